@@ -0,0 +1,131 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/containers/selinuxd/pkg/daemon"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// adoptCmd represents the adopt command
+var adoptCmd = &cobra.Command{
+	Use:   "adopt",
+	Short: "adopt the modules that were installed before selinuxd managed them",
+	Long: `Asks selinuxd to compare the installed modules with the policy files in
+the module directories, and to take over the modules that match their file
+without re-installing them.`,
+	Args: cobra.NoArgs,
+	Run:  adoptCmdFunc,
+}
+
+//nolint:gochecknoinits
+func init() {
+	rootCmd.AddCommand(adoptCmd)
+	defineAdoptFlags(adoptCmd)
+}
+
+func defineAdoptFlags(rootCmd *cobra.Command) {
+	rootCmd.Flags().String("socket-path", daemon.DefaultUnixSockAddr, "the path where the selinuxd socket is listening at")
+}
+
+func parseAdoptFlags(rootCmd *cobra.Command) (*daemon.SelinuxdOptions, error) {
+	var config daemon.SelinuxdOptions
+	var err error
+
+	config.Path, err = rootCmd.Flags().GetString("socket-path")
+	if err != nil {
+		return nil, fmt.Errorf("failed getting socket-path flag: %w", err)
+	}
+
+	return &config, nil
+}
+
+func adoptCmdFunc(rootCmd *cobra.Command, _ []string) {
+	opts, err := parseAdoptFlags(rootCmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parsing flags: %s", err)
+		syscall.Exit(1)
+	}
+
+	httpc := getHTTPClient(opts.Path)
+
+	// Adopting doesn't install policies, but it waits for the pending
+	// operations to be applied
+	ctx, cancel := context.WithTimeout(context.Background(), defaultReconcileTimeout)
+	defer cancel()
+
+	adopturl := baseStatusServerURL + "/adopt/"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", adopturl, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Forming adopt request: %s", err)
+		syscall.Exit(1)
+	}
+
+	response, err := httpc.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Requesting adoption: %s", err)
+		syscall.Exit(1)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		buf := new(strings.Builder)
+		if _, err := io.Copy(buf, response.Body); err != nil {
+			fmt.Fprintf(os.Stderr, "Decoding adopt error response: %s", err)
+			syscall.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Adoption failed: %s", buf.String())
+		syscall.Exit(1)
+	}
+
+	var report daemon.AdoptReport
+	err = json.NewDecoder(response.Body).Decode(&report)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Decoding adopt response: %s", err)
+		syscall.Exit(1)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Policy", "Result"})
+	for _, policy := range report.Adopted {
+		table.Append([]string{policy, "adopted"})
+	}
+	for _, policy := range report.Mismatched {
+		table.Append([]string{policy, "mismatched"})
+	}
+	for _, policy := range report.Rejected {
+		table.Append([]string{policy, "rejected"})
+	}
+	for _, policy := range report.Failed {
+		table.Append([]string{policy, "failed"})
+	}
+	table.Render()
+
+	if len(report.Failed) > 0 {
+		syscall.Exit(1)
+	}
+}
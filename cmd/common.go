@@ -22,13 +22,17 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/containers/selinuxd/pkg/admission"
+	"github.com/containers/selinuxd/pkg/daemon"
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/signature"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
+	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
 
 const (
-	defaultModulePath   = "/etc/selinux.d"
 	defaultTimeout      = 10 * time.Second
 	baseStatusServerURL = "http://unix"
 )
@@ -59,3 +63,155 @@ func getHTTPClient(sockpath string) *http.Client {
 		},
 	}
 }
+
+func defineScanFlags(rootCmd *cobra.Command) {
+	rootCmd.Flags().Bool("follow-symlinks", false,
+		"install the policies that symlinks point to. Needed for Kubernetes ConfigMap and Secret volumes.")
+}
+
+func parseScanFlags(rootCmd *cobra.Command) (daemon.ScanOptions, error) {
+	var opts daemon.ScanOptions
+	var err error
+
+	opts.FollowSymlinks, err = rootCmd.Flags().GetBool("follow-symlinks")
+	if err != nil {
+		return opts, fmt.Errorf("failed getting follow-symlinks flag: %w", err)
+	}
+
+	return opts, nil
+}
+
+func defineModuleDirFlags(rootCmd *cobra.Command) {
+	rootCmd.Flags().StringSlice("module-dir", []string{daemon.DefaultModulePath},
+		"a directory to install policies from, as path[:priority]. Can be repeated. "+
+			"Policies in directories with a higher priority override the ones with the same name.")
+}
+
+func parseModuleDirFlags(rootCmd *cobra.Command) (daemon.ModuleDirs, error) {
+	specs, err := rootCmd.Flags().GetStringSlice("module-dir")
+	if err != nil {
+		return nil, fmt.Errorf("failed getting module-dir flag: %w", err)
+	}
+
+	dirs := make(daemon.ModuleDirs, 0, len(specs))
+	for _, spec := range specs {
+		md, err := daemon.ParseModuleDir(spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing module-dir flag: %w", err)
+		}
+		dirs = append(dirs, md)
+	}
+
+	return dirs, nil
+}
+
+func defineOwnershipFlags(rootCmd *cobra.Command) {
+	rootCmd.Flags().Bool("overwrite-unowned", false,
+		"install over, and remove, modules that selinuxd didn't install, e.g. the ones shipped by the distribution.")
+	rootCmd.Flags().Bool("adopt-existing", false,
+		"on startup, take over the installed modules that match their policy file instead of refusing them.")
+}
+
+func parseOwnershipFlags(rootCmd *cobra.Command) (daemon.OwnershipOptions, error) {
+	var opts daemon.OwnershipOptions
+	var err error
+
+	opts.OverwriteUnowned, err = rootCmd.Flags().GetBool("overwrite-unowned")
+	if err != nil {
+		return opts, fmt.Errorf("failed getting overwrite-unowned flag: %w", err)
+	}
+
+	opts.AdoptExisting, err = rootCmd.Flags().GetBool("adopt-existing")
+	if err != nil {
+		return opts, fmt.Errorf("failed getting adopt-existing flag: %w", err)
+	}
+
+	return opts, nil
+}
+
+func defineAdmissionFlags(rootCmd *cobra.Command) {
+	rootCmd.Flags().String("admission-rules", "",
+		"a JSON file with the rules that policies are checked against before installing them. "+
+			"By default, every policy is admitted.")
+	rootCmd.Flags().String("signature-keys", "",
+		"a directory with the PEM encoded ed25519 or ECDSA public keys that policies must be signed with. "+
+			"By default, signatures aren't required.")
+}
+
+func parseAdmissionFlags(rootCmd *cobra.Command) (daemon.AdmissionOptions, error) {
+	var opts daemon.AdmissionOptions
+
+	path, err := rootCmd.Flags().GetString("admission-rules")
+	if err != nil {
+		return opts, fmt.Errorf("failed getting admission-rules flag: %w", err)
+	}
+	if path != "" {
+		opts.Rules, err = admission.Load(path)
+		if err != nil {
+			return opts, fmt.Errorf("failed loading admission rules: %w", err)
+		}
+	}
+
+	keysDir, err := rootCmd.Flags().GetString("signature-keys")
+	if err != nil {
+		return opts, fmt.Errorf("failed getting signature-keys flag: %w", err)
+	}
+	if keysDir != "" {
+		opts.Keys, err = signature.LoadKeySet(keysDir)
+		if err != nil {
+			return opts, fmt.Errorf("failed loading signature keys: %w", err)
+		}
+	}
+
+	return opts, nil
+}
+
+func defineHistoryFlags(rootCmd *cobra.Command) {
+	rootCmd.Flags().Int("history-max-entries", datastore.DefaultHistoryMaxEntries,
+		"how many entries to keep in the history of each policy. 0 keeps every entry.")
+	rootCmd.Flags().Duration("history-max-age", datastore.DefaultHistoryMaxAge,
+		"how long to keep the entries in the history of each policy for. 0 keeps them forever.")
+}
+
+func parseHistoryFlags(rootCmd *cobra.Command) (datastore.HistoryRetention, error) {
+	var retention datastore.HistoryRetention
+	var err error
+
+	retention.MaxEntries, err = rootCmd.Flags().GetInt("history-max-entries")
+	if err != nil {
+		return retention, fmt.Errorf("failed getting history-max-entries flag: %w", err)
+	}
+
+	retention.MaxAge, err = rootCmd.Flags().GetDuration("history-max-age")
+	if err != nil {
+		return retention, fmt.Errorf("failed getting history-max-age flag: %w", err)
+	}
+
+	return retention, nil
+}
+
+func defineDataStoreFlags(rootCmd *cobra.Command) {
+	rootCmd.Flags().String("datastore", datastore.DefaultURI,
+		"the URI of the policy data store: bolt://<path>, json://<path> or memory://")
+	rootCmd.Flags().String("datastore-path", datastore.DefaultDataStorePath, "The path to the policy data store")
+	//nolint:errcheck // the flag was just defined
+	rootCmd.Flags().MarkDeprecated("datastore-path", "use --datastore bolt://<path> instead")
+}
+
+// parseDataStoreFlags returns the URI of the datastore. The deprecated
+// `--datastore-path` is honored if it's the only one given.
+func parseDataStoreFlags(rootCmd *cobra.Command) (string, error) {
+	uri, err := rootCmd.Flags().GetString("datastore")
+	if err != nil {
+		return "", fmt.Errorf("failed getting datastore flag: %w", err)
+	}
+	if rootCmd.Flags().Changed("datastore") || !rootCmd.Flags().Changed("datastore-path") {
+		return uri, nil
+	}
+
+	path, err := rootCmd.Flags().GetString("datastore-path")
+	if err != nil {
+		return "", fmt.Errorf("failed getting datastore-path flag: %w", err)
+	}
+	return "bolt://" + path, nil
+}
@@ -22,7 +22,6 @@ import (
 	"syscall"
 
 	"github.com/containers/selinuxd/pkg/daemon"
-	"github.com/containers/selinuxd/pkg/datastore"
 	"github.com/containers/selinuxd/pkg/semodule"
 	"github.com/containers/selinuxd/pkg/version"
 	"github.com/spf13/cobra"
@@ -51,8 +50,19 @@ func defineFlags(rootCmd *cobra.Command) {
 	rootCmd.Flags().String("socket-path", daemon.DefaultUnixSockAddr, "The path to the socket to listen at")
 	rootCmd.Flags().Int("socket-uid", 0, "The user owner of the status HTTP socket")
 	rootCmd.Flags().Int("socket-gid", 0, "The group owner of the status HTTP socket")
-	rootCmd.Flags().String("datastore-path", datastore.DefaultDataStorePath, "The path to the policy data store")
+	defineDataStoreFlags(rootCmd)
 	rootCmd.Flags().Bool("enable-profiling", false, "whether to enable or not profiling endpoints in the status server.")
+	rootCmd.Flags().Duration("reconcile-interval", daemon.DefaultReconcileInterval,
+		"how often to reconcile the module directory with the installed policies. 0 disables it.")
+	rootCmd.Flags().Duration("debounce-window", daemon.DefaultDebounceWindow,
+		"how long to wait for file events to settle before applying the policy operations.")
+	rootCmd.Flags().Int("max-install-attempts", daemon.DefaultMaxInstallAttempts,
+		"how many times to attempt installing a policy before giving up. 1 disables retries.")
+	defineScanFlags(rootCmd)
+	defineModuleDirFlags(rootCmd)
+	defineOwnershipFlags(rootCmd)
+	defineAdmissionFlags(rootCmd)
+	defineHistoryFlags(rootCmd)
 }
 
 func parseFlags(rootCmd *cobra.Command) (*daemon.SelinuxdOptions, error) {
@@ -74,9 +84,9 @@ func parseFlags(rootCmd *cobra.Command) (*daemon.SelinuxdOptions, error) {
 		return nil, fmt.Errorf("failed getting socket-path flag: %w", err)
 	}
 
-	config.StatusDBPath, err = rootCmd.Flags().GetString("datastore-path")
+	config.StatusDBPath, err = parseDataStoreFlags(rootCmd)
 	if err != nil {
-		return nil, fmt.Errorf("failed getting datastore-path flag: %w", err)
+		return nil, err
 	}
 
 	config.EnableProfiling, err = rootCmd.Flags().GetBool("enable-profiling")
@@ -84,6 +94,42 @@ func parseFlags(rootCmd *cobra.Command) (*daemon.SelinuxdOptions, error) {
 		return nil, fmt.Errorf("failed getting enable-profiling flag: %w", err)
 	}
 
+	config.ReconcileInterval, err = rootCmd.Flags().GetDuration("reconcile-interval")
+	if err != nil {
+		return nil, fmt.Errorf("failed getting reconcile-interval flag: %w", err)
+	}
+
+	config.DebounceWindow, err = rootCmd.Flags().GetDuration("debounce-window")
+	if err != nil {
+		return nil, fmt.Errorf("failed getting debounce-window flag: %w", err)
+	}
+
+	config.RetryOptions = daemon.DefaultRetryOptions()
+	config.MaxAttempts, err = rootCmd.Flags().GetInt("max-install-attempts")
+	if err != nil {
+		return nil, fmt.Errorf("failed getting max-install-attempts flag: %w", err)
+	}
+
+	config.ScanOptions, err = parseScanFlags(rootCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	config.OwnershipOptions, err = parseOwnershipFlags(rootCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	config.AdmissionOptions, err = parseAdmissionFlags(rootCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	config.HistoryRetention, err = parseHistoryFlags(rootCmd)
+	if err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
 
@@ -100,19 +146,26 @@ func daemonCmdFunc(rootCmd *cobra.Command, _ []string) {
 		syscall.Exit(1)
 	}
 
+	dirs, err := parseModuleDirFlags(rootCmd)
+	if err != nil {
+		logger.Error(err, "Parsing flags")
+		syscall.Exit(1)
+	}
+
 	version.PrintInfoPermissive(logger)
 
 	exitSignal := make(chan os.Signal, 1)
 	done := make(chan bool)
 	signal.Notify(exitSignal, syscall.SIGINT, syscall.SIGTERM)
 
-	sh, err := semodule.NewSemoduleHandler(true, logger)
+	// NOTE: The daemon commits the policy operations in batches
+	sh, err := semodule.NewSemoduleHandler(false, logger)
 	if err != nil {
 		logger.Error(err, "Creating semodule handler")
 	}
 	defer sh.Close()
 
-	go daemon.Daemon(options, defaultModulePath, sh, nil, done, logger)
+	go daemon.Daemon(options, dirs, sh, nil, done, logger)
 
 	<-exitSignal
 	logger.Info("Exit signal received")
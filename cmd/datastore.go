@@ -0,0 +1,316 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/containers/selinuxd/pkg/daemon"
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// datastoreCmd groups the commands that work on the datastore directly
+var datastoreCmd = &cobra.Command{
+	Use:   "datastore",
+	Short: "inspect and repair the selinuxd datastore",
+	Long: `These commands open the datastore directly, instead of asking the daemon.
+As the daemon locks its datastore, it needs to be stopped first.`,
+}
+
+var datastoreExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "dump the datastore as JSON",
+	Long: `Writes every policy status and history entry in the datastore as JSON.
+Entries that can't be read are reported, and left out of the dump. The
+datastore is opened read-only: it must exist, and be up to date.`,
+	Args: cobra.NoArgs,
+	Run:  datastoreExportCmdFunc,
+}
+
+var datastoreImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "restore the datastore from a JSON dump",
+	Long: `Replaces the contents of the datastore with the ones of a dump made with
+'selinuxdctl datastore export'. Use '-' to read the dump from stdin.`,
+	Args: cobra.ExactArgs(1),
+	Run:  datastoreImportCmdFunc,
+}
+
+var datastoreCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "compare the datastore with the policy files and the installed modules",
+	Long: `Reports the policy files that the datastore doesn't track, the entries
+whose file is gone or changed, and the modules that selinuxd installed but
+aren't installed anymore. Nothing is repaired: the datastore is opened
+read-only, so it must exist, and be up to date.`,
+	Args: cobra.NoArgs,
+	Run:  datastoreCheckCmdFunc,
+}
+
+var datastoreRebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "regenerate the datastore from the policy files and the installed modules",
+	Long: `Drops every policy status in the datastore, and adopts the installed
+modules that match their policy file, if the policy file passes the
+signature and admission checks. The history is kept. The daemon
+installs the rest of the policies once it starts. If the datastore is too
+damaged to be opened, remove its file first.`,
+	Args: cobra.NoArgs,
+	Run:  datastoreRebuildCmdFunc,
+}
+
+//nolint:gochecknoinits
+func init() {
+	rootCmd.AddCommand(datastoreCmd)
+	datastoreCmd.AddCommand(datastoreExportCmd, datastoreImportCmd, datastoreCheckCmd, datastoreRebuildCmd)
+
+	defineDataStoreFlags(datastoreExportCmd)
+	datastoreExportCmd.Flags().StringP("output", "o", "-", "the file to write the dump to. '-' is stdout.")
+
+	defineDataStoreFlags(datastoreImportCmd)
+
+	defineDataStoreFlags(datastoreCheckCmd)
+	defineScanFlags(datastoreCheckCmd)
+	defineModuleDirFlags(datastoreCheckCmd)
+
+	defineDataStoreFlags(datastoreRebuildCmd)
+	defineScanFlags(datastoreRebuildCmd)
+	defineModuleDirFlags(datastoreRebuildCmd)
+	defineHistoryFlags(datastoreRebuildCmd)
+	defineAdmissionFlags(datastoreRebuildCmd)
+}
+
+// openDataStore opens the datastore given by the flags of `rootCmd`, or exits
+func openDataStore(rootCmd *cobra.Command) datastore.DataStore {
+	uri, err := parseDataStoreFlags(rootCmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parsing flags: %s\n", err)
+		syscall.Exit(1)
+	}
+	ds, err := datastore.Open(uri)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Opening datastore: %s\n", err)
+		syscall.Exit(1)
+	}
+	return ds
+}
+
+// openReadOnlyDataStore opens the existing datastore given by the flags of
+// `rootCmd` without writing to it, or exits
+func openReadOnlyDataStore(rootCmd *cobra.Command) datastore.ReadOnlyDataStore {
+	uri, err := parseDataStoreFlags(rootCmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parsing flags: %s\n", err)
+		syscall.Exit(1)
+	}
+	ds, err := datastore.OpenReadOnly(uri)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Opening datastore: %s\n", err)
+		syscall.Exit(1)
+	}
+	return ds
+}
+
+func datastoreExportCmdFunc(rootCmd *cobra.Command, _ []string) {
+	output, err := rootCmd.Flags().GetString("output")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parsing flags: failed getting output flag: %s\n", err)
+		syscall.Exit(1)
+	}
+
+	ds := openReadOnlyDataStore(rootCmd)
+	defer ds.Close()
+
+	dump, exportErr := datastore.Export(ds)
+	if dump == nil {
+		fmt.Fprintf(os.Stderr, "Exporting datastore: %s\n", exportErr)
+		syscall.Exit(1)
+	}
+
+	out := os.Stdout
+	if output != "-" {
+		out, err = os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Creating dump: %s\n", err)
+			syscall.Exit(1)
+		}
+		defer out.Close()
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(dump); err != nil {
+		fmt.Fprintf(os.Stderr, "Writing dump: %s\n", err)
+		syscall.Exit(1)
+	}
+
+	if exportErr != nil {
+		fmt.Fprintf(os.Stderr, "Some entries couldn't be exported: %s\n", exportErr)
+		syscall.Exit(1)
+	}
+}
+
+func datastoreImportCmdFunc(rootCmd *cobra.Command, args []string) {
+	in := os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Opening dump: %s\n", err)
+			syscall.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var dump datastore.Dump
+	if err := json.NewDecoder(in).Decode(&dump); err != nil {
+		fmt.Fprintf(os.Stderr, "Decoding dump: %s\n", err)
+		syscall.Exit(1)
+	}
+
+	ds := openDataStore(rootCmd)
+	defer ds.Close()
+
+	if err := datastore.Import(ds, &dump); err != nil {
+		fmt.Fprintf(os.Stderr, "Importing dump: %s\n", err)
+		syscall.Exit(1)
+	}
+	fmt.Printf("Imported %d policies and the history of %d\n", len(dump.Policies), len(dump.History))
+}
+
+func datastoreCheckCmdFunc(rootCmd *cobra.Command, _ []string) {
+	opts, dirs, err := parseOfflineFlags(rootCmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parsing flags: %s\n", err)
+		syscall.Exit(1)
+	}
+
+	logger, err := getLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		syscall.Exit(1)
+	}
+	sh, err := semodule.NewSemoduleHandler(false, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Creating semodule handler: %s\n", err)
+		syscall.Exit(1)
+	}
+	defer sh.Close()
+
+	ds := openReadOnlyDataStore(rootCmd)
+	defer ds.Close()
+
+	issues, err := daemon.CheckDataStore(dirs, opts, sh, ds)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Checking datastore: %s\n", err)
+		syscall.Exit(1)
+	}
+
+	if len(issues) == 0 {
+		fmt.Println("The datastore is consistent")
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Policy", "Problem", "Detail"})
+	for _, issue := range issues {
+		table.Append([]string{issue.Policy, string(issue.Problem), issue.Detail})
+	}
+	table.Render()
+	syscall.Exit(1)
+}
+
+func datastoreRebuildCmdFunc(rootCmd *cobra.Command, _ []string) {
+	opts, dirs, err := parseOfflineFlags(rootCmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parsing flags: %s\n", err)
+		syscall.Exit(1)
+	}
+	retention, err := parseHistoryFlags(rootCmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parsing flags: %s\n", err)
+		syscall.Exit(1)
+	}
+	admission, err := parseAdmissionFlags(rootCmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parsing flags: %s\n", err)
+		syscall.Exit(1)
+	}
+
+	logger, err := getLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		syscall.Exit(1)
+	}
+	sh, err := semodule.NewSemoduleHandler(false, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Creating semodule handler: %s\n", err)
+		syscall.Exit(1)
+	}
+	defer sh.Close()
+
+	ds := openDataStore(rootCmd)
+	defer ds.Close()
+
+	report, err := daemon.RebuildDataStore(dirs, opts, admission, sh, ds, retention)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Rebuilding datastore: %s\n", err)
+		syscall.Exit(1)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Policy", "Result"})
+	for _, policy := range report.Kept {
+		table.Append([]string{policy, "kept"})
+	}
+	for _, policy := range report.Adopted {
+		table.Append([]string{policy, "adopted"})
+	}
+	for _, policy := range report.Mismatched {
+		table.Append([]string{policy, "mismatched"})
+	}
+	for _, policy := range report.Rejected {
+		table.Append([]string{policy, "rejected"})
+	}
+	for _, policy := range report.Failed {
+		table.Append([]string{policy, "failed"})
+	}
+	table.Render()
+
+	if len(report.Failed) > 0 {
+		syscall.Exit(1)
+	}
+}
+
+// parseOfflineFlags parses the flags that tell the commands working on the
+// datastore where the policy files are
+func parseOfflineFlags(rootCmd *cobra.Command) (daemon.ScanOptions, daemon.ModuleDirs, error) {
+	opts, err := parseScanFlags(rootCmd)
+	if err != nil {
+		return opts, nil, err
+	}
+	dirs, err := parseModuleDirFlags(rootCmd)
+	if err != nil {
+		return opts, nil, err
+	}
+	return opts, dirs, nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containers/selinuxd/pkg/daemon"
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history <policy>",
+	Args:  cobra.ExactArgs(1),
+	Short: "Get the history of a policy",
+	Long: `This gets the actions that were taken on the given policy, oldest
+first. The history is kept after the policy is removed.`,
+	Run: historyCmdFunc,
+}
+
+//nolint:gochecknoinits
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	defineHistoryCmdFlags(historyCmd)
+}
+
+func defineHistoryCmdFlags(rootCmd *cobra.Command) {
+	rootCmd.Flags().String("socket-path", daemon.DefaultUnixSockAddr, "the path where the selinuxd socket is listening at")
+}
+
+func parseHistoryCmdFlags(rootCmd *cobra.Command) (*daemon.SelinuxdOptions, error) {
+	var config daemon.SelinuxdOptions
+	var err error
+
+	config.Path, err = rootCmd.Flags().GetString("socket-path")
+	if err != nil {
+		return nil, fmt.Errorf("failed getting socket-path flag: %w", err)
+	}
+
+	return &config, nil
+}
+
+func historyCmdFunc(rootCmd *cobra.Command, args []string) {
+	opts, err := parseHistoryCmdFlags(rootCmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parsing flags: %s", err)
+		syscall.Exit(1)
+	}
+
+	httpc := getHTTPClient(opts.Path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	historyurl := baseStatusServerURL + "/policies/" + url.PathEscape(args[0]) + "/history"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", historyurl, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Forming history query: %s", err)
+		syscall.Exit(1)
+	}
+
+	response, err := httpc.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Querying policy history: %s", err)
+		syscall.Exit(1)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		buf := new(strings.Builder)
+		if _, err := io.Copy(buf, response.Body); err != nil {
+			fmt.Fprintf(os.Stderr, "Decoding policy history error response: %s", err)
+			syscall.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Getting policy history failed: %s", buf.String())
+		syscall.Exit(1)
+	}
+
+	var history []datastore.HistoryEntry
+	err = json.NewDecoder(response.Body).Decode(&history)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Decoding policy history response: %s", err)
+		syscall.Exit(1)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Time", "Action", "Status", "Checksum", "Message"})
+	for _, entry := range history {
+		table.Append([]string{
+			entry.Time.Format(time.RFC3339),
+			string(entry.Action),
+			string(entry.Status),
+			shortChecksum(entry.Checksum),
+			entry.Message,
+		})
+	}
+	table.Render()
+}
+
+// shortChecksum abbreviates checksums, as the full ones don't fit a table
+func shortChecksum(cs datastore.Checksum) string {
+	const shortLen = 12
+	if s := cs.String(); len(s) > shortLen {
+		return s[:shortLen]
+	}
+	return cs.String()
+}
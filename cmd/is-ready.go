@@ -43,10 +43,19 @@ func init() {
 
 func defineIsReadyFlags(rootCmd *cobra.Command) {
 	rootCmd.Flags().String("socket-path", daemon.DefaultUnixSockAddr, "the path where the selinuxd socket is listening at")
+	rootCmd.Flags().Bool("strict", false,
+		"don't report ready while any of the policies found on startup failed to install")
+	rootCmd.Flags().Bool("verbose", false, "print the number of installed, failed and pending policies")
 }
 
-func parseIsReadyFlags(rootCmd *cobra.Command) (*daemon.SelinuxdOptions, error) {
-	var config daemon.SelinuxdOptions
+type isReadyOptions struct {
+	daemon.SelinuxdOptions
+	strict  bool
+	verbose bool
+}
+
+func parseIsReadyFlags(rootCmd *cobra.Command) (*isReadyOptions, error) {
+	var config isReadyOptions
 	var err error
 
 	config.Path, err = rootCmd.Flags().GetString("socket-path")
@@ -54,6 +63,16 @@ func parseIsReadyFlags(rootCmd *cobra.Command) (*daemon.SelinuxdOptions, error)
 		return nil, fmt.Errorf("failed getting socket-path flag: %w", err)
 	}
 
+	config.strict, err = rootCmd.Flags().GetBool("strict")
+	if err != nil {
+		return nil, fmt.Errorf("failed getting strict flag: %w", err)
+	}
+
+	config.verbose, err = rootCmd.Flags().GetBool("verbose")
+	if err != nil {
+		return nil, fmt.Errorf("failed getting verbose flag: %w", err)
+	}
+
 	return &config, nil
 }
 
@@ -70,6 +89,9 @@ func isreadyCmdFunc(rootCmd *cobra.Command, args []string) {
 	defer cancel()
 
 	readyurl := baseStatusServerURL + "/ready/"
+	if opts.strict {
+		readyurl += "?strict=true"
+	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", readyurl, nil)
 	if err != nil {
@@ -84,16 +106,21 @@ func isreadyCmdFunc(rootCmd *cobra.Command, args []string) {
 	}
 	defer response.Body.Close()
 
-	var status map[string]bool
+	var status daemon.ReadyStatus
 	err = json.NewDecoder(response.Body).Decode(&status)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Decoding ready endpoint response: %s", err)
 		syscall.Exit(1)
 	}
 
-	if status["ready"] {
+	if status.Ready {
 		fmt.Fprint(os.Stdout, "yes")
 	} else {
 		fmt.Fprint(os.Stdout, "no")
 	}
+
+	if opts.verbose {
+		fmt.Fprintf(os.Stdout, " (installed: %d, failed: %d, pending: %d)",
+			status.Installed, status.Failed, status.Pending)
+	}
 }
@@ -0,0 +1,143 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/containers/selinuxd/pkg/lint"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+const (
+	lintOutputText  = "text"
+	lintOutputJSON  = "json"
+	lintOutputSARIF = "sarif"
+)
+
+var errInvalidLintOutput = errors.New("invalid output format, valid formats: text json sarif")
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint <files...>",
+	Short: "check policy files before deploying them",
+	Long: `Checks policy files against the rules that selinuxd applies when
+installing them: their extension and module name, the CIL syntax, duplicate
+module names, their size and, if given, the admission rules and the
+signatures. Module names are
+only duplicates if the directories given with --module-dir install them at
+the same priority. Directories are
+checked recursively, skipping the files that selinuxd skips. It doesn't need
+SELinux on the machine, and exits with a non-zero code if any error is found.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  lintCmdFunc,
+}
+
+//nolint:gochecknoinits
+func init() {
+	rootCmd.AddCommand(lintCmd)
+	defineLintFlags(lintCmd)
+}
+
+func defineLintFlags(rootCmd *cobra.Command) {
+	rootCmd.Flags().StringP("output", "o", lintOutputText, "the output format: text, json or sarif")
+	defineAdmissionFlags(rootCmd)
+	defineModuleDirFlags(rootCmd)
+}
+
+func parseLintFlags(rootCmd *cobra.Command) (lint.Options, string, error) {
+	var opts lint.Options
+	var err error
+
+	output, err := rootCmd.Flags().GetString("output")
+	if err != nil {
+		return opts, "", fmt.Errorf("failed getting output flag: %w", err)
+	}
+	switch output {
+	case lintOutputText, lintOutputJSON, lintOutputSARIF:
+	default:
+		return opts, "", fmt.Errorf("%w: %s", errInvalidLintOutput, output)
+	}
+
+	admissionOpts, err := parseAdmissionFlags(rootCmd)
+	if err != nil {
+		return opts, "", err
+	}
+	opts.Admission = admissionOpts.Rules
+	opts.Keys = admissionOpts.Keys
+
+	dirs, err := parseModuleDirFlags(rootCmd)
+	if err != nil {
+		return opts, "", err
+	}
+	for _, md := range dirs {
+		opts.ModuleDirs = append(opts.ModuleDirs, lint.ModuleDir{Path: md.Path, Priority: md.Priority})
+	}
+
+	return opts, output, nil
+}
+
+func lintCmdFunc(rootCmd *cobra.Command, args []string) {
+	opts, output, err := parseLintFlags(rootCmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parsing flags: %s", err)
+		syscall.Exit(1)
+	}
+
+	findings, err := lint.Lint(args, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Linting policies: %s", err)
+		syscall.Exit(1)
+	}
+
+	switch output {
+	case lintOutputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(findings)
+	case lintOutputSARIF:
+		err = lint.WriteSARIF(os.Stdout, findings)
+	default:
+		printLintTable(findings)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Writing results: %s", err)
+		syscall.Exit(1)
+	}
+
+	if lint.HasErrors(findings) {
+		syscall.Exit(1)
+	}
+}
+
+func printLintTable(findings []lint.Finding) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"File", "Line", "Severity", "Rule", "Message"})
+	for _, f := range findings {
+		line := ""
+		if f.Line > 0 {
+			line = strconv.Itoa(f.Line) + ":" + strconv.Itoa(f.Column)
+		}
+		table.Append([]string{f.Path, line, string(f.Severity), f.Rule, f.Message})
+	}
+	table.Render()
+}
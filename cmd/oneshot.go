@@ -16,6 +16,7 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"syscall"
@@ -44,32 +45,70 @@ func init() {
 }
 
 func defineOneShotFlags(rootCmd *cobra.Command) {
-	rootCmd.Flags().String("datastore-path", datastore.DefaultDataStorePath, "The path to the policy data store")
+	defineDataStoreFlags(rootCmd)
+	defineScanFlags(rootCmd)
+	defineModuleDirFlags(rootCmd)
+	defineOwnershipFlags(rootCmd)
+	defineAdmissionFlags(rootCmd)
+	defineHistoryFlags(rootCmd)
 }
 
 func parseOneShotFlags(rootCmd *cobra.Command) (*daemon.SelinuxdOptions, error) {
 	var config daemon.SelinuxdOptions
 	var err error
 
-	config.StatusDBPath, err = rootCmd.Flags().GetString("datastore-path")
+	config.StatusDBPath, err = parseDataStoreFlags(rootCmd)
 	if err != nil {
-		return nil, fmt.Errorf("failed getting datastore-path flag: %w", err)
+		return nil, err
+	}
+
+	config.ScanOptions, err = parseScanFlags(rootCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	config.OwnershipOptions, err = parseOwnershipFlags(rootCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	config.AdmissionOptions, err = parseAdmissionFlags(rootCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	config.HistoryRetention, err = parseHistoryFlags(rootCmd)
+	if err != nil {
+		return nil, err
 	}
 
 	return &config, nil
 }
 
-func tryInstallAllPolicies(sh seiface.Handler, ds datastore.DataStore, logger logr.Logger) {
-	policyops := make(chan daemon.PolicyAction)
+func installAllPolicies(ctx context.Context, dirs daemon.ModuleDirs, opts *daemon.SelinuxdOptions, sh seiface.Handler,
+	ds datastore.DataStore, logger logr.Logger,
+) {
+	if opts.AdoptExisting {
+		daemon.AdoptExistingPolicies(ctx, dirs, opts.ScanOptions, opts.AdmissionOptions, sh, ds, logger)
+	}
+
+	policyops := daemon.NewActionQueue(daemon.DefaultDebounceWindow)
 
-	go func() {
-		if err := daemon.InstallPoliciesInDir(defaultModulePath, policyops, nil); err != nil {
-			logger.Error(err, "Installing policies in module directory")
+	for _, md := range dirs {
+		if err := daemon.InstallPoliciesInDir(md.Path, opts.ScanOptions, policyops, nil); err != nil {
+			logger.Error(err, "Installing policies in module directory", "directory", md.Path)
 		}
-		close(policyops)
-	}()
+	}
+	if err := daemon.RemoveOrphanedPolicies(dirs, opts.ScanOptions, ds.GetReadOnly(), policyops); err != nil {
+		logger.Error(err, "Removing orphaned policies")
+	}
+	policyops.ShutDown()
 
-	daemon.InstallPolicies(defaultModulePath, sh, ds, policyops, logger)
+	// NOTE: The policies are applied in a single commit, falling back
+	// to a policy-per-policy install if that fails. Failed installs
+	// aren't retried, since we exit right after.
+	daemon.InstallPolicies(ctx, dirs, sh, ds, policyops, daemon.RetryOptions{}, opts.OwnershipOptions,
+		opts.AdmissionOptions, opts.HistoryRetention, logger)
 }
 
 func oneshotCmdFunc(rootCmd *cobra.Command, _ []string) {
@@ -87,13 +126,19 @@ func oneshotCmdFunc(rootCmd *cobra.Command, _ []string) {
 		syscall.Exit(1)
 	}
 
+	dirs, err := parseModuleDirFlags(rootCmd)
+	if err != nil {
+		logger.Error(err, "Parsing flags")
+		syscall.Exit(1)
+	}
+
 	sh, err := semodule.NewSemoduleHandler(false, logger)
 	if err != nil {
 		logger.Error(err, "Creating semodule handler")
 	}
 	defer sh.Close()
 
-	ds, err := datastore.New(opts.StatusDBPath)
+	ds, err := datastore.Open(opts.StatusDBPath)
 	if err != nil {
 		logger.Error(err, "Unable to get R/W datastore")
 	}
@@ -101,16 +146,7 @@ func oneshotCmdFunc(rootCmd *cobra.Command, _ []string) {
 
 	logger.Info("Running oneshot command")
 
-	tryInstallAllPolicies(sh, ds, logger)
-
-	if err := sh.Commit(); err != nil {
-		logger.Info("Unable to install policies in one commit. " +
-			"This is most likely due to a policy being wrongly formatted. " +
-			"Will attempt to install each policy individually.")
-		// Do longer policy-per-policy install
-		sh.SetAutoCommit(true)
-		tryInstallAllPolicies(sh, ds, logger)
-	}
+	installAllPolicies(rootCmd.Context(), dirs, opts, sh, ds, logger)
 
 	logger.Info("Done installing policies in directory")
 }
@@ -0,0 +1,129 @@
+/*
+Copyright © 2020 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containers/selinuxd/pkg/daemon"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// reconciling may imply installing policies, which can take a while
+const defaultReconcileTimeout = 5 * time.Minute
+
+// reconcileCmd represents the reconcile command
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "trigger a reconciliation pass",
+	Long: `Asks selinuxd to compare the module directory, its datastore and the
+installed modules, and to repair any drift between them.`,
+	Args: cobra.NoArgs,
+	Run:  reconcileCmdFunc,
+}
+
+//nolint:gochecknoinits
+func init() {
+	rootCmd.AddCommand(reconcileCmd)
+	defineReconcileFlags(reconcileCmd)
+}
+
+func defineReconcileFlags(rootCmd *cobra.Command) {
+	rootCmd.Flags().String("socket-path", daemon.DefaultUnixSockAddr, "the path where the selinuxd socket is listening at")
+}
+
+func parseReconcileFlags(rootCmd *cobra.Command) (*daemon.SelinuxdOptions, error) {
+	var config daemon.SelinuxdOptions
+	var err error
+
+	config.Path, err = rootCmd.Flags().GetString("socket-path")
+	if err != nil {
+		return nil, fmt.Errorf("failed getting socket-path flag: %w", err)
+	}
+
+	return &config, nil
+}
+
+func reconcileCmdFunc(rootCmd *cobra.Command, _ []string) {
+	opts, err := parseReconcileFlags(rootCmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parsing flags: %s", err)
+		syscall.Exit(1)
+	}
+
+	httpc := getHTTPClient(opts.Path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultReconcileTimeout)
+	defer cancel()
+
+	reconcileurl := baseStatusServerURL + "/reconcile/"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", reconcileurl, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Forming reconcile request: %s", err)
+		syscall.Exit(1)
+	}
+
+	response, err := httpc.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Requesting reconciliation: %s", err)
+		syscall.Exit(1)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		buf := new(strings.Builder)
+		if _, err := io.Copy(buf, response.Body); err != nil {
+			fmt.Fprintf(os.Stderr, "Decoding reconcile error response: %s", err)
+			syscall.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Reconciliation failed: %s", buf.String())
+		syscall.Exit(1)
+	}
+
+	var report daemon.ReconcileReport
+	err = json.NewDecoder(response.Body).Decode(&report)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Decoding reconcile response: %s", err)
+		syscall.Exit(1)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Policy", "Action"})
+	for _, policy := range report.Installed {
+		table.Append([]string{policy, "installed"})
+	}
+	for _, policy := range report.Removed {
+		table.Append([]string{policy, "removed"})
+	}
+	for _, policy := range report.Failed {
+		table.Append([]string{policy, "failed"})
+	}
+	table.Render()
+
+	if len(report.Failed) > 0 {
+		syscall.Exit(1)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"syscall"
 
@@ -121,15 +122,23 @@ func handleList(table *tablewriter.Table, response *http.Response) {
 func handleSinglePolicy(table *tablewriter.Table, response *http.Response) {
 	table.SetHeader([]string{"Key", "Value"})
 	if response.StatusCode == http.StatusOK {
-		var moduleStatus map[string]string
-		err := json.NewDecoder(response.Body).Decode(&moduleStatus)
+		var moduleStatus map[string]interface{}
+		// Numbers are shown as they are, instead of as floats
+		dec := json.NewDecoder(response.Body)
+		dec.UseNumber()
+		err := dec.Decode(&moduleStatus)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Decoding policy status response: %s", err)
 			syscall.Exit(1)
 		}
 
-		for key, value := range moduleStatus {
-			table.Append([]string{key, value})
+		keys := make([]string, 0, len(moduleStatus))
+		for key := range moduleStatus {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			table.Append([]string{key, fmt.Sprint(moduleStatus[key])})
 		}
 		return
 	}
@@ -0,0 +1,481 @@
+// Package admission checks policies against a set of rules before they're
+// installed, so that writing to the module directory doesn't grant any
+// permission at will.
+package admission
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/containers/selinuxd/pkg/cil"
+	"github.com/containers/selinuxd/pkg/utils"
+)
+
+var ErrInvalidRules = errors.New("invalid admission rules")
+
+// The identifiers of the checks that violations are reported for
+const (
+	CheckForbiddenAllow      = "forbidden-allow"
+	CheckPrivilegedAttribute = "privileged-attribute"
+	CheckForbiddenTemplate   = "forbidden-template"
+	CheckUncheckedFormat     = "unchecked-format"
+	CheckClassMapping        = "class-mapping"
+	CheckForbiddenStatement  = "forbidden-statement"
+	// CheckUnresolvedClassPermission is reported for the allow statements
+	// that forbidden allow rules might match, but whose class permissions
+	// can't be known, e.g. a set defined in another policy or a macro
+	// parameter.
+	CheckUnresolvedClassPermission = "unresolved-classpermission"
+	// CheckUnresolvedType is reported for the allow statements that
+	// forbidden allow rules might match, but whose source or target can't
+	// be known, e.g. a macro parameter, or an attribute whose members are
+	// an expression. It's also reported for the types that the rules
+	// refer to being added to attributes of other policies, as those
+	// policies might hold the allow statements.
+	CheckUnresolvedType = "unresolved-type"
+)
+
+// AllowRule matches `allow` statements. Empty fields match anything.
+type AllowRule struct {
+	Source string `json:"source,omitempty"`
+	Target string `json:"target,omitempty"`
+	Class  string `json:"class,omitempty"`
+	// Permissions matches the statements that grant any of them
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// Rules are the rules that policies are admitted with
+type Rules struct {
+	// ForbiddenAllows are the `allow` statements that policies can't have
+	ForbiddenAllows []AllowRule `json:"forbiddenAllows,omitempty"`
+	// PrivilegedAttributes are the attributes that policies can't add
+	// types to with `typeattributeset`, e.g. `unconfined_domain_type`
+	PrivilegedAttributes []string `json:"privilegedAttributes,omitempty"`
+	// ForbiddenTemplates are the blocks that policies can't inherit
+	// with `blockinherit`
+	ForbiddenTemplates []string `json:"forbiddenTemplates,omitempty"`
+	// ForbiddenStatements are the CIL statements that policies can't use,
+	// e.g. `typepermissive`, or `policycap` that configures the whole policy
+	ForbiddenStatements []string `json:"forbiddenStatements,omitempty"`
+	// AllowUnchecked admits the policies that the rules can't be checked
+	// on, i.e. module packages and type-enforcement sources. They're
+	// denied otherwise.
+	AllowUnchecked bool `json:"allowUnchecked,omitempty"`
+}
+
+// Load reads the rules from a JSON file
+func Load(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading admission rules: %w", err)
+	}
+	var rules Rules
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidRules, path, err)
+	}
+	for _, ar := range rules.ForbiddenAllows {
+		if ar.Source == "" && ar.Target == "" && ar.Class == "" && len(ar.Permissions) == 0 {
+			return nil, fmt.Errorf("%w: %s: forbidden allow rules must match something", ErrInvalidRules, path)
+		}
+	}
+	return &rules, nil
+}
+
+// Violation is a statement that the rules deny
+type Violation struct {
+	Check string
+	Pos   cil.Pos
+	// Statement is the offending statement
+	Statement string
+}
+
+func (v Violation) String() string {
+	if v.Pos.Line == 0 {
+		return v.Check + ": " + v.Statement
+	}
+	return fmt.Sprintf("line %s: %s: %s", v.Pos, v.Check, v.Statement)
+}
+
+// Check returns the statements of the policy in `path` that the rules deny.
+// Policies that aren't CIL are denied unless AllowUnchecked is set.
+func (r *Rules) Check(path string) ([]Violation, error) {
+	if utils.PolicyLanguage(path) != "cil" {
+		return r.CheckPolicy(path, nil)
+	}
+	data, err := utils.ReadPolicy(path)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // ReadPolicy already adds context
+	}
+	return r.CheckPolicy(path, data)
+}
+
+// CheckPolicy is like Check, on the module `data` that was read from the
+// policy file in `path`. The daemon checks the exact module it installs.
+func (r *Rules) CheckPolicy(path string, data []byte) ([]Violation, error) {
+	if utils.PolicyLanguage(path) != "cil" {
+		if r.AllowUnchecked {
+			return nil, nil
+		}
+		return []Violation{{
+			Check:     CheckUncheckedFormat,
+			Statement: "the admission rules can only be checked on CIL policies",
+		}}, nil
+	}
+	stmts, err := cil.Validate(data)
+	if err != nil {
+		return nil, fmt.Errorf("checking admission rules on %s: %w", path, err)
+	}
+	return r.CheckCIL(stmts), nil
+}
+
+// CheckCIL returns the statements that the rules deny. Statements in
+// macros are checked too, as they can be called from other policies.
+// Class maps are denied along with forbidden allow rules, as allow
+// statements on a class map grant the permissions it's mapped to, which
+// might be mapped by another policy.
+func (r *Rules) CheckCIL(stmts []*cil.Node) []Violation {
+	sets := classPermissionSets(stmts)
+	types := newTypeResolver(stmts)
+	params := macroParameters(stmts)
+	violations := make([]Violation, 0)
+	cil.Walk(stmts, func(stmt *cil.Node) {
+		var denied bool
+		var check string
+		switch stmt.Keyword() {
+		case "allow":
+			check = r.checkAllow(stmt, sets, types, params[stmt])
+			denied = check != ""
+		case "typeattributeset":
+			check = CheckPrivilegedAttribute
+			denied = len(stmt.Children) > 1 && matchesName(r.PrivilegedAttributes, stmt.Children[1])
+			if !denied && r.addsRuleTypeToForeignAttribute(stmt, types) {
+				check = CheckUnresolvedType
+				denied = true
+			}
+		case "blockinherit":
+			check = CheckForbiddenTemplate
+			denied = len(stmt.Children) > 1 && matchesName(r.ForbiddenTemplates, stmt.Children[1])
+		case "classmap", "classmapping":
+			check = CheckClassMapping
+			denied = len(r.ForbiddenAllows) > 0
+		}
+		if !denied && slices.Contains(r.ForbiddenStatements, stmt.Keyword()) {
+			check = CheckForbiddenStatement
+			denied = true
+		}
+		if denied {
+			violations = append(violations, Violation{Check: check, Pos: stmt.Pos, Statement: stmt.String()})
+		}
+	})
+	return violations
+}
+
+// symbolName strips the dot that refers to the global namespace
+func symbolName(n *cil.Node) string {
+	if n.IsList() {
+		return ""
+	}
+	return strings.TrimPrefix(n.Atom, ".")
+}
+
+func matchesName(names []string, n *cil.Node) bool {
+	return slices.Contains(names, symbolName(n))
+}
+
+// classPermissions are the permissions that a statement grants on a class.
+// `all` is set if the permissions are an expression that might grant any.
+type classPermissions struct {
+	class string
+	perms []string
+	all   bool
+}
+
+// classPermissionSets returns the named class permissions defined with
+// `classpermissionset`. A set can be defined by several statements, which
+// add up. The sets that a statement can't be parsed for map to nil, as
+// they're as unknown as the ones defined in other policies.
+func classPermissionSets(stmts []*cil.Node) map[string][]classPermissions {
+	sets := make(map[string][]classPermissions)
+	unresolved := make(map[string]bool)
+	cil.Walk(stmts, func(stmt *cil.Node) {
+		if stmt.Keyword() != "classpermissionset" || len(stmt.Children) != 3 {
+			return
+		}
+		name := symbolName(stmt.Children[1])
+		cps, ok := parseClassPermissions(stmt.Children[2], nil)
+		if !ok {
+			unresolved[name] = true
+		}
+		sets[name] = append(sets[name], cps...)
+	})
+	for name := range unresolved {
+		sets[name] = nil
+	}
+	return sets
+}
+
+// parseClassPermissions parses a `(class (permissions...))` expression, or
+// the name of a class permission set.
+func parseClassPermissions(n *cil.Node, sets map[string][]classPermissions) ([]classPermissions, bool) {
+	if !n.IsList() {
+		cps := sets[symbolName(n)]
+		return cps, cps != nil
+	}
+	if len(n.Children) != 2 || n.Children[0].IsList() {
+		return nil, false
+	}
+	cp := classPermissions{class: symbolName(n.Children[0])}
+	var collect func(e *cil.Node)
+	collect = func(e *cil.Node) {
+		if !e.IsList() {
+			switch e.Atom {
+			case "all", "not":
+				cp.all = true
+			case "and", "or", "xor":
+			default:
+				cp.perms = append(cp.perms, e.Atom)
+			}
+			return
+		}
+		for _, child := range e.Children {
+			collect(child)
+		}
+	}
+	collect(n.Children[1])
+	return []classPermissions{cp}, true
+}
+
+// checkAllow returns the check that denies the
+// `(allow source target classpermissions)` statement, if any. The statement
+// is denied if any of the forbidden allow rules matches it, or might match
+// it while its source, target or class permissions can't be resolved.
+// `params` are the parameters of the macro that holds the statement.
+func (r *Rules) checkAllow(stmt *cil.Node, sets map[string][]classPermissions, types *typeResolver,
+	params map[string]bool,
+) string {
+	if len(stmt.Children) != 4 {
+		return ""
+	}
+	source, sourceKnown := types.resolve(stmt.Children[1], params)
+	target, targetKnown := source, sourceKnown
+	if symbolName(stmt.Children[2]) != "self" {
+		target, targetKnown = types.resolve(stmt.Children[2], params)
+	}
+	cps, cpsKnown := parseClassPermissions(stmt.Children[3], sets)
+	if params[symbolName(stmt.Children[3])] {
+		cpsKnown = false
+	}
+
+	var unresolvedTypes, unresolvedPerms bool
+	for _, ar := range r.ForbiddenAllows {
+		sourceMatches, sourceUnknown := matchesType(ar.Source, source, sourceKnown)
+		targetMatches, targetUnknown := matchesType(ar.Target, target, targetKnown)
+		if (!sourceMatches && !sourceUnknown) || (!targetMatches && !targetUnknown) {
+			continue
+		}
+		switch {
+		case !cpsKnown:
+			unresolvedPerms = true
+		case !slices.ContainsFunc(cps, ar.matches):
+		case sourceUnknown || targetUnknown:
+			unresolvedTypes = true
+		default:
+			return CheckForbiddenAllow
+		}
+	}
+	switch {
+	case unresolvedPerms:
+		return CheckUnresolvedClassPermission
+	case unresolvedTypes:
+		return CheckUnresolvedType
+	}
+	return ""
+}
+
+// matchesType tells whether the rule's type matches the types that a
+// statement's source or target can be, or whether it can't be known
+func matchesType(ruleType string, types []string, known bool) (matches, unknown bool) {
+	switch {
+	case ruleType == "" || slices.Contains(types, ruleType):
+		return true, false
+	case !known:
+		return false, true
+	}
+	return false, false
+}
+
+// addsRuleTypeToForeignAttribute tells whether the `typeattributeset`
+// statement adds a type that the forbidden allow rules refer to to an
+// attribute that the policy doesn't declare. The allow statements on the
+// attribute are in another policy, so they can't be checked.
+func (r *Rules) addsRuleTypeToForeignAttribute(stmt *cil.Node, types *typeResolver) bool {
+	if len(stmt.Children) != 3 || types.attributes[symbolName(stmt.Children[1])] {
+		return false
+	}
+	members, _ := types.expression(stmt.Children[2])
+	for _, member := range members {
+		resolved, _ := types.resolve(&cil.Node{Atom: member}, nil)
+		for _, ar := range r.ForbiddenAllows {
+			if (ar.Source != "" && slices.Contains(resolved, ar.Source)) ||
+				(ar.Target != "" && slices.Contains(resolved, ar.Target)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// typeResolver resolves the names that allow statements refer to into the
+// types they might stand for, through the attributes and aliases that the
+// policy defines. Names that the policy doesn't define are types, or
+// attributes, of other policies, and stand for themselves.
+type typeResolver struct {
+	// attributes are the attributes that the policy declares
+	attributes map[string]bool
+	// members are the names added to attributes with `typeattributeset`
+	members map[string][]string
+	// aliases map the aliases that the policy declares to their actual
+	// type, or to "" until it's set with `typealiasactual`
+	aliases map[string]string
+	// unknown are the names whose types can't be known, e.g. attributes
+	// whose members are an expression with `not` or `all`
+	unknown map[string]bool
+}
+
+func newTypeResolver(stmts []*cil.Node) *typeResolver {
+	tr := &typeResolver{
+		attributes: make(map[string]bool),
+		members:    make(map[string][]string),
+		aliases:    make(map[string]string),
+		unknown:    make(map[string]bool),
+	}
+	cil.Walk(stmts, func(stmt *cil.Node) {
+		switch stmt.Keyword() {
+		case "typeattribute":
+			if len(stmt.Children) == 2 {
+				tr.attributes[symbolName(stmt.Children[1])] = true
+			}
+		case "typealias":
+			if len(stmt.Children) == 2 {
+				name := symbolName(stmt.Children[1])
+				if _, ok := tr.aliases[name]; !ok {
+					tr.aliases[name] = ""
+				}
+			}
+		case "typealiasactual":
+			if len(stmt.Children) == 3 {
+				tr.aliases[symbolName(stmt.Children[1])] = symbolName(stmt.Children[2])
+			}
+		}
+	})
+	cil.Walk(stmts, func(stmt *cil.Node) {
+		if stmt.Keyword() != "typeattributeset" || len(stmt.Children) != 3 {
+			return
+		}
+		name := symbolName(stmt.Children[1])
+		members, known := tr.expression(stmt.Children[2])
+		tr.members[name] = append(tr.members[name], members...)
+		if !known {
+			tr.unknown[name] = true
+		}
+	})
+	return tr
+}
+
+// expression returns the names in a `typeattributeset` expression. It
+// returns false if the expression might stand for types that aren't named.
+func (tr *typeResolver) expression(n *cil.Node) ([]string, bool) {
+	if !n.IsList() {
+		return []string{symbolName(n)}, true
+	}
+	names := make([]string, 0)
+	known := true
+	for _, child := range n.Children {
+		if !child.IsList() {
+			switch child.Atom {
+			case "all", "not":
+				known = false
+			case "and", "or", "xor":
+			default:
+				names = append(names, symbolName(child))
+			}
+			continue
+		}
+		childNames, childKnown := tr.expression(child)
+		names = append(names, childNames...)
+		known = known && childKnown
+	}
+	return names, known
+}
+
+// resolve returns the types that the name in `n` might stand for, including
+// the name itself. It returns false if they can't all be known, e.g. for
+// the macro parameters in `params`.
+func (tr *typeResolver) resolve(n *cil.Node, params map[string]bool) ([]string, bool) {
+	if n.IsList() {
+		return nil, false
+	}
+	types := make([]string, 0)
+	known := true
+	seen := make(map[string]bool)
+	pending := []string{symbolName(n)}
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		types = append(types, name)
+		if params[name] || tr.unknown[name] {
+			known = false
+		}
+		if actual, ok := tr.aliases[name]; ok {
+			if actual == "" {
+				known = false
+			} else {
+				pending = append(pending, actual)
+			}
+		}
+		pending = append(pending, tr.members[name]...)
+	}
+	return types, known
+}
+
+// macroParameters maps the statements in macros to the names of the
+// parameters of their macro
+func macroParameters(stmts []*cil.Node) map[*cil.Node]map[string]bool {
+	params := make(map[*cil.Node]map[string]bool)
+	cil.Walk(stmts, func(stmt *cil.Node) {
+		if stmt.Keyword() != "macro" || len(stmt.Children) < 3 || !stmt.Children[2].IsList() {
+			return
+		}
+		names := make(map[string]bool)
+		for _, param := range stmt.Children[2].Children {
+			if param.IsList() && len(param.Children) == 2 {
+				names[symbolName(param.Children[1])] = true
+			}
+		}
+		cil.Walk(stmt.Children[3:], func(nested *cil.Node) {
+			params[nested] = names
+		})
+	})
+	return params
+}
+
+// matches tells whether the rule matches the permissions granted on a class
+func (ar AllowRule) matches(cp classPermissions) bool {
+	if ar.Class != "" && ar.Class != cp.class {
+		return false
+	}
+	return len(ar.Permissions) == 0 || cp.all || slices.ContainsFunc(cp.perms, func(p string) bool {
+		return slices.Contains(ar.Permissions, p)
+	})
+}
@@ -0,0 +1,188 @@
+package admission
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/selinuxd/pkg/cil"
+)
+
+const testRules = `{
+	"forbiddenAllows": [
+		{"class": "security", "permissions": ["load_policy", "setenforce"]},
+		{"target": "shadow_t", "class": "file", "permissions": ["write", "append"]},
+		{"source": "container_t", "target": "shadow_t", "class": "file"}
+	],
+	"privilegedAttributes": ["unconfined_domain_type"],
+	"forbiddenTemplates": ["unconfined_template"],
+	"forbiddenStatements": ["typepermissive", "policycap"]
+}`
+
+func loadTestRules(t *testing.T) *Rules {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(testRules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading the rules: %s", err)
+	}
+	return rules
+}
+
+func TestLoad(t *testing.T) {
+	rules := loadTestRules(t)
+	if len(rules.ForbiddenAllows) != 3 || rules.AllowUnchecked {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	invalid := []string{
+		`{"forbiddenAllow": []}`,
+		`{"forbiddenAllows": [{}]}`,
+		`{"privilegedAttributes": "unconfined_domain_type"}`,
+	}
+	for _, data := range invalid {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); !errors.Is(err, ErrInvalidRules) {
+			t.Errorf("expected %s to be invalid, got: %v", data, err)
+		}
+	}
+}
+
+func TestCheckCIL(t *testing.T) {
+	rules := loadTestRules(t)
+
+	denied := []struct {
+		data  string
+		check string
+		pos   cil.Pos
+	}{
+		{"(allow app_t self (security (load_policy)))", CheckForbiddenAllow, cil.Pos{Line: 1, Column: 1}},
+		{"(allow app_t security_t (security (all)))", CheckForbiddenAllow, cil.Pos{Line: 1, Column: 1}},
+		{"(allow app_t security_t (security (not (read))))", CheckForbiddenAllow, cil.Pos{Line: 1, Column: 1}},
+		{"(type app_t)\n(allow app_t .shadow_t (file (read write)))", CheckForbiddenAllow, cil.Pos{Line: 2, Column: 1}},
+		{"(typeattributeset unconfined_domain_type (app_t))", CheckPrivilegedAttribute, cil.Pos{Line: 1, Column: 1}},
+		{"(block app\n  (blockinherit .unconfined_template))", CheckForbiddenTemplate, cil.Pos{Line: 2, Column: 3}},
+		{"(macro m ((type t))\n  (allow t shadow_t (file (append))))", CheckForbiddenAllow, cil.Pos{Line: 2, Column: 3}},
+		{
+			"(booleanif b (true\n  (allow a_t self (security (setenforce)))))",
+			CheckForbiddenAllow, cil.Pos{Line: 2, Column: 3},
+		},
+		{
+			"(classpermissionset cps (security (load_policy)))\n(allow a_t self cps)",
+			CheckForbiddenAllow, cil.Pos{Line: 2, Column: 1},
+		},
+		{
+			"(classpermissionset cps (file (read)))\n(classpermissionset cps (security (setenforce)))\n(allow a_t self cps)",
+			CheckForbiddenAllow, cil.Pos{Line: 3, Column: 1},
+		},
+		{"(allow app_t self other_module_set)", CheckUnresolvedClassPermission, cil.Pos{Line: 1, Column: 1}},
+		{
+			"(classpermission cp)\n(allow app_t self cp)",
+			CheckUnresolvedClassPermission, cil.Pos{Line: 2, Column: 1},
+		},
+		{
+			"(macro m ((type t) (classpermission cp))\n  (allow t self cp))",
+			CheckUnresolvedClassPermission, cil.Pos{Line: 2, Column: 3},
+		},
+		{
+			"(typeattribute a)\n(typeattributeset a (container_t))\n(allow a shadow_t (file (read)))",
+			CheckForbiddenAllow, cil.Pos{Line: 3, Column: 1},
+		},
+		{
+			"(typeattribute a)\n(typeattribute b)\n(typeattributeset b (container_t))\n(typeattributeset a (b))\n" +
+				"(allow a shadow_t (file (read)))",
+			CheckForbiddenAllow, cil.Pos{Line: 5, Column: 1},
+		},
+		{
+			"(typealias x)\n(typealiasactual x container_t)\n(allow x shadow_t (file (read)))",
+			CheckForbiddenAllow, cil.Pos{Line: 3, Column: 1},
+		},
+		{
+			"(macro m ((type t))\n  (allow t shadow_t (file (read))))\n(call m (container_t))",
+			CheckUnresolvedType, cil.Pos{Line: 2, Column: 3},
+		},
+		{
+			"(typeattribute a)\n(typeattributeset a (not app_t))\n(allow a shadow_t (file (read)))",
+			CheckUnresolvedType, cil.Pos{Line: 3, Column: 1},
+		},
+		{"(typealias x)\n(allow x shadow_t (file (read)))", CheckUnresolvedType, cil.Pos{Line: 2, Column: 1}},
+		{"(typeattributeset other_attr (container_t))", CheckUnresolvedType, cil.Pos{Line: 1, Column: 1}},
+		{"(classmap cmap (load))", CheckClassMapping, cil.Pos{Line: 1, Column: 1}},
+		{"(classmapping cmap load (security (load_policy)))", CheckClassMapping, cil.Pos{Line: 1, Column: 1}},
+		{"(block b\n\t(type b_t)\n\t(typepermissive b_t))", CheckForbiddenStatement, cil.Pos{Line: 3, Column: 2}},
+		{"(policycap open_perms)", CheckForbiddenStatement, cil.Pos{Line: 1, Column: 1}},
+	}
+	for _, expected := range denied {
+		data := expected.data
+		stmts, err := cil.Validate([]byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %s", data, err)
+		}
+		violations := rules.CheckCIL(stmts)
+		if len(violations) != 1 {
+			t.Errorf("expected a single violation for %q, got: %+v", data, violations)
+			continue
+		}
+		if violations[0].Check != expected.check || violations[0].Pos != expected.pos {
+			t.Errorf("expected a %s violation at %s for %q, got: %s", expected.check, expected.pos, data, violations[0])
+		}
+	}
+
+	admitted := []string{
+		"(allow app_t self (security (read)))",
+		"(allow app_t shadow_t (file (read)))",
+		"(allow app_t other_t (file (write)))",
+		"(typeattributeset domain (app_t))",
+		"(blockinherit container)",
+		"(typeattribute a)\n(typeattributeset a (app_t))\n(allow a shadow_t (file (read)))",
+		"(macro m ((type t))\n  (allow t other_t (file (read))))",
+	}
+	for _, data := range admitted {
+		expectAdmitted(t, rules, data)
+	}
+
+	// Class permissions that can't be resolved are only denied if a
+	// forbidden allow rule might match the statement
+	rules = &Rules{ForbiddenAllows: []AllowRule{{Target: "shadow_t", Class: "file"}}}
+	expectAdmitted(t, rules, "(allow app_t other_t other_module_set)")
+}
+
+func expectAdmitted(t *testing.T, rules *Rules, data string) {
+	t.Helper()
+	stmts, err := cil.Validate([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error parsing %q: %s", data, err)
+	}
+	if violations := rules.CheckCIL(stmts); len(violations) != 0 {
+		t.Errorf("expected %q to be admitted, got: %+v", data, violations)
+	}
+}
+
+func TestCheckUnchecked(t *testing.T) {
+	rules := loadTestRules(t)
+	path := filepath.Join(t.TempDir(), "module.pp")
+	if err := os.WriteFile(path, []byte("binary"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	violations, err := rules.Check(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(violations) != 1 || violations[0].Check != CheckUncheckedFormat {
+		t.Fatalf("expected the module package to be denied, got: %+v", violations)
+	}
+
+	rules.AllowUnchecked = true
+	violations, err = rules.Check(path)
+	if err != nil || len(violations) != 0 {
+		t.Fatalf("expected the module package to be admitted, got: %+v, %v", violations, err)
+	}
+}
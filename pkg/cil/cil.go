@@ -0,0 +1,188 @@
+// Package cil parses policies written in the Common Intermediate Language
+// into a syntax tree, so that malformed policies can be caught before they
+// reach the policy store.
+package cil
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrSyntax = errors.New("invalid CIL syntax")
+
+// Pos is a position in a CIL policy. Lines and columns start at 1.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// SyntaxError describes where and why a policy couldn't be parsed
+type SyntaxError struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return ErrSyntax
+}
+
+// Node is either an atom, a quoted string or a list of nodes
+type Node struct {
+	Pos Pos
+	// Atom holds the symbol, or the contents of a quoted string. It's
+	// empty for lists.
+	Atom string
+	// Quoted tells whether the atom is a quoted string
+	Quoted bool
+	// Children holds the elements of a list. It's nil for atoms.
+	Children []*Node
+}
+
+// IsList tells whether the node is a list
+func (n *Node) IsList() bool {
+	return n.Children != nil
+}
+
+// Keyword returns the atom that a list starts with, e.g. `type` for
+// `(type foo_t)`, or an empty string if there's none.
+func (n *Node) Keyword() string {
+	if len(n.Children) == 0 || n.Children[0].IsList() || n.Children[0].Quoted {
+		return ""
+	}
+	return n.Children[0].Atom
+}
+
+// String renders the node back into CIL, on a single line
+func (n *Node) String() string {
+	if !n.IsList() {
+		if n.Quoted {
+			return `"` + n.Atom + `"`
+		}
+		return n.Atom
+	}
+	var sb strings.Builder
+	sb.WriteByte('(')
+	for i, child := range n.Children {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(child.String())
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+// Parse parses the statements of a CIL policy. Errors are *SyntaxError.
+func Parse(data []byte) ([]*Node, error) {
+	p := &parser{data: data, pos: Pos{Line: 1, Column: 1}}
+	return p.parse()
+}
+
+type parser struct {
+	data []byte
+	off  int
+	pos  Pos
+}
+
+func (p *parser) errorf(pos Pos, format string, args ...any) error {
+	return &SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// advance moves past the current byte, keeping track of the position
+func (p *parser) advance() {
+	if p.data[p.off] == '\n' {
+		p.pos.Line++
+		p.pos.Column = 1
+	} else {
+		p.pos.Column++
+	}
+	p.off++
+}
+
+func (p *parser) parse() ([]*Node, error) {
+	// The open lists, the innermost one last
+	stack := []*Node{{Children: []*Node{}}}
+	for p.off < len(p.data) {
+		c := p.data[p.off]
+		start := p.pos
+		switch {
+		case c == ';':
+			for p.off < len(p.data) && p.data[p.off] != '\n' {
+				p.advance()
+			}
+		case c == '(':
+			list := &Node{Pos: start, Children: []*Node{}}
+			top := stack[len(stack)-1]
+			top.Children = append(top.Children, list)
+			stack = append(stack, list)
+			p.advance()
+		case c == ')':
+			if len(stack) == 1 {
+				return nil, p.errorf(start, "unexpected ')'")
+			}
+			stack = stack[:len(stack)-1]
+			p.advance()
+		case c == '"':
+			atom, err := p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			top := stack[len(stack)-1]
+			top.Children = append(top.Children, atom)
+		case isSpace(c):
+			p.advance()
+		default:
+			top := stack[len(stack)-1]
+			top.Children = append(top.Children, p.symbol())
+		}
+	}
+	if len(stack) != 1 {
+		open := stack[len(stack)-1]
+		return nil, p.errorf(open.Pos, "unterminated list, missing ')'")
+	}
+	return stack[0].Children, nil
+}
+
+func (p *parser) quoted() (*Node, error) {
+	start := p.pos
+	p.advance()
+	from := p.off
+	for p.off < len(p.data) && p.data[p.off] != '"' {
+		if p.data[p.off] == '\n' {
+			return nil, p.errorf(start, "unterminated quoted string")
+		}
+		p.advance()
+	}
+	if p.off == len(p.data) {
+		return nil, p.errorf(start, "unterminated quoted string")
+	}
+	atom := &Node{Pos: start, Atom: string(p.data[from:p.off]), Quoted: true}
+	p.advance()
+	return atom, nil
+}
+
+func (p *parser) symbol() *Node {
+	start := p.pos
+	from := p.off
+	for p.off < len(p.data) && !isDelimiter(p.data[p.off]) {
+		p.advance()
+	}
+	return &Node{Pos: start, Atom: string(p.data[from:p.off])}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isDelimiter(c byte) bool {
+	return isSpace(c) || c == '(' || c == ')' || c == ';' || c == '"'
+}
@@ -0,0 +1,102 @@
+package cil
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte(`; A comment (with parentheses)
+(block web
+    (type web_t)
+    (filecon "/srv/web(/.*)?" any (system_u object_r web_t ((s0) (s0)))))
+`)
+	nodes, err := Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(nodes) != 1 || nodes[0].Keyword() != "block" {
+		t.Fatalf("expected a single block, got: %+v", nodes)
+	}
+	block := nodes[0]
+	if block.Pos != (Pos{Line: 2, Column: 1}) {
+		t.Errorf("unexpected position of the block: %s", block.Pos)
+	}
+	if len(block.Children) != 4 {
+		t.Fatalf("expected the block to have 4 elements, got: %d", len(block.Children))
+	}
+	typ := block.Children[2]
+	if typ.Keyword() != "type" || typ.Pos != (Pos{Line: 3, Column: 5}) {
+		t.Errorf("unexpected type statement: %+v at %s", typ, typ.Pos)
+	}
+	path := block.Children[3].Children[1]
+	if !path.Quoted || path.Atom != "/srv/web(/.*)?" || path.Pos != (Pos{Line: 4, Column: 14}) {
+		t.Errorf("unexpected quoted string: %+v at %s", path, path.Pos)
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	tests := map[string]struct {
+		data string
+		pos  Pos
+	}{
+		"unterminated list": {
+			data: "(type a_t)\n(type b_t\n(type c_t)\n",
+			pos:  Pos{Line: 2, Column: 1},
+		},
+		"unexpected parenthesis": {
+			data: "(type a_t))\n",
+			pos:  Pos{Line: 1, Column: 11},
+		},
+		"unterminated string": {
+			data: "(filecon \"/srv any context)\n",
+			pos:  Pos{Line: 1, Column: 10},
+		},
+		"statement without parentheses": {
+			data: "(type a_t)\n  type b_t\n",
+			pos:  Pos{Line: 2, Column: 3},
+		},
+		"empty statement": {
+			data: "(block b\n\t()\n)\n",
+			pos:  Pos{Line: 2, Column: 2},
+		},
+		"statement starting with a list": {
+			data: "(optional o\n\t((type a_t))\n)\n",
+			pos:  Pos{Line: 2, Column: 3},
+		},
+		"invalid statement in a conditional": {
+			data: "(booleanif b (true (allow a b (file (read)))) (false allow))\n",
+			pos:  Pos{Line: 1, Column: 54},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Validate([]byte(tc.data))
+			if !errors.Is(err, ErrSyntax) {
+				t.Fatalf("expected a syntax error, got: %v", err)
+			}
+			var serr *SyntaxError
+			if !errors.As(err, &serr) {
+				t.Fatalf("expected a *SyntaxError, got: %T", err)
+			}
+			if serr.Pos != tc.pos {
+				t.Errorf("expected the error at %s, got: %s", tc.pos, err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := []string{
+		"",
+		"; only a comment\n",
+		"(macro m () (allow a b (file (read))))",
+		"(in after web (type extra_t))",
+		"(tunableif t (true (allow a b (file (read)))) (false (allow a c (file (read)))))",
+	}
+	for _, data := range valid {
+		if _, err := Validate([]byte(data)); err != nil {
+			t.Errorf("expected %q to be valid, got: %s", data, err)
+		}
+	}
+}
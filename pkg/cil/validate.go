@@ -0,0 +1,99 @@
+package cil
+
+// containers maps the statements that hold other statements to the index
+// of the first one. `in` might be qualified with `before` or `after`, and
+// `booleanif` and `tunableif` hold them in their `true` and `false` lists.
+var containers = map[string]int{
+	"block":    2,
+	"optional": 2,
+	"in":       2,
+	"macro":    3,
+	"true":     1,
+	"false":    1,
+}
+
+// conditionals hold `true` and `false` lists, starting at the index of
+// conditionalBranches
+var conditionals = map[string]bool{
+	"booleanif": true,
+	"tunableif": true,
+}
+
+const conditionalBranches = 2
+
+// Validate parses a CIL policy and checks that its statements are well
+// formed: every statement is a list that starts with a keyword. It doesn't
+// check the meaning of the statements, that's left to the compiler.
+func Validate(data []byte) ([]*Node, error) {
+	nodes, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateStatements(nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func validateStatements(stmts []*Node) error {
+	for _, stmt := range stmts {
+		if err := validateStatement(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateStatement(stmt *Node) error {
+	if !stmt.IsList() {
+		return &SyntaxError{Pos: stmt.Pos, Msg: "expected a statement, got '" + stmt.Atom + "'"}
+	}
+	keyword := stmt.Keyword()
+	if keyword == "" {
+		if len(stmt.Children) == 0 {
+			return &SyntaxError{Pos: stmt.Pos, Msg: "empty statement"}
+		}
+		return &SyntaxError{Pos: stmt.Children[0].Pos, Msg: "statements must start with a keyword"}
+	}
+	nested, ok := nestedStatements(stmt)
+	if !ok {
+		return &SyntaxError{Pos: stmt.Pos, Msg: "incomplete '" + keyword + "' statement"}
+	}
+	return validateStatements(nested)
+}
+
+// nestedStatements returns the statements held by a container statement.
+// It returns false if the statement is too short to hold any.
+func nestedStatements(stmt *Node) ([]*Node, bool) {
+	keyword := stmt.Keyword()
+	first, ok := containers[keyword]
+	if conditionals[keyword] {
+		first, ok = conditionalBranches, true
+	}
+	if !ok {
+		return nil, true
+	}
+	if keyword == "in" && len(stmt.Children) > 1 {
+		if q := stmt.Children[1]; !q.IsList() && (q.Atom == "before" || q.Atom == "after") {
+			first++
+		}
+	}
+	if len(stmt.Children) < first {
+		return nil, false
+	}
+	return stmt.Children[first:], true
+}
+
+// Walk calls `fn` for each statement of a validated policy, including the
+// ones nested in blocks, macros, optionals and conditionals.
+func Walk(stmts []*Node, fn func(stmt *Node)) {
+	for _, stmt := range stmts {
+		if !stmt.IsList() {
+			continue
+		}
+		fn(stmt)
+		if nested, ok := nestedStatements(stmt); ok {
+			Walk(nested, fn)
+		}
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"os"
+	"slices"
+	"syscall"
+	"time"
 
 	"github.com/containers/selinuxd/pkg/datastore"
 	seiface "github.com/containers/selinuxd/pkg/semodule/interface"
@@ -12,62 +16,190 @@ import (
 
 type PolicyAction interface {
 	String() string
-	do(modulePath string, sh seiface.Handler, ds datastore.DataStore) (string, error)
+	// key identifies the file the action applies to, or the policy if
+	// it's known by name only. Pending actions with the same key supersede
+	// each other. See ActionQueue.
+	key() string
+	// affectedPolicy is the name of the policy the action applies to, if any
+	affectedPolicy() string
+	do(cfg applyConfig, sh seiface.Handler, ds datastore.DataStore) (string, error)
 }
 
 // Defines an action to be taken on a policy file on the specified path
 type policyInstall struct {
 	path string
+	// force skips the checksum comparison against the datastore,
+	// this is needed when the module went missing from the system
+	// while the datastore still thinks it's installed.
+	force bool
+	// attempt is the number of previous failed attempts to install
+	// the policy, when this is a retry.
+	attempt int
+	// src holds the policy files, once they were read for the
+	// dependency scan of the batch
+	src *policySource
 }
 
 // newInstallAction will execute the "install" action for a policy.
 func newInstallAction(path string) PolicyAction {
-	return &policyInstall{path}
+	return &policyInstall{path: path}
+}
+
+// newReinstallAction will execute the "install" action for a policy
+// even if the datastore says it's already installed.
+func newReinstallAction(path string) PolicyAction {
+	return &policyInstall{path: path, force: true}
+}
+
+// newRetryAction will retry the "install" action for a policy that
+// failed to install `attempt` times.
+func newRetryAction(path string, attempt int) PolicyAction {
+	return &policyInstall{path: path, force: true, attempt: attempt}
 }
 
 func (pi *policyInstall) String() string {
+	if pi.attempt > 0 {
+		return fmt.Sprintf("retry %d - %s", pi.attempt, pi.path)
+	}
+	if pi.force {
+		return "reinstall - " + pi.path
+	}
 	return "install - " + pi.path
 }
 
-func (pi *policyInstall) do(modulePath string, sh seiface.Handler, ds datastore.DataStore) (string, error) {
+func (pi *policyInstall) key() string {
+	return pi.path
+}
+
+func (pi *policyInstall) affectedPolicy() string {
+	return policyFromPath(pi.path)
+}
+
+// source returns the policy files, reading them unless the dependency
+// scan already did. They're read again by the next call, as the action
+// might be applied more than once.
+func (pi *policyInstall) source() (*policySource, error) {
+	if pi.src == nil {
+		src, err := readPolicySource(pi.path)
+		if err != nil {
+			return nil, err
+		}
+		pi.src = src
+	}
+	return pi.src, nil
+}
+
+func (pi *policyInstall) do(cfg applyConfig, sh seiface.Handler, ds datastore.DataStore) (string, error) {
 	policyName, err := utils.PolicyNameFromPath(pi.path)
 	if err != nil {
 		return "", fmt.Errorf("installing policy: %w", err)
 	}
 
-	cs, csErr := utils.Checksum(pi.path)
-	if csErr != nil {
-		return "", fmt.Errorf("installing policy: %w", csErr)
+	src, srcErr := pi.source()
+	pi.src = nil
+	if srcErr != nil {
+		return "", fmt.Errorf("installing policy: %w", srcErr)
 	}
+	cs := src.checksum()
 
-	p, getErr := ds.Get(policyName)
+	priority := cfg.dirs.priorityOf(pi.path)
+	p, claim, claimErr := pi.claim(policyName, priority, ds)
+	if claimErr != nil {
+		return "", claimErr
+	}
 	// If the checksums are equal, the policy is already installed
-	// and in an appropriate state
-	if getErr == nil && bytes.Equal(p.Checksum, cs) {
+	// and in an appropriate state. Rejected policies are checked again,
+	// as the admission rules might have changed since.
+	if claim == claimOwned && !pi.force && bytes.Equal(p.Checksum, cs) && p.Status != datastore.RejectedStatus {
 		return "", nil
-	} else if getErr != nil && !errors.Is(getErr, datastore.ErrPolicyNotFound) {
-		return "", fmt.Errorf("installing policy: couldn't access datastore: %w", getErr)
+	}
+	if !cfg.OverwriteUnowned && !owns(p, priority) {
+		installed, listErr := moduleInstalled(sh, policyName, priority)
+		if listErr != nil {
+			return "", pi.fail(cfg, ds, p, claim, listErr)
+		}
+		if installed {
+			return "", pi.refuse(cfg, ds, p, claim)
+		}
+	}
+	updating := ownedPriority(p) != 0
+	// Only one module is kept installed per policy. The one that's
+	// replaced takes over again if the file overriding it goes away.
+	if owned := ownedPriority(p); owned != 0 && owned != priority {
+		if err := removeModule(sh, policyName, owned); err != nil {
+			return "", fmt.Errorf("removing policy %s installed at priority %d: %w", policyName, owned, err)
+		}
+		p.OwnedPriority = 0
+		p.OwnedChecksum = nil
+	}
+	if claim == claimOverride {
+		p.Conflicts = append(p.Conflicts, p.SourcePath)
 	}
 
-	installErr := sh.Install(pi.path)
+	installErr := pi.install(cfg, sh, src, policyName, priority)
 	status := datastore.InstalledStatus
 	var msg string
+	var attempt int
 
-	if installErr != nil {
+	switch {
+	case errors.Is(installErr, errRejected):
+		status = datastore.RejectedStatus
+		msg = installErr.Error()
+	case installErr != nil:
 		status = datastore.FailedStatus
 		msg = installErr.Error()
+		attempt = pi.attempt + 1
 	}
 
-	ps := datastore.PolicyStatus{
-		Policy:   policyName,
-		Status:   status,
-		Message:  msg,
-		Checksum: cs,
+	now := time.Now()
+	ps := p
+	ps.Status = status
+	ps.Message = msg
+	ps.Checksum = cs
+	ps.Attempt = attempt
+	ps.NextRetry = nil
+	ps.LastUpdated = &now
+	ps.InstallAttempts = 1
+	if bytes.Equal(p.Checksum, cs) {
+		ps.InstallAttempts = p.InstallAttempts + 1
+	}
+	setSource(&ps, cfg.dirs, pi.path)
+	removed := false
+	// The module of a rejected policy is removed, as when the policy is
+	// revoked because the trusted keys changed. It'd be at odds with the
+	// policy's status otherwise.
+	if owned := ownedPriority(p); status == datastore.RejectedStatus && owned != 0 {
+		if err := removeModule(sh, policyName, owned); err != nil {
+			return "", fmt.Errorf("removing rejected policy %s: %w", policyName, err)
+		}
+		ps.OwnedPriority = 0
+		ps.OwnedChecksum = nil
+		removed = true
+	}
+	if installErr == nil {
+		ps.OwnedPriority = priority
+		ps.OwnedChecksum = cs
+		ps.Backend = sh.Name()
+		if ps.FirstInstalled == nil {
+			ps.FirstInstalled = &now
+		}
 	}
 	puterr := ds.Put(ps)
 	if puterr != nil {
 		return "", fmt.Errorf("failed persisting status in datastore: %w", puterr)
 	}
+	action := datastore.HistoryInstall
+	switch {
+	case removed:
+		action = datastore.HistoryRemove
+	case installErr != nil:
+		action = datastore.HistoryFailure
+	case updating:
+		action = datastore.HistoryUpdate
+	}
+	if err := recordHistory(cfg, ds, ps.Policy, historyEntry(action, ps, now)); err != nil {
+		return "", err
+	}
 
 	if installErr != nil {
 		return "", fmt.Errorf("failed executing install action: %w", installErr)
@@ -75,54 +207,485 @@ func (pi *policyInstall) do(modulePath string, sh seiface.Handler, ds datastore.
 	return "", nil
 }
 
+// install installs the module from the policy files in `src`, decompressing
+// or compiling it first if needed. The module is installed from a private
+// copy of the contents that were checked, so that changing the files in
+// the meantime doesn't get an unchecked module installed. The checksum is
+// still taken from the files as they are on disk, so that it's comparable
+// across events. Policies that aren't
+// signed by a trusted key, with invalid syntax, or that the admission rules
+// deny never reach the handler.
+func (pi *policyInstall) install(cfg applyConfig, sh seiface.Handler, src *policySource, policyName string,
+	priority uint16,
+) error {
+	// semodule would refuse the name too, but only once the batch is
+	// committed
+	if err := utils.ValidateModuleName(policyName); err != nil {
+		return err //nolint:wrapcheck // the caller adds context
+	}
+	if err := checkPolicy(cfg, src); err != nil {
+		return err
+	}
+	installPath, cleanup, err := stagePolicy(cfg, src, policyName)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	//nolint:wrapcheck // the caller adds context
+	return sh.Install(installPath, priority)
+}
+
+// checkPolicy checks that the policy files in `src` are signed by a trusted
+// key, that their syntax is valid, and that the admission rules admit them
+func checkPolicy(cfg applyConfig, src *policySource) error {
+	if err := verifySignatures(cfg.AdmissionOptions, src); err != nil {
+		return err
+	}
+	if err := validatePolicy(src); err != nil {
+		return err
+	}
+	return admit(cfg.AdmissionOptions, src)
+}
+
+var (
+	errBlocked        = errors.New("blocked by a failed dependency")
+	errPolicyConflict = errors.New("policy is already provided by another file")
+)
+
+// claimResult tells how a file relates to the policy it provides
+type claimResult int
+
+const (
+	// claimNew means that no file provides the policy
+	claimNew claimResult = iota
+	// claimOwned means that the file already provides the policy
+	claimOwned
+	// claimOverride means that the file overrides the one providing the
+	// policy, as it has a higher priority
+	claimOverride
+	// claimConflict means that another file provides the policy
+	claimConflict
+)
+
+// claim returns the datastore entry of the policy, and how the file relates
+// to it. Policies are provided by the first file that claims them, unless a
+// file with a higher priority claims them too. Other files with the same
+// policy name are refused, and recorded as conflicts in the owner's entry,
+// until the owner goes away.
+func (pi *policyInstall) claim(policyName string, priority uint16, ds datastore.DataStore,
+) (datastore.PolicyStatus, claimResult, error) {
+	p, err := ds.Get(policyName)
+	if errors.Is(err, datastore.ErrPolicyNotFound) {
+		return datastore.PolicyStatus{Policy: policyName}, claimNew, nil
+	} else if err != nil {
+		return p, claimNew, fmt.Errorf("installing policy: couldn't access datastore: %w", err)
+	}
+
+	if p.SourcePath == "" || p.SourcePath == pi.path {
+		return p, claimOwned, nil
+	}
+	p.Conflicts = slices.DeleteFunc(p.Conflicts, func(c string) bool { return c == pi.path })
+	if _, statErr := os.Stat(p.SourcePath); statErr != nil {
+		// The owner is gone, and we'll get an event for it.
+		// The policy is ours in the meantime.
+		return p, claimNew, nil
+	}
+	if priority > priorityOrDefault(p.Priority) {
+		return p, claimOverride, nil
+	}
+
+	p.Conflicts = append(p.Conflicts, pi.path)
+	if err := ds.Put(p); err != nil {
+		return p, claimConflict, fmt.Errorf("failed persisting status in datastore: %w", err)
+	}
+	return p, claimConflict, fmt.Errorf("%w: %s is provided by %s", errPolicyConflict, policyName, p.SourcePath)
+}
+
+// block records that the install can't be attempted because the policy it
+// depends on failed. The checksum isn't recorded, so that the next event
+// for the policy triggers an install.
+func (pi *policyInstall) block(cfg applyConfig, ds datastore.DataStore, dependency string) error {
+	policyName, err := utils.PolicyNameFromPath(pi.path)
+	if err != nil {
+		return fmt.Errorf("installing policy: %w", err)
+	}
+	priority := cfg.dirs.priorityOf(pi.path)
+	ps, claim, claimErr := pi.claim(policyName, priority, ds)
+	if claimErr != nil {
+		return claimErr
+	}
+	blockErr := fmt.Errorf("%w: %s", errBlocked, dependency)
+	// The policy being overridden stays in place
+	if claim == claimOverride {
+		return blockErr
+	}
+	now := time.Now()
+	ps.Status = datastore.BlockedStatus
+	ps.Message = blockErr.Error()
+	ps.Checksum = nil
+	ps.Attempt = pi.attempt + 1
+	ps.NextRetry = nil
+	ps.LastUpdated = &now
+	setSource(&ps, cfg.dirs, pi.path)
+	if err := ds.Put(ps); err != nil {
+		return fmt.Errorf("failed persisting status in datastore: %w", err)
+	}
+	if err := recordHistory(cfg, ds, ps.Policy, historyEntry(datastore.HistoryFailure, ps, now)); err != nil {
+		return err
+	}
+	return blockErr
+}
+
+// fail records that the install wasn't attempted, as the ownership of the
+// module couldn't be checked. It's retried like failed installs.
+func (pi *policyInstall) fail(cfg applyConfig, ds datastore.DataStore, ps datastore.PolicyStatus,
+	claim claimResult, reason error,
+) error {
+	failErr := fmt.Errorf("installing policy %s: %w", ps.Policy, reason)
+	// The policy being overridden stays in place
+	if claim == claimOverride {
+		return failErr
+	}
+	now := time.Now()
+	ps.Status = datastore.FailedStatus
+	ps.Message = failErr.Error()
+	ps.Checksum = nil
+	ps.Attempt = pi.attempt + 1
+	ps.NextRetry = nil
+	ps.LastUpdated = &now
+	setSource(&ps, cfg.dirs, pi.path)
+	if err := ds.Put(ps); err != nil {
+		return fmt.Errorf("failed persisting status in datastore: %w", err)
+	}
+	if err := recordHistory(cfg, ds, ps.Policy, historyEntry(datastore.HistoryFailure, ps, now)); err != nil {
+		return err
+	}
+	return failErr
+}
+
+// refuse records that the install wasn't attempted, as it would overwrite
+// a module that selinuxd doesn't own. As with blocked installs, the
+// checksum isn't recorded, so that the next event for the policy checks
+// again.
+func (pi *policyInstall) refuse(cfg applyConfig, ds datastore.DataStore, ps datastore.PolicyStatus,
+	claim claimResult,
+) error {
+	priority := cfg.dirs.priorityOf(pi.path)
+	refuseErr := fmt.Errorf("%w: %s is installed at priority %d", errNotOwned, ps.Policy, priority)
+	// The policy being overridden stays in place
+	if claim == claimOverride {
+		return refuseErr
+	}
+	now := time.Now()
+	ps.Status = datastore.RefusedStatus
+	ps.Message = refuseErr.Error()
+	ps.Checksum = nil
+	ps.Attempt = 0
+	ps.NextRetry = nil
+	ps.LastUpdated = &now
+	setSource(&ps, cfg.dirs, pi.path)
+	if err := ds.Put(ps); err != nil {
+		return fmt.Errorf("failed persisting status in datastore: %w", err)
+	}
+	if err := recordHistory(cfg, ds, ps.Policy, historyEntry(datastore.HistoryFailure, ps, now)); err != nil {
+		return err
+	}
+	return refuseErr
+}
+
+// setSource records the file in `path` as the one that provides the policy,
+// along with its module directory and the priority it's installed at. The
+// file's details are left empty if it can't be read.
+func setSource(ps *datastore.PolicyStatus, dirs ModuleDirs, path string) {
+	dir, _ := dirs.lookup(path)
+	ps.SourcePath = path
+	ps.ModuleDir = dir.Path
+	ps.Priority = dirs.priorityOf(path)
+	ps.SourceSize = 0
+	ps.SourceModTime = nil
+	ps.SourceOwner = ""
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	modTime := info.ModTime()
+	ps.SourceSize = info.Size()
+	ps.SourceModTime = &modTime
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		ps.SourceOwner = fmt.Sprintf("%d:%d", st.Uid, st.Gid)
+	}
+}
+
+// policyFromPath returns the name of the policy in `path`, or an empty
+// string if it doesn't hold a policy; the action will fail on its own.
+func policyFromPath(path string) string {
+	policy, err := utils.PolicyNameFromPath(path)
+	if err != nil {
+		return ""
+	}
+	return policy
+}
+
+// asRetry returns the action to use when re-applying `action` on its own,
+// after the transaction it was part of couldn't be committed. Installs are
+// forced, as they are when they're retried.
+func asRetry(action PolicyAction) PolicyAction {
+	action = unwrapAction(action)
+	if pi, ok := action.(*policyInstall); ok {
+		return &policyInstall{path: pi.path, force: true, attempt: pi.attempt}
+	}
+	return action
+}
+
+// unwrapAction returns the operation behind the wrappers used to track it
+func unwrapAction(action PolicyAction) PolicyAction {
+	if ta, ok := action.(*trackedAction); ok {
+		return unwrapAction(ta.PolicyAction)
+	}
+	return action
+}
+
 type policyRemove struct {
 	path string
+	// policy is the name of the policy to remove. It's only set
+	// when there's no file path to derive the name from, e.g. when
+	// the file backing the policy is already gone.
+	policy string
 }
 
-// newInstallAction will execute the "remove" action for a policy.
+// newRemoveAction will execute the "remove" action for a policy.
 func newRemoveAction(path string) PolicyAction {
-	return &policyRemove{path}
+	return &policyRemove{path: path}
+}
+
+// newRemovePolicyAction will execute the "remove" action for a policy
+// that's known by name only.
+func newRemovePolicyAction(policy string) PolicyAction {
+	return &policyRemove{policy: policy}
 }
 
 func (pi *policyRemove) String() string {
+	if pi.path == "" {
+		return "remove - " + pi.policy
+	}
 	return "remove - " + pi.path
 }
 
-func (pi *policyRemove) do(modulePath string, sh seiface.Handler, ds datastore.DataStore) (string, error) {
+func (pi *policyRemove) key() string {
+	if pi.path != "" {
+		return pi.path
+	}
+	return pi.policy
+}
+
+func (pi *policyRemove) affectedPolicy() string {
+	if pi.policy != "" {
+		return pi.policy
+	}
+	return policyFromPath(pi.path)
+}
+
+func (pi *policyRemove) policyName() (string, error) {
+	if pi.policy != "" {
+		return pi.policy, nil
+	}
+	//nolint:wrapcheck // this is wrapped by the caller
+	return utils.PolicyNameFromPath(pi.path)
+}
+
+func (pi *policyRemove) do(cfg applyConfig, sh seiface.Handler, ds datastore.DataStore) (string, error) {
 	var policyArg string
-	policyArg, err := utils.PolicyNameFromPath(pi.path)
+	policyArg, err := pi.policyName()
 	if err != nil {
 		return "", fmt.Errorf("removing policy: %w", err)
 	}
 
-	if !pi.moduleInstalled(sh, policyArg) {
-		if err := ds.Remove(policyArg); err != nil {
-			return "Module is not in the system", fmt.Errorf("failed removing policy from datastore: %w", err)
+	priority := cfg.dirs.defaultPriority()
+	if pi.path != "" {
+		priority = cfg.dirs.priorityOf(pi.path)
+	}
+	p, getErr := ds.Get(policyArg)
+	if getErr == nil && p.Priority != 0 {
+		priority = p.Priority
+	}
+	if owned := ownedPriority(p); owned != 0 {
+		priority = owned
+	}
+
+	if pi.path != "" && getErr == nil {
+		switch {
+		case p.SourcePath != "" && p.SourcePath != pi.path:
+			return pi.dropConflict(ds, p)
+		case len(p.Conflicts) > 0:
+			if out, handedOver, err := handOver(cfg, sh, ds, p); handedOver {
+				return out, err
+			}
+		}
+	}
+
+	// Removals are only recorded for the policies that selinuxd knew of
+	known := getErr == nil
+	p.Policy = policyArg
+
+	installed, listErr := moduleInstalled(sh, policyArg, priority)
+	if listErr != nil {
+		return "", pi.fail(cfg, ds, p, known, fmt.Errorf("removing policy %s: %w", policyArg, listErr))
+	}
+	if !installed {
+		if err := forget(cfg, ds, p, known, "The module was not in the system"); err != nil {
+			return "Module is not in the system", err
 		}
 		return "No action needed; Module is not in the system", nil
 	}
 
-	if err := sh.Remove(policyArg); err != nil {
-		return "", fmt.Errorf("failed executing remove action: %w", err)
+	// Modules with the same name might be shipped by the distribution,
+	// or installed by hand
+	if !cfg.OverwriteUnowned && !owns(p, priority) {
+		msg := fmt.Sprintf("the module at priority %d wasn't installed by selinuxd", priority)
+		if err := forget(cfg, ds, p, known, "Left "+msg); err != nil {
+			return "", err
+		}
+		return "No action needed; " + msg, nil
+	}
+
+	if err := sh.Remove(policyArg, priority); err != nil {
+		return "", pi.fail(cfg, ds, p, known, fmt.Errorf("failed executing remove action: %w", err))
 	}
 
-	if err := ds.Remove(policyArg); err != nil {
-		return "", fmt.Errorf("failed removing policy from datastore: %w", err)
+	if err := forget(cfg, ds, p, true, fmt.Sprintf("Removed the module at priority %d", priority)); err != nil {
+		return "", err
 	}
 	return "", nil
 }
 
-func (pi *policyRemove) moduleInstalled(sh seiface.Handler, policy string) bool {
+// fail records that the policy couldn't be removed. The module is still
+// installed, so its ownership is kept. The checksum isn't, so that the
+// policy is installed again if its file comes back.
+func (pi *policyRemove) fail(cfg applyConfig, ds datastore.DataStore, p datastore.PolicyStatus, known bool,
+	removeErr error,
+) error {
+	if !known {
+		return removeErr
+	}
+	now := time.Now()
+	p.Status = datastore.FailedStatus
+	p.Message = removeErr.Error()
+	p.NextRetry = nil
+	p.LastUpdated = &now
+	entry := historyEntry(datastore.HistoryFailure, p, now)
+	p.Checksum = nil
+	if err := ds.Put(p); err != nil {
+		return errors.Join(removeErr, fmt.Errorf("failed persisting status in datastore: %w", err))
+	}
+	if err := recordHistory(cfg, ds, p.Policy, entry); err != nil {
+		return errors.Join(removeErr, err)
+	}
+	return removeErr
+}
+
+// dropConflict handles the removal of a file that didn't provide the policy,
+// as another file did so already.
+func (pi *policyRemove) dropConflict(ds datastore.DataStore, p datastore.PolicyStatus) (string, error) {
+	if !slices.Contains(p.Conflicts, pi.path) {
+		return "No action needed; the policy is provided by " + p.SourcePath, nil
+	}
+	p.Conflicts = slices.DeleteFunc(p.Conflicts, func(c string) bool { return c == pi.path })
+	if err := ds.Put(p); err != nil {
+		return "", fmt.Errorf("failed persisting status in datastore: %w", err)
+	}
+	return "Dropped conflicting file; the policy is provided by " + p.SourcePath, nil
+}
+
+// handOver makes the next file that claimed the policy provide it, as the
+// owner is going away. The files with the highest priority go first. It
+// returns false if none of the files is left.
+func handOver(cfg applyConfig, sh seiface.Handler, ds datastore.DataStore, p datastore.PolicyStatus,
+) (string, bool, error) {
+	claimants := slices.DeleteFunc(slices.Clone(p.Conflicts), func(c string) bool {
+		_, err := os.Stat(c)
+		return err != nil
+	})
+	if len(claimants) == 0 {
+		return "", false, nil
+	}
+	slices.SortStableFunc(claimants, func(a, b string) int {
+		return int(cfg.dirs.priorityOf(b)) - int(cfg.dirs.priorityOf(a))
+	})
+	next := claimants[0]
+
+	if owned := ownedPriority(p); owned != 0 {
+		if err := removeModule(sh, p.Policy, owned); err != nil {
+			return "", true, fmt.Errorf("failed executing remove action: %w", err)
+		}
+	}
+	p.OwnedPriority = 0
+	p.OwnedChecksum = nil
+	p.SourcePath = next
+	p.Conflicts = claimants[1:]
+	if err := ds.Put(p); err != nil {
+		return "", true, fmt.Errorf("failed persisting status in datastore: %w", err)
+	}
+	out, err := newReinstallAction(next).do(cfg, sh, ds)
+	if err != nil {
+		return out, true, err
+	}
+	return "The policy is now provided by " + next, true, nil
+}
+
+// forget removes the policy's entry from the datastore, and records the
+// removal in its history if `known`
+func forget(cfg applyConfig, ds datastore.DataStore, p datastore.PolicyStatus, known bool, msg string) error {
+	if err := removeFromDataStore(ds, p.Policy); err != nil {
+		return err
+	}
+	if !known {
+		return nil
+	}
+	entry := datastore.HistoryEntry{
+		Time:     time.Now(),
+		Action:   datastore.HistoryRemove,
+		Checksum: p.Checksum,
+		Message:  msg,
+	}
+	return recordHistory(cfg, ds, p.Policy, entry)
+}
+
+// removeFromDataStore removes the policy's entry from the datastore. An
+// entry that's already gone is not an error; this happens when a removal
+// is retried because the transaction it was part of got rolled back.
+func removeFromDataStore(ds datastore.DataStore, policy string) error {
+	err := ds.Remove(policy)
+	if err != nil && !errors.Is(err, datastore.ErrPolicyNotFound) {
+		return fmt.Errorf("failed removing policy from datastore: %w", err)
+	}
+	return nil
+}
+
+// removeModule removes the module installed at `priority`, if any
+func removeModule(sh seiface.Handler, policy string, priority uint16) error {
+	installed, err := moduleInstalled(sh, policy, priority)
+	if err != nil || !installed {
+		return err
+	}
+	//nolint:wrapcheck // this is wrapped by the callers
+	return sh.Remove(policy, priority)
+}
+
+// moduleInstalled tells whether the module is installed at `priority`. It
+// fails if the installed modules can't be listed, as guessing would either
+// overwrite modules that selinuxd doesn't own or forget the ones it does.
+func moduleInstalled(sh seiface.Handler, policy string, priority uint16) (bool, error) {
 	currentModules, err := sh.List()
 	if err != nil {
-		return false
+		return false, fmt.Errorf("listing installed modules: %w", err)
 	}
 
 	for _, mod := range currentModules {
-		if policy == mod {
-			return true
+		if policy == mod.Name && priority == mod.Priority {
+			return true, nil
 		}
 	}
 
-	return false
+	return false, nil
 }
@@ -0,0 +1,164 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule/test"
+	"github.com/go-logr/logr"
+)
+
+func TestPolicyNameCollisions(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	teamA := filepath.Join(moddir, "teamA")
+	teamB := filepath.Join(moddir, "teamB")
+	for _, dir := range []string{teamA, teamB} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := writePolicy(t, teamA, "web", "(type web_t)")
+	second := writePolicy(t, teamB, "web", "(type web_t)\n(type other_t)")
+
+	getStatus := func() datastore.PolicyStatus {
+		t.Helper()
+		ps, err := ds.Get("web")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		return ps
+	}
+
+	t.Run("The first file should provide the policy", func(t *testing.T) {
+		results := applyBatch(testConfig(moddir), sh, ds, []PolicyAction{
+			newInstallAction(first),
+			newInstallAction(second),
+		}, logr.Discard())
+
+		if results[0].err != nil {
+			t.Fatalf("unexpected error installing the first file: %s", results[0].err)
+		}
+		if !errors.Is(results[1].err, errPolicyConflict) {
+			t.Fatalf("expected a conflict for the second file, got: %v", results[1].err)
+		}
+
+		ps := getStatus()
+		if ps.Status != datastore.InstalledStatus || ps.SourcePath != first {
+			t.Fatalf("expected the policy to be provided by %s, got: %+v", first, ps)
+		}
+		if len(ps.Conflicts) != 1 || ps.Conflicts[0] != second {
+			t.Fatalf("expected %s to be recorded as a conflict, got: %v", second, ps.Conflicts)
+		}
+	})
+
+	t.Run("Removing the conflicting file should keep the policy", func(t *testing.T) {
+		if err := os.Remove(second); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newRemoveAction(second).do(testConfig(moddir), sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !sh.IsModuleInstalled("web") {
+			t.Fatalf("expected the policy to remain installed")
+		}
+		if ps := getStatus(); len(ps.Conflicts) != 0 || ps.SourcePath != first {
+			t.Fatalf("expected no conflicts, got: %+v", ps)
+		}
+	})
+
+	t.Run("Removing the owner should hand the policy over", func(t *testing.T) {
+		writePolicy(t, teamB, "web", "(type web_t)\n(type other_t)")
+		if _, err := newInstallAction(second).do(testConfig(moddir), sh, ds); !errors.Is(err, errPolicyConflict) {
+			t.Fatalf("expected a conflict, got: %v", err)
+		}
+
+		if err := os.Remove(first); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newRemoveAction(first).do(testConfig(moddir), sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !sh.IsModuleInstalled("web") {
+			t.Fatalf("expected the policy to remain installed")
+		}
+		ps := getStatus()
+		if ps.SourcePath != second || len(ps.Conflicts) != 0 || ps.Status != datastore.InstalledStatus {
+			t.Fatalf("expected the policy to be provided by %s, got: %+v", second, ps)
+		}
+	})
+
+	t.Run("Removing the last file should remove the policy", func(t *testing.T) {
+		if err := os.Remove(second); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newRemoveAction(second).do(testConfig(moddir), sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if sh.IsModuleInstalled("web") {
+			t.Fatalf("expected the policy to be removed")
+		}
+		if _, err := ds.Get("web"); !errors.Is(err, datastore.ErrPolicyNotFound) {
+			t.Fatalf("expected the policy to be removed from the datastore, got: %v", err)
+		}
+	})
+}
+
+func TestPolicyStatusDetails(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	policy := writePolicy(t, moddir, "web", "(type web_t)")
+	sh.FailInstalls("web", 1)
+	if _, err := newInstallAction(policy).do(testConfig(moddir), sh, ds); err == nil {
+		t.Fatalf("expected the first install to fail")
+	}
+	if _, err := newRetryAction(policy, 1).do(testConfig(moddir), sh, ds); err != nil {
+		t.Fatalf("unexpected error retrying the install: %s", err)
+	}
+
+	ps, err := ds.Get("web")
+	if err != nil {
+		t.Fatalf("Unable to get policy status: %s", err)
+	}
+	if ps.InstallAttempts != 2 || ps.Backend != sh.Name() || ps.FirstInstalled == nil || ps.LastUpdated == nil {
+		t.Fatalf("expected the install details to be recorded, got: %+v", ps)
+	}
+	info, err := os.Stat(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.SourceSize != info.Size() || ps.SourceModTime == nil || !ps.SourceModTime.Equal(info.ModTime()) ||
+		ps.SourceOwner != fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()) {
+		t.Fatalf("expected the source details to be recorded, got: %+v", ps)
+	}
+	firstInstalled := *ps.FirstInstalled
+
+	// Updating the policy keeps the time it was first installed at
+	writePolicy(t, moddir, "web", "(type web_t)\n(type other_t)")
+	if _, err := newInstallAction(policy).do(testConfig(moddir), sh, ds); err != nil {
+		t.Fatalf("unexpected error updating the policy: %s", err)
+	}
+	ps, err = ds.Get("web")
+	if err != nil {
+		t.Fatalf("Unable to get policy status: %s", err)
+	}
+	if ps.InstallAttempts != 1 || !ps.FirstInstalled.Equal(firstInstalled) || !ps.LastUpdated.After(firstInstalled) {
+		t.Fatalf("expected the update to be recorded, got: %+v", ps)
+	}
+}
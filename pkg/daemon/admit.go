@@ -0,0 +1,49 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/containers/selinuxd/pkg/admission"
+	"github.com/containers/selinuxd/pkg/signature"
+	"github.com/containers/selinuxd/pkg/utils"
+)
+
+// AdmissionOptions control which policies selinuxd agrees to install
+type AdmissionOptions struct {
+	// Rules deny the policies with over-permissive statements. If nil,
+	// every policy is admitted.
+	Rules *admission.Rules
+	// Keys are the keys that policies must be signed with. If nil,
+	// signatures aren't required.
+	Keys *signature.KeySet
+}
+
+var errRejected = errors.New("policy rejected")
+
+// admit checks the policy in `src` against the admission rules
+func admit(opts AdmissionOptions, src *policySource) error {
+	if opts.Rules == nil {
+		return nil
+	}
+	var data []byte
+	if utils.PolicyLanguage(src.path) == "cil" {
+		var err error
+		if data, err = src.moduleData(); err != nil {
+			return err
+		}
+	}
+	violations, err := opts.Rules.CheckPolicy(src.path, data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errRejected, err)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(violations))
+	for _, v := range violations {
+		msgs = append(msgs, v.String())
+	}
+	return fmt.Errorf("%w by the admission rules: %s", errRejected, strings.Join(msgs, "; "))
+}
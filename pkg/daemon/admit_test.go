@@ -0,0 +1,117 @@
+package daemon
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/selinuxd/pkg/admission"
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule/test"
+	"github.com/go-logr/logr"
+)
+
+func TestAdmissionRules(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	cfg := testConfig(moddir)
+	cfg.Rules = &admission.Rules{
+		ForbiddenAllows:      []admission.AllowRule{{Class: "security", Permissions: []string{"setenforce"}}},
+		PrivilegedAttributes: []string{"unconfined_domain_type"},
+	}
+
+	admitted := writePolicy(t, moddir, "admitted", "(type admitted_t)")
+	rejected := writePolicy(t, moddir, "rejected",
+		"(type rejected_t)\n(typeattributeset unconfined_domain_type (rejected_t))")
+	dependent := writePolicy(t, moddir, "dependent", "(allow dependent_t rejected_t (file (read)))")
+	results := applyBatch(cfg, sh, ds, []PolicyAction{
+		newInstallAction(admitted),
+		newInstallAction(rejected),
+		newInstallAction(dependent),
+	}, logr.Discard())
+
+	if results[0].err != nil {
+		t.Errorf("unexpected error installing the admitted policy: %s", results[0].err)
+	}
+	var rejectedErr error
+	for _, res := range results[1:] {
+		if res.action.affectedPolicy() == "rejected" {
+			rejectedErr = res.err
+		}
+	}
+	if !errors.Is(rejectedErr, errRejected) {
+		t.Errorf("expected the policy to be rejected, got: %v", rejectedErr)
+	}
+	if !sh.IsModuleInstalled("admitted") || sh.IsModuleInstalled("rejected") {
+		t.Errorf("expected only the admitted policy to be installed")
+	}
+
+	ps, err := ds.Get("rejected")
+	if err != nil {
+		t.Fatalf("Unable to get policy status: %s", err)
+	}
+	if ps.Status != datastore.RejectedStatus || ps.Attempt != 0 {
+		t.Fatalf("expected a rejected status, got: %+v", ps)
+	}
+	if !strings.Contains(ps.Message, "line 2:1: privileged-attribute: (typeattributeset unconfined_domain_type") {
+		t.Fatalf("expected the offending statement in the message, got: %s", ps.Message)
+	}
+
+	t.Run("A rejected policy should be checked again with new rules", func(t *testing.T) {
+		relaxed := testConfig(moddir)
+		if _, err := newInstallAction(rejected).do(relaxed, sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !sh.IsModuleInstalled("rejected") {
+			t.Fatalf("expected the policy to be installed")
+		}
+	})
+
+	ps, err = ds.Get("dependent")
+	if err != nil {
+		t.Fatalf("Unable to get policy status: %s", err)
+	}
+	if ps.Status != datastore.BlockedStatus {
+		t.Fatalf("expected the dependent policy to be blocked, got: %+v", ps)
+	}
+}
+
+func TestAdmissionUsesCheckedContents(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds := datastore.NewMemory()
+	defer ds.Close()
+
+	cfg := testConfig(moddir)
+	cfg.Rules = &admission.Rules{
+		ForbiddenAllows: []admission.AllowRule{{Class: "security", Permissions: []string{"setenforce"}}},
+	}
+
+	admitted := "(type swapped_t)"
+	path := writePolicy(t, moddir, "swapped", admitted)
+	action := newInstallAction(path)
+	// The dependency scan of the batch reads the policy before it's
+	// installed. Swapping the file afterwards mustn't go unchecked.
+	if _, err := action.(*policyInstall).source(); err != nil {
+		t.Fatal(err)
+	}
+	writePolicy(t, moddir, "swapped", "(allow swapped_t self (security (setenforce)))")
+
+	if _, err := action.do(cfg, sh, ds); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, err := sh.Extract("swapped", DefaultPriority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != admitted {
+		t.Fatalf("expected the admitted contents to be installed, got: %q", data)
+	}
+}
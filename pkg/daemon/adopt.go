@@ -0,0 +1,239 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	seiface "github.com/containers/selinuxd/pkg/semodule/interface"
+)
+
+// AdoptReport describes the outcome of adopting the modules that were
+// installed before selinuxd managed them.
+type AdoptReport struct {
+	// Adopted lists the policies whose module matched their file, and
+	// are now owned by selinuxd
+	Adopted []string `json:"adopted"`
+	// Mismatched lists the policies whose module differs from their
+	// file. Their installs are refused, as selinuxd doesn't own them.
+	Mismatched []string `json:"mismatched"`
+	// Rejected lists the policies whose file wouldn't be installed, as its
+	// signature doesn't verify, its syntax is invalid or the admission
+	// rules deny it. Their modules are left unowned.
+	Rejected []string `json:"rejected"`
+	// Failed lists the policies whose module couldn't be compared
+	Failed []string `json:"failed"`
+}
+
+type adoptResult struct {
+	report *AdoptReport
+	err    error
+}
+
+// Defines an adoption pass. As with reconciliation passes, it's executed
+// as a regular policy action so it's serialized with the rest of the
+// policy operations.
+type policyAdopt struct {
+	opts ScanOptions
+	// result is optional, and receives the outcome of the pass
+	result chan<- adoptResult
+}
+
+// newAdoptAction will execute an adoption pass. If `result` is not nil,
+// the report will be sent through it.
+func newAdoptAction(opts ScanOptions, result chan<- adoptResult) PolicyAction {
+	return &policyAdopt{opts, result}
+}
+
+func (pa *policyAdopt) String() string {
+	return "adopt"
+}
+
+// key is empty, as adoption passes are never coalesced
+func (pa *policyAdopt) key() string {
+	return ""
+}
+
+func (pa *policyAdopt) affectedPolicy() string {
+	return ""
+}
+
+// standalone keeps the pass out of transactional batches, so that its
+// report is sent once, and only lists committed operations
+func (pa *policyAdopt) standalone() {}
+
+// send hands the result to the caller, if it's still waiting for it
+func (pa *policyAdopt) send(res adoptResult) {
+	if pa.result == nil {
+		return
+	}
+	select {
+	case pa.result <- res:
+	default:
+	}
+}
+
+func (pa *policyAdopt) do(cfg applyConfig, sh seiface.Handler, ds datastore.DataStore) (string, error) {
+	report, err := adopt(cfg, pa.opts, sh, ds)
+	pa.send(adoptResult{report, err})
+	if err != nil {
+		return "", fmt.Errorf("adopting policies: %w", err)
+	}
+	return fmt.Sprintf("Adoption done. Adopted: %v. Mismatched: %v. Rejected: %v. Failed: %v",
+		report.Adopted, report.Mismatched, report.Rejected, report.Failed), nil
+}
+
+// adopt compares the modules installed at the priority of each policy file
+// in the module directories with the file. The modules that match are
+// recorded as installed and owned by selinuxd, without re-installing them.
+// Only the files that would be installed are adopted; that is, the ones that
+// pass the same signature, syntax and admission checks as installs.
+// Policies that selinuxd already owns a module for are left alone.
+func adopt(cfg applyConfig, opts ScanOptions, sh seiface.Handler, ds datastore.DataStore,
+) (*AdoptReport, error) {
+	files, err := policyFilesInDirs(cfg.dirs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded, err := loadedModules(sh)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AdoptReport{
+		Adopted:    []string{},
+		Mismatched: []string{},
+		Rejected:   []string{},
+		Failed:     []string{},
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := files[name]
+		priority := cfg.dirs.priorityOf(path)
+		if !loaded[priority][name] {
+			continue
+		}
+
+		p, getErr := ds.Get(name)
+		switch {
+		case errors.Is(getErr, datastore.ErrPolicyNotFound):
+			p = datastore.PolicyStatus{Policy: name}
+		case getErr != nil:
+			return nil, fmt.Errorf("couldn't access datastore: %w", getErr)
+		case ownedPriority(p) != 0:
+			// selinuxd already manages the policy
+			continue
+		}
+
+		src, readErr := readPolicySource(path)
+		if readErr != nil {
+			report.Failed = append(report.Failed, name)
+			continue
+		}
+		if checkErr := checkPolicy(cfg, src); checkErr != nil {
+			report.Rejected = append(report.Rejected, name)
+			continue
+		}
+
+		matches, cmpErr := moduleMatches(cfg, sh, name, priority, src)
+		switch {
+		case cmpErr != nil:
+			report.Failed = append(report.Failed, name)
+			continue
+		case !matches:
+			report.Mismatched = append(report.Mismatched, name)
+			continue
+		}
+
+		if err := recordAdoption(cfg, ds, p, src, sh.Name()); err != nil {
+			return nil, err
+		}
+		report.Adopted = append(report.Adopted, name)
+	}
+
+	return report, nil
+}
+
+// moduleMatches tells whether the module installed at `priority` has the
+// same contents as the module that the policy files in `src` would install.
+func moduleMatches(cfg applyConfig, sh seiface.Handler, policy string, priority uint16, src *policySource,
+) (bool, error) {
+	installed, err := sh.Extract(policy, priority)
+	if err != nil {
+		return false, fmt.Errorf("extracting module %s: %w", policy, err)
+	}
+	staged, cleanup, err := stagePolicy(cfg, src, policy)
+	if err != nil {
+		return false, err
+	}
+	defer cleanup()
+	data, err := os.ReadFile(staged)
+	if err != nil {
+		return false, fmt.Errorf("reading policy %s: %w", src.path, err)
+	}
+	return bytes.Equal(installed, data), nil
+}
+
+// recordAdoption records the policy in `src` as installed and owned, by the
+// module handler `backend`. The checksum is the one of the contents that
+// were compared with the module.
+func recordAdoption(cfg applyConfig, ds datastore.DataStore, p datastore.PolicyStatus, src *policySource,
+	backend string,
+) error {
+	path := src.path
+	cs := src.checksum()
+	priority := cfg.dirs.priorityOf(path)
+
+	// The file that provided the policy so far is refused from now on
+	if p.SourcePath != "" && p.SourcePath != path {
+		if _, statErr := os.Stat(p.SourcePath); statErr == nil {
+			p.Conflicts = append(p.Conflicts, p.SourcePath)
+		}
+	}
+	now := time.Now()
+	p.Status = datastore.InstalledStatus
+	p.Message = "Adopted the module that was already installed"
+	p.Checksum = cs
+	p.Attempt = 0
+	p.NextRetry = nil
+	p.LastUpdated = &now
+	p.Backend = backend
+	p.InstallAttempts = 0
+	if p.FirstInstalled == nil {
+		p.FirstInstalled = &now
+	}
+	setSource(&p, cfg.dirs, path)
+	p.OwnedPriority = priority
+	p.OwnedChecksum = cs
+	p.Conflicts = slices.DeleteFunc(p.Conflicts, func(c string) bool { return c == path })
+	if err := ds.Put(p); err != nil {
+		return fmt.Errorf("failed persisting status in datastore: %w", err)
+	}
+	return recordHistory(cfg, ds, p.Policy, historyEntry(datastore.HistoryInstall, p, now))
+}
+
+// requestAdopt issues an adoption pass and waits for its report
+func requestAdopt(ctx context.Context, opts ScanOptions, policyops ActionAdder) (*AdoptReport, error) {
+	result := make(chan adoptResult, 1)
+	policyops.Add(newAdoptAction(opts, result))
+
+	select {
+	case res := <-result:
+		return res.report, res.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for adoption: %w", ctx.Err())
+	}
+}
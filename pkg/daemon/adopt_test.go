@@ -0,0 +1,166 @@
+package daemon
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containers/selinuxd/pkg/admission"
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule/test"
+	"github.com/containers/selinuxd/pkg/signature"
+	"github.com/go-logr/logr"
+)
+
+func TestAdopt(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	// "matching" and "mismatched" were installed by hand, and
+	// "mismatched" got changed afterwards. "fresh" was never installed.
+	matching := writePolicy(t, moddir, "matching", "(type matching_t)")
+	mismatched := writePolicy(t, moddir, "mismatched", "(type mismatched_t)")
+	for _, path := range []string{matching, mismatched} {
+		if err := sh.Install(path, DefaultPriority); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePolicy(t, moddir, "mismatched", "(type mismatched_t)\n(type extra_t)")
+	writePolicy(t, moddir, "fresh", "(type fresh_t)")
+
+	report, err := adopt(testConfig(moddir), ScanOptions{}, sh, ds)
+	if err != nil {
+		t.Fatalf("Unexpected adoption error: %s", err)
+	}
+	if !slices.Equal(report.Adopted, []string{"matching"}) {
+		t.Errorf("expected 'matching' to be adopted, got: %v", report.Adopted)
+	}
+	if !slices.Equal(report.Mismatched, []string{"mismatched"}) {
+		t.Errorf("expected 'mismatched' to be reported, got: %v", report.Mismatched)
+	}
+	if len(report.Failed) != 0 {
+		t.Errorf("expected no failures, got: %v", report.Failed)
+	}
+
+	ps, err := ds.Get("matching")
+	if err != nil {
+		t.Fatalf("Unable to get policy status: %s", err)
+	}
+	if ps.Status != datastore.InstalledStatus || ps.OwnedPriority != DefaultPriority || ps.SourcePath != matching {
+		t.Fatalf("expected 'matching' to be installed and owned, got: %+v", ps)
+	}
+	if _, err := ds.Get("fresh"); !errors.Is(err, datastore.ErrPolicyNotFound) {
+		t.Fatalf("expected 'fresh' not to be recorded, got: %v", err)
+	}
+
+	results := applyBatch(testConfig(moddir), sh, ds, []PolicyAction{
+		newInstallAction(matching),
+		newInstallAction(mismatched),
+	}, logr.Discard())
+	if results[0].err != nil {
+		t.Errorf("unexpected error installing the adopted policy: %s", results[0].err)
+	}
+	if !errors.Is(results[1].err, errNotOwned) {
+		t.Errorf("expected the mismatched policy to be refused, got: %v", results[1].err)
+	}
+
+	// Adopting again doesn't report what's already managed
+	report, err = adopt(testConfig(moddir), ScanOptions{}, sh, ds)
+	if err != nil {
+		t.Fatalf("Unexpected adoption error: %s", err)
+	}
+	if len(report.Adopted) != 0 || !slices.Equal(report.Mismatched, []string{"mismatched"}) {
+		t.Errorf("unexpected report for the second pass: %+v", report)
+	}
+}
+
+func TestAdoptChecksPolicies(t *testing.T) {
+	moddir := t.TempDir()
+	keysDir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds := datastore.NewMemory()
+	defer ds.Close()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTrustedKey(t, keysDir, pub)
+	keys, err := signature.LoadKeySet(keysDir)
+	if err != nil {
+		t.Fatalf("unexpected error loading the keys: %s", err)
+	}
+	cfg := testConfig(moddir)
+	cfg.Keys = keys
+	cfg.Rules = &admission.Rules{
+		ForbiddenAllows: []admission.AllowRule{{Class: "security", Permissions: []string{"setenforce"}}},
+	}
+
+	// All of them were installed by hand and match their file, but only
+	// "trusted" would be installed by selinuxd
+	trusted := writePolicy(t, moddir, "trusted", "(type trusted_t)")
+	signFile(t, trusted, priv)
+	unsigned := writePolicy(t, moddir, "unsigned", "(type unsigned_t)")
+	forbidden := writePolicy(t, moddir, "forbidden", "(allow forbidden_t self (security (setenforce)))")
+	signFile(t, forbidden, priv)
+	invalid := writePolicy(t, moddir, "invalid", "(type invalid_t")
+	signFile(t, invalid, priv)
+	for _, path := range []string{trusted, unsigned, forbidden, invalid} {
+		if err := sh.Install(path, DefaultPriority); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := adopt(cfg, ScanOptions{}, sh, ds)
+	if err != nil {
+		t.Fatalf("Unexpected adoption error: %s", err)
+	}
+	if !slices.Equal(report.Adopted, []string{"trusted"}) {
+		t.Errorf("expected 'trusted' to be adopted, got: %v", report.Adopted)
+	}
+	if !slices.Equal(report.Rejected, []string{"forbidden", "invalid", "unsigned"}) {
+		t.Errorf("expected the unchecked policies to be rejected, got: %v", report.Rejected)
+	}
+	if len(report.Mismatched) != 0 || len(report.Failed) != 0 {
+		t.Errorf("expected no mismatches nor failures, got: %+v", report)
+	}
+	for _, policy := range report.Rejected {
+		if _, err := ds.Get(policy); !errors.Is(err, datastore.ErrPolicyNotFound) {
+			t.Errorf("expected '%s' not to be owned, got: %v", policy, err)
+		}
+	}
+}
+
+func TestRecordAdoptionUsesComparedContents(t *testing.T) {
+	moddir := t.TempDir()
+	ds := datastore.NewMemory()
+	defer ds.Close()
+
+	path := writePolicy(t, moddir, "racy", "(type racy_t)")
+	src, err := readPolicySource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file changes after its contents were compared with the module
+	writePolicy(t, moddir, "racy", "(type racy_t)\n(type extra_t)")
+
+	if err := recordAdoption(testConfig(moddir), ds, datastore.PolicyStatus{Policy: "racy"}, src, "test"); err != nil {
+		t.Fatalf("Unexpected adoption error: %s", err)
+	}
+	ps, err := ds.Get("racy")
+	if err != nil {
+		t.Fatalf("Unable to get policy status: %s", err)
+	}
+	if !bytes.Equal(ps.OwnedChecksum, src.checksum()) || !bytes.Equal(ps.Checksum, src.checksum()) {
+		t.Fatalf("expected the checksum of the compared contents to be recorded, got: %+v", ps)
+	}
+}
@@ -0,0 +1,141 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/selinuxd/pkg/utils"
+)
+
+const (
+	checkmoduleBin     = "/usr/bin/checkmodule"
+	semodulePackageBin = "/usr/bin/semodule_package"
+	makeBin            = "/usr/bin/make"
+	// develMakefile builds module packages out of sources that use
+	// interfaces. It's shipped along with the policy development files.
+	develMakefile = "/usr/share/selinux/devel/Makefile"
+)
+
+var errCompile = errors.New("compiling policy failed")
+
+// sourceFiles are the paths of the files that a type-enforcement source is
+// compiled from. The file contexts and interfaces are empty if there are
+// none.
+type sourceFiles struct {
+	te    string
+	fc    string
+	iface string
+}
+
+// compileFunc compiles the type-enforcement source in `files` into the
+// module package `out`. The compiler is stopped once `ctx` is done.
+// Failures carry the compiler diagnostics.
+type compileFunc func(ctx context.Context, files sourceFiles, out string) error
+
+// compileWithCheckmodule compiles type-enforcement sources the same way
+// it's done by hand: checkmodule builds the module, and semodule_package
+// bundles it with its file contexts. Sources with interfaces are built
+// with the development makefile instead, as checkmodule can't expand them.
+func compileWithCheckmodule(ctx context.Context, files sourceFiles, out string) error {
+	if files.iface != "" {
+		return compileWithMakefile(ctx, files, out)
+	}
+	mod := strings.TrimSuffix(out, filepath.Ext(out)) + ".mod"
+	if err := runCompiler(ctx, checkmoduleBin, "-M", "-m", "-o", mod, files.te); err != nil {
+		return err
+	}
+	args := []string{"-o", out, "-m", mod}
+	if files.fc != "" {
+		args = append(args, "-f", files.fc)
+	}
+	return runCompiler(ctx, semodulePackageBin, args...)
+}
+
+// compileWithMakefile builds the module package with the development
+// makefile, which expands the interfaces with m4 first. It picks up the
+// files named after the module in its working directory, so they must all
+// be in the directory of `out`. The makefile expects file contexts, so
+// empty ones are written if there are none.
+func compileWithMakefile(ctx context.Context, files sourceFiles, out string) error {
+	dir := filepath.Dir(out)
+	if files.fc == "" {
+		fc := strings.TrimSuffix(out, filepath.Ext(out)) + utils.FileContextsExtension
+		if err := os.WriteFile(fc, nil, 0o600); err != nil {
+			return fmt.Errorf("%w: %w", errCompile, err)
+		}
+	}
+	return runCompiler(ctx, makeBin, "-f", develMakefile, "-C", dir, filepath.Base(out))
+}
+
+func runCompiler(ctx context.Context, bin string, args ...string) error {
+	out, err := exec.CommandContext(ctx, bin, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w: %s", errCompile, filepath.Base(bin), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// compiler returns the function that type-enforcement sources are
+// compiled with
+func (cfg applyConfig) compiler() compileFunc {
+	if cfg.compile != nil {
+		return cfg.compile
+	}
+	return compileWithCheckmodule
+}
+
+// compilePolicy compiles the type-enforcement source in `src` into a
+// `<policy>.pp` module package in `dir`, and returns its path. The file
+// contexts and interfaces that go with the source are used too, if there
+// are any. The compiler works on copies of the files in `dir`.
+func compilePolicy(cfg applyConfig, src *policySource, policyName, dir string) (string, error) {
+	base := filepath.Join(dir, policyName)
+	var files sourceFiles
+	var err error
+	if files.te, err = stageSourceFile(src.data, base+utils.SourceExtension); err != nil {
+		return "", fmt.Errorf("staging policy %s: %w", src.path, err)
+	}
+	if files.fc, err = stageSourceFile(src.fc, base+utils.FileContextsExtension); err != nil {
+		return "", fmt.Errorf("staging policy %s: %w", src.path, err)
+	}
+	if files.iface, err = stageSourceFile(src.iface, base+utils.InterfaceExtension); err != nil {
+		return "", fmt.Errorf("staging policy %s: %w", src.path, err)
+	}
+	out := base + ".pp"
+	if err := cfg.compiler()(cfg.context(), files, out); err != nil {
+		return "", fmt.Errorf("compiling %s: %w", src.path, err)
+	}
+	return out, nil
+}
+
+// stageSourceFile writes `data` into `path` and returns it, or returns an
+// empty path if `data` is nil, as it's a companion file that doesn't exist
+func stageSourceFile(data []byte, path string) (string, error) {
+	if data == nil {
+		return "", nil
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", err //nolint:wrapcheck // this is wrapped by the caller
+	}
+	return path, nil
+}
+
+// sourceOfCompanion returns the type-enforcement source that the file
+// contexts or interfaces in `path` are compiled with, if there's one.
+// Changes to them are applied by re-installing the source.
+func sourceOfCompanion(path string) (string, bool) {
+	ext := filepath.Ext(path)
+	if ext != utils.FileContextsExtension && ext != utils.InterfaceExtension {
+		return "", false
+	}
+	te := strings.TrimSuffix(path, ext) + utils.SourceExtension
+	if _, err := os.Stat(te); err != nil {
+		return "", false
+	}
+	return te, true
+}
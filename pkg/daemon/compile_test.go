@@ -0,0 +1,173 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule/test"
+	"github.com/containers/selinuxd/pkg/utils"
+	"github.com/go-logr/logr"
+)
+
+// fakeCompile "compiles" sources by concatenating them, and fails on
+// sources containing "syntax error" the way checkmodule reports it
+func fakeCompile(ctx context.Context, files sourceFiles, out string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: checkmodule: %w", errCompile, err)
+	}
+	src, err := os.ReadFile(files.te)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(string(src), "syntax error") {
+		return fmt.Errorf("%w: checkmodule: exit status 1: %s:1:ERROR 'syntax error'", errCompile, files.te)
+	}
+	for _, companion := range []string{files.iface, files.fc} {
+		if companion == "" {
+			continue
+		}
+		data, err := os.ReadFile(companion)
+		if err != nil {
+			return err
+		}
+		src = append(src, data...)
+	}
+	return os.WriteFile(out, src, 0o600)
+}
+
+func TestCompileSources(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+	cfg := testConfig(moddir)
+	cfg.compile = fakeCompile
+
+	te := filepath.Join(moddir, "webapp.te")
+	fc := filepath.Join(moddir, "webapp.fc")
+	iface := filepath.Join(moddir, "webapp.if")
+	writeFile := func(t *testing.T, path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	installed := func(t *testing.T) string {
+		t.Helper()
+		data, err := sh.Extract("webapp", DefaultPriority)
+		if err != nil {
+			t.Fatalf("expected the module to be installed: %s", err)
+		}
+		return string(data)
+	}
+
+	t.Run("A source should be compiled along with its file contexts", func(t *testing.T) {
+		writeFile(t, te, "module webapp 1.0;\n")
+		writeFile(t, fc, "/srv/webapp(/.*)? system_u:object_r:httpd_sys_content_t:s0\n")
+		if _, err := newInstallAction(te).do(cfg, sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got := installed(t); !strings.Contains(got, "module webapp") || !strings.Contains(got, "/srv/webapp") {
+			t.Fatalf("expected the compiled package to be installed, got: %q", got)
+		}
+		mods, err := sh.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(mods) != 1 || mods[0].Language != "pp" {
+			t.Fatalf("expected a single module package to be installed, got: %+v", mods)
+		}
+	})
+
+	t.Run("Changing the file contexts should re-install the policy", func(t *testing.T) {
+		src, ok := policyOfCompanion(fc)
+		if !ok || src != te {
+			t.Fatalf("expected %s to be the source of %s, got: %s", te, fc, src)
+		}
+		writeFile(t, fc, "/srv/other(/.*)? system_u:object_r:httpd_sys_content_t:s0\n")
+		if _, err := newInstallAction(src).do(cfg, sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got := installed(t); !strings.Contains(got, "/srv/other") {
+			t.Fatalf("expected the new file contexts to be installed, got: %q", got)
+		}
+	})
+
+	t.Run("Changing the interfaces should re-install the policy", func(t *testing.T) {
+		writeFile(t, iface, "interface(`webapp_read_content',`')\n")
+		for _, companion := range []string{iface, iface + ".sig"} {
+			if src, ok := policyOfCompanion(companion); !ok || src != te {
+				t.Fatalf("expected %s to be the source of %s, got: %s", te, companion, src)
+			}
+		}
+		if _, err := newInstallAction(te).do(cfg, sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got := installed(t); !strings.Contains(got, "webapp_read_content") {
+			t.Fatalf("expected the interfaces to be compiled, got: %q", got)
+		}
+		ps, err := ds.Get("webapp")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		cs, err := utils.PolicyChecksum(te)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ps.Checksum, cs) {
+			t.Fatalf("expected the checksum to cover the interfaces, got: %x", ps.Checksum)
+		}
+	})
+
+	t.Run("Compilation should stop once the daemon is done", func(t *testing.T) {
+		writeFile(t, te, "module webapp 1.2;\n")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		stopped := cfg
+		stopped.ctx = ctx
+		if _, err := newInstallAction(te).do(stopped, sh, ds); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the compilation to be canceled, got: %v", err)
+		}
+		if got := installed(t); strings.Contains(got, "module webapp 1.2") {
+			t.Fatalf("expected the previous module to be kept, got: %q", got)
+		}
+	})
+
+	t.Run("Compiler diagnostics should be reported in the status", func(t *testing.T) {
+		writeFile(t, te, "module webapp 1.1;\nsyntax error\n")
+		action := newInstallAction(te)
+		_, err := action.do(cfg, sh, ds)
+		if !errors.Is(err, errCompile) {
+			t.Fatalf("expected a compilation error, got: %v", err)
+		}
+		policyops := NewActionQueue(time.Millisecond)
+		defer policyops.ShutDown()
+		scheduleRetries([]actionResult{{action: action, err: err}}, RetryOptions{MaxAttempts: 5}, ds, policyops,
+			logr.Discard())
+
+		ps, err := ds.Get("webapp")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if ps.Status != datastore.FailedStatus || !strings.Contains(ps.Message, "ERROR 'syntax error'") {
+			t.Fatalf("expected the diagnostics in the status, got: %+v", ps)
+		}
+		if ps.NextRetry != nil {
+			t.Fatalf("expected the compilation not to be retried, got: %+v", ps)
+		}
+		if got := installed(t); !strings.Contains(got, "/srv/other") {
+			t.Fatalf("expected the previous module to be kept, got: %q", got)
+		}
+	})
+}
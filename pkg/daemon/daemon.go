@@ -1,37 +1,63 @@
 package daemon
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/inotify"
 	seiface "github.com/containers/selinuxd/pkg/semodule/interface"
-	"github.com/fsnotify/fsnotify"
+	"github.com/containers/selinuxd/pkg/utils"
 	"github.com/go-logr/logr"
 )
 
 type SelinuxdOptions struct {
 	StatusServerConfig
+	// StatusDBPath is the URI of the datastore, as taken by datastore.Open.
+	// Plain paths point to a bbolt datastore.
 	StatusDBPath string
+	// ReconcileInterval is how often the module directory, the datastore
+	// and the installed modules are compared. Zero disables it.
+	ReconcileInterval time.Duration
+	// DebounceWindow is how long to wait for a burst of file events
+	// to settle before applying the resulting policy operations. It
+	// defaults to DefaultDebounceWindow.
+	DebounceWindow time.Duration
+	ScanOptions
+	RetryOptions
+	OwnershipOptions
+	AdmissionOptions
+	// HistoryRetention is how long the history of each policy is kept for
+	HistoryRetention datastore.HistoryRetention
 }
 
 // Daemon takes the following parameters:
 // * `opts`: are the options to run status server.
-// * `mPath`: is the path to install and read modules from.
+// * `dirs`: are the directories to install and read modules from.
 // * `sh`: is the SELinux module handler interface.
 // * `ds`: is the DataStore interface.
 // * `l`: is a logger interface.
-func Daemon(opts *SelinuxdOptions, mPath string, sh seiface.Handler, ds datastore.DataStore, done chan bool,
+func Daemon(opts *SelinuxdOptions, dirs ModuleDirs, sh seiface.Handler, ds datastore.DataStore, done chan bool,
 	l logr.Logger,
 ) {
-	policyops := make(chan PolicyAction)
-	readychan := make(chan bool)
+	window := opts.DebounceWindow
+	if window <= 0 {
+		window = DefaultDebounceWindow
+	}
+	policyops := NewActionQueue(window)
+	defer policyops.ShutDown()
+	scan := newInitialScan(policyops, l)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	l.Info("Started daemon")
 	if ds == nil {
 		var err error
-		ds, err = datastore.New(opts.StatusDBPath)
+		ds, err = datastore.Open(opts.StatusDBPath)
 		if err != nil {
 			l.Error(err, "Unable to get R/W datastore")
 			panic(err)
@@ -39,69 +65,148 @@ func Daemon(opts *SelinuxdOptions, mPath string, sh seiface.Handler, ds datastor
 		defer ds.Close()
 	}
 
-	ss, err := initStatusServer(opts.StatusServerConfig, ds.GetReadOnly(), l)
+	reconcileFn := func(rctx context.Context) (*ReconcileReport, error) {
+		return requestReconcile(rctx, opts.ScanOptions, policyops)
+	}
+	adoptFn := func(actx context.Context) (*AdoptReport, error) {
+		return requestAdopt(actx, opts.ScanOptions, policyops)
+	}
+
+	ss, err := initStatusServer(opts.StatusServerConfig, ds.GetReadOnly(), scan, reconcileFn, adoptFn, l)
 	if err != nil {
 		l.Error(err, "Unable initialize status server")
 		panic(err)
 	}
 
-	go serveState(ss, readychan, l)
+	go serveState(ss, l)
 
-	watcher, err := fsnotify.NewWatcher()
+	watcher, err := inotify.NewWatcher()
 	if err != nil {
-		l.Error(err, "Unable to get fsnotify watcher")
+		l.Error(err, "Unable to get inotify watcher")
 		panic(err)
 	}
 	defer watcher.Close()
 
-	// TODO(jaosorior): Enable multiple watchers
-	go watchFiles(watcher, policyops, l)
+	go watchFiles(watcher, dirs, opts.ScanOptions, policyops, l)
 
-	go InstallPolicies(mPath, sh, ds, policyops, l)
+	go InstallPolicies(ctx, dirs, sh, ds, policyops, opts.RetryOptions, opts.OwnershipOptions, opts.AdmissionOptions,
+		opts.HistoryRetention, l)
+
+	// NOTE: Modules that were installed by other means are adopted before
+	// the policies are installed, as their installs would be refused.
+	// Nothing was queued yet, so this doesn't race with the installer.
+	if opts.AdoptExisting {
+		AdoptExistingPolicies(ctx, dirs, opts.ScanOptions, opts.AdmissionOptions, sh, ds, l)
+	}
 
 	// NOTE(jaosorior): We do this before adding the path to the notification
 	// watcher so all the policies are installed already when we start watching
-	// for events.
-	if err := InstallPoliciesInDir(mPath, policyops, watcher); err != nil {
-		l.Error(err, "Installing policies in module directory")
+	// for events. The operations go through `scan`, as the daemon is ready
+	// once they were applied.
+	for _, md := range dirs {
+		if err := InstallPoliciesInDir(md.Path, opts.ScanOptions, scan, watcher); err != nil {
+			l.Error(err, "Installing policies in module directory", "directory", md.Path)
+		}
 	}
 
-	err = watcher.Add(mPath)
-	if err != nil {
-		l.Error(err, "Could not create an fsnotify watcher")
+	// NOTE(jaosorior): Files might have been removed while we weren't
+	// running, so we won't get an event for them.
+	if err := RemoveOrphanedPolicies(dirs, opts.ScanOptions, ds.GetReadOnly(), scan); err != nil {
+		l.Error(err, "Removing orphaned policies")
 	}
+	scan.finish()
 
-	readychan <- true
-	close(readychan)
+	for _, md := range dirs {
+		if err := watcher.Add(md.Path); err != nil {
+			l.Error(err, "Could not create an inotify watcher", "directory", md.Path)
+		}
+	}
+
+	if opts.Keys != nil {
+		keyWatcher, err := inotify.NewWatcher()
+		if err != nil {
+			l.Error(err, "Unable to get inotify watcher")
+			panic(err)
+		}
+		defer keyWatcher.Close()
+		if err := keyWatcher.Add(opts.Keys.Dir()); err != nil {
+			l.Error(err, "Could not watch the trusted keys", "directory", opts.Keys.Dir())
+		}
+		go watchKeys(keyWatcher, opts.AdmissionOptions, policyops, l)
+	}
+
+	go reconcilePeriodically(ctx, opts.ReconcileInterval, opts.ScanOptions, policyops, l)
 
 	<-done
 }
 
-func watchFiles(watcher *fsnotify.Watcher, policyops chan PolicyAction, logger logr.Logger) {
+// AdoptExistingPolicies adopts the modules that are installed and match the
+// policy files in `dirs`, if `admission` admits the files. See `adopt`.
+func AdoptExistingPolicies(ctx context.Context, dirs ModuleDirs, opts ScanOptions, admission AdmissionOptions,
+	sh seiface.Handler, ds datastore.DataStore, logger logr.Logger,
+) {
+	alog := logger.WithName("adopter")
+	report, err := adopt(applyConfig{dirs: dirs, AdmissionOptions: admission, ctx: ctx}, opts, sh, ds)
+	if err != nil {
+		alog.Error(err, "Adopting existing policies")
+		return
+	}
+	alog.Info("Adopted existing policies", "adopted", report.Adopted, "mismatched", report.Mismatched,
+		"rejected", report.Rejected, "failed", report.Failed)
+}
+
+func watchFiles(watcher *inotify.Watcher, dirs ModuleDirs, opts ScanOptions, policyops ActionAdder,
+	logger logr.Logger,
+) {
 	fwlog := logger.WithName("file-watcher")
 	for {
 		select {
 		case event, ok := <-watcher.Events:
 			if !ok {
-				fwlog.Info("WARNING: the fsnotify channel has been closed or is empty")
+				fwlog.Info("WARNING: the inotify channel has been closed or is empty")
 				return // TODO(jaosorior): Actually signal exit
 			}
-			switch dispatch(event) {
+			// Events from outside the module directories come from the
+			// directories that hold the targets of symlinks.
+			md, ok := dirs.lookup(event.Name)
+			if !ok {
+				handleSymlinkTargetEvent(event, dirs, policyops, fwlog)
+				continue
+			}
+			// Changes to file contexts or signatures are applied by
+			// re-installing the policy they belong to
+			if policy, ok := policyOfCompanion(event.Name); ok {
+				fwlog.Info("Re-installing policy due to a change in its companion file",
+					"file", policy, "companion", event.Name)
+				policyops.Add(newReinstallAction(policy))
+				continue
+			}
+			switch dispatch(event, opts) {
 			case dispatchRemoval:
 				fwlog.Info("Removing policy", "file", event.Name)
-				policyops <- newRemoveAction(event.Name)
+				policyops.Add(newRemoveAction(event.Name))
 			case dispatchFileAddition:
 				fwlog.Info("Installing policy", "file", event.Name)
-				policyops <- newInstallAction(event.Name)
+				policyops.Add(newInstallAction(event.Name))
 			case dispatchDirectoryAddition:
 				fwlog.Info("Tracking sub-directory", "directory", event.Name)
 				if addErr := watcher.Add(event.Name); addErr != nil {
 					fwlog.Error(addErr, "Unable to watch sub-directory")
 				}
 				fwlog.Info("Installing policies in sub-directory", "directory", event.Name)
-				if instErr := InstallPoliciesInDir(event.Name, policyops, watcher); instErr != nil {
+				if instErr := InstallPoliciesInDir(event.Name, opts, policyops, watcher); instErr != nil {
 					fwlog.Error(instErr, "Error installing policies in sub-directory")
 				}
+			case dispatchAtomicUpdate:
+				// Removed policies get their own events, as their
+				// symlinks are deleted after the update.
+				fwlog.Info("The module directory was atomically updated. Re-installing policies",
+					"directory", md.Path)
+				if instErr := InstallPoliciesInDir(md.Path, opts, policyops, watcher); instErr != nil {
+					fwlog.Error(instErr, "Error installing policies in module directory")
+				}
+			case dispatchBookkeeping, dispatchTemporary, dispatchIncomplete:
+				// nothing to do
 			case dispatchSymlink:
 				fwlog.Info("Ignoring symlink", "symlink", event.Name)
 			case dispatchUnkown:
@@ -109,7 +214,7 @@ func watchFiles(watcher *fsnotify.Watcher, policyops chan PolicyAction, logger l
 			}
 		case err, ok := <-watcher.Errors:
 			if !ok {
-				fwlog.Info("WARNING: the fsnotify channel has been closed or is empty")
+				fwlog.Info("WARNING: the inotify channel has been closed or is empty")
 				return // TODO(jaosorior): Actually signal exit
 			}
 			fwlog.Error(err, "Error watching for event")
@@ -117,28 +222,255 @@ func watchFiles(watcher *fsnotify.Watcher, policyops chan PolicyAction, logger l
 	}
 }
 
-// InstallPolicies installs the policies found in the `modulePath` directory
-func InstallPolicies(modulePath string, sh seiface.Handler, ds datastore.DataStore, policyops chan PolicyAction, logger logr.Logger) { //nolint:lll
+// InstallPolicies installs the policies found in the `dirs` directories
+//
+// The operations that the queue hands over together are applied as a batch
+// and committed at once, since each commit implies a full policy rebuild.
+// If the commit fails, the operations in the batch are applied one by one,
+// so a single wrongly formatted policy doesn't affect the rest. Failed
+// installs are re-queued according to `retry`. Sources that are being
+// compiled once `ctx` is done fail to install.
+func InstallPolicies(ctx context.Context, dirs ModuleDirs, sh seiface.Handler, ds datastore.DataStore,
+	policyops *ActionQueue, retry RetryOptions, ownership OwnershipOptions, admission AdmissionOptions,
+	history datastore.HistoryRetention, logger logr.Logger,
+) {
 	ilog := logger.WithName("policy-installer")
-	for action := range policyops {
-		if actionOut, err := action.do(modulePath, sh, ds); err != nil {
-			ilog.Error(err, "Failed applying operation on policy", "operation", action, "output", actionOut)
-		} else {
-			// TODO(jaosorior): Replace this log with proper tracking of the installation status
-			if actionOut == "" {
-				actionOut = "The operation was successful"
-			}
-			ilog.Info(actionOut, "operation", action)
+	cfg := applyConfig{
+		dirs: dirs, OwnershipOptions: ownership, AdmissionOptions: admission, history: history, ctx: ctx,
+	}
+	for {
+		batch, open := policyops.get()
+		if len(batch) > 0 {
+			results := applyBatch(cfg, sh, ds, batch, ilog)
+			scheduleRetries(results, retry, ds, policyops, ilog)
+		}
+		if !open {
+			break
+		}
+	}
+	ilog.Info("The policy operations queue is now shut down")
+}
+
+type actionResult struct {
+	action PolicyAction
+	output string
+	err    error
+}
+
+// applyBatch applies the operations and returns their outcome
+func applyBatch(cfg applyConfig, sh seiface.Handler, ds datastore.DataStore, batch []PolicyAction,
+	ilog logr.Logger,
+) []actionResult {
+	defer notifyApplied(batch)
+
+	batch, standalone := splitStandalone(batch)
+	results := applyTransaction(cfg, sh, ds, batch, ilog)
+
+	// Standalone actions run after the rest of the batch is committed, and
+	// commit each of their operations on their own
+	for _, action := range standalone {
+		sh.SetAutoCommit(true)
+		out, err := action.do(cfg, sh, ds)
+		res := actionResult{action, out, err}
+		logActionResult(res, ilog)
+		results = append(results, res)
+	}
+	return results
+}
+
+// standaloneAction is implemented by the actions that can't be part of a
+// transactional batch. They report their outcome to a caller that might be
+// waiting for it, so they must run only once, and only report what was
+// committed.
+type standaloneAction interface {
+	standalone()
+}
+
+// splitStandalone separates the standalone actions from the ones that can
+// be applied in a single commit
+func splitStandalone(batch []PolicyAction) (transactional, standalone []PolicyAction) {
+	transactional = make([]PolicyAction, 0, len(batch))
+	for _, action := range batch {
+		if _, ok := action.(standaloneAction); ok {
+			standalone = append(standalone, action)
+			continue
+		}
+		transactional = append(transactional, action)
+	}
+	return transactional, standalone
+}
+
+// applyTransaction applies the batch in a single commit. If that fails,
+// the operations are applied one by one. The datastore is only updated
+// once the commit succeeds, as it'd record outcomes that semodule
+// discarded otherwise.
+func applyTransaction(cfg applyConfig, sh seiface.Handler, ds datastore.DataStore, batch []PolicyAction,
+	ilog logr.Logger,
+) []actionResult {
+	if len(batch) == 0 {
+		return []actionResult{}
+	}
+
+	// Policies are installed after the ones they depend on
+	graph := dependenciesOf(batch)
+	batch = orderByDependencies(batch, graph)
+	ba := newBatchApplier(cfg, sh, ds, graph)
+
+	// There's nothing to gain from deferring the commit of a single operation
+	if len(batch) == 1 {
+		sh.SetAutoCommit(true)
+		res := ba.apply(batch[0])
+		logActionResult(res, ilog)
+		return []actionResult{res}
+	}
+
+	sh.SetAutoCommit(false)
+	staged := datastore.NewStaged(ds)
+	ba.ds = staged
+	results := make([]actionResult, 0, len(batch))
+	for _, action := range batch {
+		results = append(results, ba.apply(action))
+	}
+
+	if err := sh.Commit(); err != nil {
+		ilog.Info("Unable to apply policy operations in one commit. "+
+			"This is most likely due to a policy being wrongly formatted. "+
+			"Will attempt to apply each operation individually.", "error", err.Error())
+		// Do longer policy-per-policy install
+		sh.SetAutoCommit(true)
+		ba = newBatchApplier(cfg, sh, ds, graph)
+		results = results[:0]
+		for _, action := range batch {
+			res := ba.apply(asRetry(action))
+			logActionResult(res, ilog)
+			results = append(results, res)
+		}
+		return results
+	}
+
+	if err := staged.Commit(); err != nil {
+		ilog.Error(err, "Unable to record the outcome of the committed policy operations")
+	}
+	for _, res := range results {
+		logActionResult(res, ilog)
+	}
+	return results
+}
+
+func notifyApplied(batch []PolicyAction) {
+	for _, action := range batch {
+		if n, ok := action.(appliedNotifier); ok {
+			n.applied()
+		}
+	}
+}
+
+// batchApplier applies the operations of a batch, keeping track of the
+// policies that failed, so that the policies depending on them are marked
+// as blocked instead of attempted.
+type batchApplier struct {
+	cfg    applyConfig
+	sh     seiface.Handler
+	ds     datastore.DataStore
+	graph  dependencyGraph
+	failed map[string]bool
+}
+
+func newBatchApplier(cfg applyConfig, sh seiface.Handler, ds datastore.DataStore,
+	graph dependencyGraph,
+) *batchApplier {
+	return &batchApplier{cfg, sh, ds, graph, make(map[string]bool)}
+}
+
+func (ba *batchApplier) apply(action PolicyAction) actionResult {
+	policy := action.affectedPolicy()
+	if pi, ok := unwrapAction(action).(*policyInstall); ok {
+		if dep := blockingDependency(policy, ba.graph, ba.failed, ba.ds); dep != "" {
+			ba.failed[policy] = true
+			return actionResult{action, "", pi.block(ba.cfg, ba.ds, dep)}
+		}
+	}
+
+	out, err := action.do(ba.cfg, ba.sh, ba.ds)
+	// Refused files don't affect the policy that's installed
+	if err != nil && !errors.Is(err, errPolicyConflict) && !errors.Is(err, errNotOwned) {
+		ba.failed[policy] = true
+	}
+	return actionResult{action, out, err}
+}
+
+func logActionResult(res actionResult, ilog logr.Logger) {
+	if res.err != nil {
+		ilog.Error(res.err, "Failed applying operation on policy", "operation", res.action, "output", res.output)
+		return
+	}
+	// TODO(jaosorior): Replace this log with proper tracking of the installation status
+	actionOut := res.output
+	if actionOut == "" {
+		actionOut = "The operation was successful"
+	}
+	ilog.Info(actionOut, "operation", res.action)
+}
+
+// handleSymlinkTargetEvent issues the operations for the policies whose
+// symlinks point to the file that changed.
+func handleSymlinkTargetEvent(event inotify.Event, dirs ModuleDirs, policyops ActionAdder, fwlog logr.Logger) {
+	links := make([]string, 0)
+	for _, md := range dirs {
+		links = append(links, linksTo(md.Path, event.Name)...)
+	}
+	for _, link := range links {
+		if _, ok := resolvePolicySymlink(link); !ok {
+			fwlog.Info("Removing policy as its symlink target is gone", "file", link, "target", event.Name)
+			policyops.Add(newRemoveAction(link))
+			continue
+		}
+		if event.Op.Has(inotify.CloseWrite | inotify.MovedTo) {
+			fwlog.Info("Installing policy as its symlink target changed", "file", link, "target", event.Name)
+			policyops.Add(newInstallAction(link))
 		}
 	}
-	ilog.Info("The policy operations channel is now closed")
 }
 
-func InstallPoliciesInDir(mpath string, policyops chan PolicyAction, watcher *fsnotify.Watcher) error {
+func InstallPoliciesInDir(mpath string, opts ScanOptions, policyops ActionAdder, watcher *inotify.Watcher) error {
+	return walkPolicyFiles(mpath, opts, watcher, func(path string) {
+		policyops.Add(newInstallAction(path))
+	})
+}
+
+// walkPolicyFiles calls `fn` for each file in `mpath` that might be a
+// policy. If a watcher is given, the directories get added to it, as do
+// the directories holding the targets of symlinks.
+func walkPolicyFiles(mpath string, opts ScanOptions, watcher *inotify.Watcher, fn func(path string)) error {
 	err := filepath.Walk(mpath, func(path string, info os.FileInfo, err error) error {
 		if info == nil {
 			return nil
 		}
+		if opts.FollowSymlinks && path != mpath && utils.IsBookkeepingEntry(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && (utils.IsTemporaryFile(path) || utils.IsCompanionFile(path)) {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink == os.ModeSymlink {
+			if !opts.FollowSymlinks {
+				return nil
+			}
+			target, ok := resolvePolicySymlink(path)
+			if !ok {
+				return nil
+			}
+			if targetDir := filepath.Dir(target); watcher != nil && !isWithin(mpath, targetDir) {
+				if err := watcher.Add(targetDir); err != nil {
+					return fmt.Errorf("unable to watch symlink target directory %s: %w", targetDir, err)
+				}
+			}
+			fn(path)
+			return nil
+		}
 		if watcher != nil && info.IsDir() {
 			err := watcher.Add(path)
 			if err != nil {
@@ -150,7 +482,7 @@ func InstallPoliciesInDir(mpath string, policyops chan PolicyAction, watcher *fs
 			return nil
 		}
 
-		policyops <- newInstallAction(path)
+		fn(path)
 		return nil
 	})
 	if err != nil {
@@ -158,3 +490,24 @@ func InstallPoliciesInDir(mpath string, policyops chan PolicyAction, watcher *fs
 	}
 	return nil
 }
+
+// RemoveOrphanedPolicies issues a removal for every policy in the datastore
+// that doesn't have a backing file in any of `dirs` anymore.
+func RemoveOrphanedPolicies(dirs ModuleDirs, opts ScanOptions, ds datastore.ReadOnlyDataStore,
+	policyops ActionAdder,
+) error {
+	files, err := policyFilesInDirs(dirs, opts)
+	if err != nil {
+		return err
+	}
+
+	stored, err := ds.List()
+	if err != nil {
+		return fmt.Errorf("unable to list policies in datastore: %w", err)
+	}
+
+	for _, policy := range orphanedPolicies(files, stored) {
+		policyops.Add(newRemovePolicyAction(policy))
+	}
+	return nil
+}
@@ -1,8 +1,10 @@
 package daemon
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -14,7 +16,9 @@ import (
 
 	backoff "github.com/cenkalti/backoff/v4"
 	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/inotify"
 	"github.com/containers/selinuxd/pkg/semodule/test"
+	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
 	"go.uber.org/zap"
 )
@@ -35,9 +39,17 @@ func getPolicyPath(module, path string) string {
 	return filepath.Join(path, moduleFileName)
 }
 
+func testModuleDirs(moddir string) ModuleDirs {
+	return ModuleDirs{{Path: moddir, Priority: DefaultPriority}}
+}
+
+func testConfig(moddir string) applyConfig {
+	return applyConfig{dirs: testModuleDirs(moddir)}
+}
+
 func installPolicy(module, path string, t *testing.T) {
 	modPath := getPolicyPath(module, path)
-	message := []byte("Hello, Gophers!")
+	message := []byte(fmt.Sprintf("(type %s_t)", module))
 	err := os.WriteFile(modPath, message, 0o600)
 	if err != nil {
 		t.Fatal(err)
@@ -117,7 +129,7 @@ func TestDaemon(t *testing.T) {
 	}
 	defer ds.Close()
 
-	go Daemon(&config, moddir, sh, ds, done, zapr.NewLogger(logger))
+	go Daemon(&config, testModuleDirs(moddir), sh, ds, done, zapr.NewLogger(logger))
 	defer close(done)
 
 	t.Run("Should install a policy", func(t *testing.T) {
@@ -176,14 +188,14 @@ func TestDaemon(t *testing.T) {
 		}
 		defer response.Body.Close()
 
-		var status map[string]bool
+		var status ReadyStatus
 		err = json.NewDecoder(response.Body).Decode(&status)
 		if err != nil {
 			t.Fatalf("cannot decode response: %s", err)
 		}
 
-		if status["ready"] != true {
-			t.Fatalf("expected 'test' module, got: %t", status["ready"])
+		if !status.Ready {
+			t.Fatalf("expected daemon to be ready, got: %t", status.Ready)
 		}
 	})
 
@@ -227,7 +239,7 @@ func TestDaemon(t *testing.T) {
 		}
 		defer response.Body.Close()
 
-		var moduleStatus map[string]string
+		var moduleStatus map[string]interface{}
 		err = json.NewDecoder(response.Body).Decode(&moduleStatus)
 		if err != nil {
 			t.Fatalf("cannot decode response: %s", err)
@@ -240,10 +252,46 @@ func TestDaemon(t *testing.T) {
 		}
 
 		if moduleStatus["status"] != string(datastore.InstalledStatus) {
-			t.Fatalf("expected module's status to be installed, got: %s", moduleStatus["status"])
+			t.Fatalf("expected module's status to be installed, got: %v", moduleStatus["status"])
+		}
+
+		for _, key := range []string{"checksum", "sourcePath", "firstInstalled", "lastUpdated", "backend"} {
+			if v, ok := moduleStatus[key].(string); !ok || v == "" {
+				t.Errorf("expected status to contain %s, got: %v", key, moduleStatus)
+			}
+		}
+		for _, key := range []string{"sourceSize", "installAttempts", "priority", "ownedPriority"} {
+			if v, ok := moduleStatus[key].(float64); !ok || v <= 0 {
+				t.Errorf("expected status to contain %s as a number, got: %v", key, moduleStatus)
+			}
 		}
 	})
 
+	t.Run("Sending a GET to the socket's /policies/<policy name>/history path should show the policy's history",
+		func(t *testing.T) {
+			ppath := fmt.Sprintf("http://unix/policies/%s/history", moduleName)
+			req, err := http.NewRequestWithContext(ctx, "GET", ppath, nil)
+			if err != nil {
+				t.Fatalf("failed getting request: %s", err)
+			}
+
+			response, err := httpc.Do(req)
+			if err != nil {
+				t.Fatalf("GET error on the socket: %s", err)
+			}
+			defer response.Body.Close()
+
+			var history []datastore.HistoryEntry
+			err = json.NewDecoder(response.Body).Decode(&history)
+			if err != nil {
+				t.Fatalf("cannot decode response: %s", err)
+			}
+
+			if len(history) == 0 || history[0].Action != datastore.HistoryInstall {
+				t.Fatalf("expected the history to start with the install, got: %+v", history)
+			}
+		})
+
 	t.Run("Module should remove a policy", func(t *testing.T) {
 		// We use the previously installed module
 		removePolicy(moduleName, moddir, t)
@@ -253,6 +301,10 @@ func TestDaemon(t *testing.T) {
 			if sh.IsModuleInstalled(moduleName) {
 				return errModuleInstalled
 			}
+			// The status is dropped right after removing the module
+			if _, err := ds.Get(moduleName); err == nil {
+				return errModuleInstalled
+			}
 			return nil
 		}, backoff.WithMaxRetries(backoff.NewConstantBackOff(defaultPollBackOff), 5))
 		if err != nil {
@@ -346,6 +398,67 @@ func TestDaemon(t *testing.T) {
 			t.Fatalf("%s", err)
 		}
 	})
+
+	t.Run("Renaming a policy should remove the old one and install the new one", func(t *testing.T) {
+		installPolicy("beforerename", moddir, t)
+		err := backoff.Retry(func() error {
+			if !sh.IsModuleInstalled("beforerename") {
+				return errModuleNotInstalled
+			}
+			return nil
+		}, backoff.WithMaxRetries(backoff.NewConstantBackOff(defaultPollBackOff), 5))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		err = os.Rename(getPolicyPath("beforerename", moddir), getPolicyPath("afterrename", moddir))
+		if err != nil {
+			t.Fatalf("Unable to rename policy: %s", err)
+		}
+
+		err = backoff.Retry(func() error {
+			if sh.IsModuleInstalled("beforerename") {
+				return errModuleInstalled
+			}
+			if !sh.IsModuleInstalled("afterrename") {
+				return errModuleNotInstalled
+			}
+			return nil
+		}, backoff.WithMaxRetries(backoff.NewConstantBackOff(defaultPollBackOff), 5))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	})
+
+	t.Run("Saving a policy atomically should only install the policy", func(t *testing.T) {
+		tmpPath := getPolicyPath("atomicsave", moddir) + ".tmp"
+		if err := os.WriteFile(tmpPath, []byte("(type atomicsave_t)"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmpPath, getPolicyPath("atomicsave", moddir)); err != nil {
+			t.Fatalf("Unable to rename policy: %s", err)
+		}
+
+		err := backoff.Retry(func() error {
+			if !sh.IsModuleInstalled("atomicsave") {
+				return errModuleNotInstalled
+			}
+			return nil
+		}, backoff.WithMaxRetries(backoff.NewConstantBackOff(defaultPollBackOff), 5))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		policies, err := ds.List()
+		if err != nil {
+			t.Fatalf("Unable to list policies: %s", err)
+		}
+		for _, policy := range policies {
+			if policy != "afterrename" && policy != "atomicsave" {
+				t.Errorf("unexpected policy in datastore: %s", policy)
+			}
+		}
+	})
 }
 
 func TestDaemonWithSubdir(t *testing.T) {
@@ -370,6 +483,9 @@ func TestDaemonWithSubdir(t *testing.T) {
 	sockpath := filepath.Join(dir, "selinuxd.sock")
 	dbpath := filepath.Join(dir, "selinuxd.db")
 	defer os.RemoveAll(dir) // clean up
+	httpc := getHTTPClient(sockpath)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
 
 	config := SelinuxdOptions{
 		StatusServerConfig: StatusServerConfig{
@@ -398,7 +514,7 @@ func TestDaemonWithSubdir(t *testing.T) {
 		installPolicy(subdirPolicy, subdirPath, t)
 	})
 
-	go Daemon(&config, moddir, sh, ds, done, zapr.NewLogger(logger))
+	go Daemon(&config, testModuleDirs(moddir), sh, ds, done, zapr.NewLogger(logger))
 	defer close(done)
 
 	t.Run("Module should track a policy in pre-existing sub-directory", func(t *testing.T) {
@@ -414,6 +530,31 @@ func TestDaemonWithSubdir(t *testing.T) {
 		}
 	})
 
+	t.Run("The ready status should account for the pre-existing policy", func(t *testing.T) {
+		var status ReadyStatus
+		err := backoff.Retry(func() error {
+			response, err := httpc.Do(getReadyRequest(ctx, t))
+			if err != nil {
+				return err
+			}
+			defer response.Body.Close()
+			if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+				return backoff.Permanent(err)
+			}
+			if !status.Ready {
+				return errInstallNotPerfomedYet
+			}
+			return nil
+		}, backoff.WithMaxRetries(backoff.NewConstantBackOff(defaultPollBackOff), 5))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		if status.Installed != 1 || status.Failed != 0 || status.Pending != 0 {
+			t.Fatalf("expected one installed policy, got: %+v", status)
+		}
+	})
+
 	t.Run("Module should stop tracking a policy in sub-directory", func(t *testing.T) {
 		os.RemoveAll(subdirPath)
 
@@ -429,3 +570,350 @@ func TestDaemonWithSubdir(t *testing.T) {
 		}
 	})
 }
+
+func TestRemoveOrphanedPolicies(t *testing.T) {
+	moddir := t.TempDir()
+
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	installPolicy("present", moddir, t)
+	for _, policy := range []string{"present", "orphan"} {
+		if err := ds.Put(datastore.PolicyStatus{Policy: policy, Status: datastore.InstalledStatus}); err != nil {
+			t.Fatalf("Unable to persist policy status: %s", err)
+		}
+	}
+
+	policyops := NewActionQueue(0)
+	if err := RemoveOrphanedPolicies(testModuleDirs(moddir), ScanOptions{}, ds.GetReadOnly(), policyops); err != nil {
+		t.Errorf("Unexpected error removing orphaned policies: %s", err)
+	}
+	policyops.ShutDown()
+
+	actions := make([]string, 0)
+	batch, _ := policyops.get()
+	for _, action := range batch {
+		actions = append(actions, action.String())
+	}
+
+	if len(actions) != 1 || actions[0] != "remove - orphan" {
+		t.Fatalf("expected only 'orphan' to be removed, got: %v", actions)
+	}
+}
+
+func TestApplyBatch(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatalf("Couldn't initialize logger: %s", err)
+	}
+	moddir := t.TempDir()
+
+	installPolicy("first", moddir, t)
+	installPolicy("second", moddir, t)
+	batch := []PolicyAction{
+		newInstallAction(getPolicyPath("first", moddir)),
+		newInstallAction(getPolicyPath("second", moddir)),
+	}
+
+	t.Run("A batch should be committed once", func(t *testing.T) {
+		sh := test.NewSEModuleTestHandler()
+		ds, err := datastore.NewTestCountedDS("memory://")
+		if err != nil {
+			t.Fatalf("Unable to get R/W datastore: %s", err)
+		}
+		defer ds.Close()
+
+		applyBatch(testConfig(moddir), sh, ds, batch, zapr.NewLogger(logger))
+
+		if sh.CommitCalls() != 1 {
+			t.Errorf("expected one commit, got: %d", sh.CommitCalls())
+		}
+		if ds.PutCalls() != 2 {
+			t.Errorf("expected two datastore updates, got: %d", ds.PutCalls())
+		}
+	})
+
+	t.Run("A batch that fails to commit should be applied one by one", func(t *testing.T) {
+		sh := test.NewSEModuleTestHandler()
+		ds, err := datastore.NewTestCountedDS("memory://")
+		if err != nil {
+			t.Fatalf("Unable to get R/W datastore: %s", err)
+		}
+		defer ds.Close()
+
+		sh.FailNextCommit()
+		applyBatch(testConfig(moddir), sh, ds, batch, zapr.NewLogger(logger))
+
+		// Only the outcome of the installs that were committed is recorded
+		if ds.PutCalls() != 2 {
+			t.Errorf("expected the policies to be recorded once, got %d datastore updates", ds.PutCalls())
+		}
+		for _, mod := range []string{"first", "second"} {
+			status, err := ds.Get(mod)
+			if err != nil {
+				t.Fatalf("Unable to get policy status: %s", err)
+			}
+			if status.Status != datastore.InstalledStatus {
+				t.Errorf("expected %s to be installed, got: %s", mod, status.Status)
+			}
+		}
+	})
+
+	t.Run("The datastore should only be updated once the batch is committed", func(t *testing.T) {
+		sh := test.NewSEModuleTestHandler()
+		ds := datastore.NewMemory()
+		defer ds.Close()
+
+		sh.FailNextCommit()
+		sh.OnNextCommit(func() {
+			for _, mod := range []string{"first", "second"} {
+				if ps, err := ds.Get(mod); !errors.Is(err, datastore.ErrPolicyNotFound) {
+					t.Errorf("expected %s not to be recorded before the commit, got: %+v", mod, ps)
+				}
+			}
+			// The install fails when it's applied on its own
+			sh.FailInstalls("second", 1)
+		})
+		applyBatch(testConfig(moddir), sh, ds, batch, zapr.NewLogger(logger))
+
+		first, err := ds.Get("first")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if first.Status != datastore.InstalledStatus || first.OwnedPriority != DefaultPriority {
+			t.Errorf("expected first to be installed and owned, got: %+v", first)
+		}
+		second, err := ds.Get("second")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if second.Status != datastore.FailedStatus || second.OwnedPriority != 0 || second.FirstInstalled != nil {
+			t.Errorf("expected second to have failed, and not to be owned, got: %+v", second)
+		}
+		if sh.IsModuleInstalled("second") {
+			t.Errorf("expected the failed commit to be rolled back")
+		}
+		history, err := ds.History("second")
+		if err != nil {
+			t.Fatalf("Unable to get policy history: %s", err)
+		}
+		if len(history) != 1 || history[0].Action != datastore.HistoryFailure {
+			t.Errorf("expected only the failure to be recorded, got: %+v", history)
+		}
+	})
+}
+
+func TestInitialScanStatus(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatalf("Couldn't initialize logger: %s", err)
+	}
+	moddir := t.TempDir()
+
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	policyops := NewActionQueue(0)
+	scan := newInitialScan(policyops, zapr.NewLogger(logger))
+	scan.Add(newInstallAction(getPolicyPath("good", moddir)))
+	scan.Add(newInstallAction(getPolicyPath("bad", moddir)))
+	// Another file provides the same policy, which is counted once
+	scan.Add(newInstallAction(getPolicyPath("good", filepath.Join(moddir, "sub"))))
+	scan.finish()
+
+	if rs := scan.status(ds, false); rs.Ready || rs.Pending != 3 {
+		t.Fatalf("expected three pending operations, got: %+v", rs)
+	}
+
+	for _, ps := range []datastore.PolicyStatus{
+		{Policy: "good", Status: datastore.InstalledStatus},
+		{Policy: "bad", Status: datastore.FailedStatus},
+	} {
+		if err := ds.Put(ps); err != nil {
+			t.Fatalf("Unable to persist policy status: %s", err)
+		}
+	}
+	batch, _ := policyops.get()
+	notifyApplied(batch)
+
+	rs := scan.status(ds, false)
+	if !rs.Ready || rs.Installed != 1 || rs.Failed != 1 || rs.Pending != 0 {
+		t.Fatalf("expected to be ready with one installed and one failed policy, got: %+v", rs)
+	}
+
+	if rs := scan.status(ds, true); rs.Ready {
+		t.Fatalf("expected not to be ready in strict mode, got: %+v", rs)
+	}
+}
+
+func TestDaemonWithConfigMapLayout(t *testing.T) {
+	done := make(chan bool)
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatalf("Couldn't initialize logger: %s", err)
+	}
+
+	moddir := t.TempDir()
+	dir := t.TempDir()
+
+	config := SelinuxdOptions{
+		StatusServerConfig: StatusServerConfig{
+			Path: filepath.Join(dir, "selinuxd.sock"),
+			UID:  os.Getuid(),
+			GID:  os.Getuid(),
+		},
+		StatusDBPath: filepath.Join(dir, "selinuxd.db"),
+		ScanOptions: ScanOptions{
+			FollowSymlinks: true,
+		},
+	}
+
+	sh := test.NewSEModuleTestHandler()
+
+	ds, err := datastore.NewTestCountedDS(config.StatusDBPath)
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	// Lays out the policies the same way the kubelet does for ConfigMaps
+	updateVolume := func(version string, policies ...string) {
+		versionDir := filepath.Join(moddir, "..v"+version)
+		if err := os.Mkdir(versionDir, 0o700); err != nil {
+			t.Fatalf("Unable to create directory: %s", err)
+		}
+		for _, policy := range policies {
+			content := []byte("; version " + version)
+			if err := os.WriteFile(getPolicyPath(policy, versionDir), content, 0o600); err != nil {
+				t.Fatalf("Unable to write policy: %s", err)
+			}
+		}
+		tmpLink := filepath.Join(moddir, "..data_tmp")
+		if err := os.Symlink(filepath.Base(versionDir), tmpLink); err != nil {
+			t.Fatalf("Unable to create symlink: %s", err)
+		}
+		if err := os.Rename(tmpLink, filepath.Join(moddir, atomicDataDir)); err != nil {
+			t.Fatalf("Unable to swap data directory: %s", err)
+		}
+		for _, policy := range policies {
+			link := getPolicyPath(policy, moddir)
+			if _, err := os.Lstat(link); err == nil {
+				continue
+			}
+			if err := os.Symlink(getPolicyPath(policy, atomicDataDir), link); err != nil {
+				t.Fatalf("Unable to create symlink: %s", err)
+			}
+		}
+	}
+
+	updateVolume("1", "cmpolicy")
+
+	go Daemon(&config, testModuleDirs(moddir), sh, ds, done, zapr.NewLogger(logger))
+	defer close(done)
+
+	t.Run("Should install the policies in the volume", func(t *testing.T) {
+		err := backoff.Retry(func() error {
+			if !sh.IsModuleInstalled("cmpolicy") {
+				return errModuleNotInstalled
+			}
+			// The status is persisted right after installing the module
+			_, err := ds.Get("cmpolicy")
+			return err
+		}, backoff.WithMaxRetries(backoff.NewConstantBackOff(defaultPollBackOff), 5))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		policies, err := ds.List()
+		if err != nil {
+			t.Fatalf("Unable to list policies: %s", err)
+		}
+		if len(policies) != 1 {
+			t.Fatalf("expected only the symlinked policy to be tracked, got: %v", policies)
+		}
+	})
+
+	t.Run("Should update the policies when the volume is swapped", func(t *testing.T) {
+		initial, err := ds.Get("cmpolicy")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+
+		updateVolume("2", "cmpolicy", "cmother")
+
+		err = backoff.Retry(func() error {
+			if !sh.IsModuleInstalled("cmother") {
+				return errModuleNotInstalled
+			}
+			current, err := ds.Get("cmpolicy")
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(initial.Checksum, current.Checksum) {
+				return errInstallNotPerfomedYet
+			}
+			return nil
+		}, backoff.WithMaxRetries(backoff.NewConstantBackOff(defaultPollBackOff), 5))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	})
+}
+
+func TestWatchFilesWaitsForCompleteWrites(t *testing.T) {
+	moddir := t.TempDir()
+	watcher, err := inotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("Unable to get inotify watcher: %s", err)
+	}
+	defer watcher.Close()
+	if err := watcher.Add(moddir); err != nil {
+		t.Fatalf("Unable to watch the module directory: %s", err)
+	}
+
+	window := 20 * time.Millisecond
+	policyops := NewActionQueue(window)
+	defer policyops.ShutDown()
+	go watchFiles(watcher, testModuleDirs(moddir), ScanOptions{}, policyops, logr.Discard())
+
+	path := getPolicyPath("slow", moddir)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The writer stalls for longer than the queue delays a burst for
+	for _, part := range []string{"(type ", "slow", "_t)"} {
+		if _, err := f.WriteString(part); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(maxDebounceFactor * window * 2)
+		if n := policyops.Len(); n != 0 {
+			t.Fatalf("expected nothing to be queued while the file is written, got %d operations", n)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	err = backoff.Retry(func() error {
+		if policyops.Len() == 0 {
+			return errInstallNotPerfomedYet
+		}
+		return nil
+	}, backoff.WithMaxRetries(backoff.NewConstantBackOff(window), 100))
+	if err != nil {
+		t.Fatalf("expected the policy to be installed once the file was closed: %s", err)
+	}
+	batch, _ := policyops.get()
+	if got := batchStrings(batch); len(got) != 1 || got[0] != "install - "+path {
+		t.Fatalf("expected a single install of %s, got: %v", path, got)
+	}
+}
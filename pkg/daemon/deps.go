@@ -0,0 +1,271 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/containers/selinuxd/pkg/cil"
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/utils"
+)
+
+// requiresDirective declares the modules that a CIL policy depends on, for
+// the dependencies that can't be inferred from the policy itself:
+//
+//	; selinuxd:requires base_policy, other_policy
+const requiresDirective = "selinuxd:requires"
+
+// cilDeclarations are the CIL statements that declare a named symbol as
+// their first argument.
+var cilDeclarations = map[string]bool{
+	"block": true, "macro": true, "type": true, "typealias": true, "typeattribute": true,
+	"role": true, "roleattribute": true, "user": true, "userattribute": true,
+	"boolean": true, "tunable": true, "class": true, "common": true,
+	"classpermission": true, "classmap": true, "sensitivity": true, "sensitivityalias": true,
+	"category": true, "categoryalias": true, "categoryset": true, "level": true,
+	"levelrange": true, "context": true, "ipaddr": true,
+}
+
+// cilSymbols holds what a CIL policy declares and what it references
+type cilSymbols struct {
+	declared   map[string]bool
+	referenced map[string]bool
+	// params are the names of macro parameters, which can't be
+	// referenced from other policies.
+	params map[string]bool
+	// requires are the dependencies declared with `requiresDirective`
+	requires []string
+}
+
+// scanCILSymbols extracts the symbols of a CIL policy. This is a best
+// effort: files that aren't CIL, or that can't be parsed, only get their
+// explicit dependencies extracted. The latter are refused on install.
+func scanCILSymbols(src *policySource) (*cilSymbols, error) {
+	syms := &cilSymbols{
+		declared:   make(map[string]bool),
+		referenced: make(map[string]bool),
+		params:     make(map[string]bool),
+	}
+	if utils.PolicyLanguage(src.path) != "cil" {
+		return syms, nil
+	}
+
+	data, err := src.moduleData()
+	if err != nil {
+		return nil, err
+	}
+	syms.requires = requiresFromComments(data)
+
+	nodes, parseErr := cil.Parse(data)
+	if parseErr != nil {
+		return syms, nil
+	}
+	for _, n := range nodes {
+		syms.collect(n, "")
+	}
+	return syms, nil
+}
+
+// requiresFromComments returns the modules listed in `requiresDirective`
+// comments
+func requiresFromComments(data []byte) []string {
+	requires := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, ";") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimLeft(line, ";"))
+		if !strings.HasPrefix(line, requiresDirective) {
+			continue
+		}
+		fields := strings.FieldsFunc(strings.TrimPrefix(line, requiresDirective), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		requires = append(requires, fields...)
+	}
+	return requires
+}
+
+// collect walks the expression, recording declarations qualified by the
+// blocks they are nested in.
+func (cs *cilSymbols) collect(n *cil.Node, scope string) {
+	if !n.IsList() {
+		// Quoted strings are file paths and such, they never
+		// reference symbols.
+		if !n.Quoted {
+			cs.referenced[strings.TrimPrefix(n.Atom, ".")] = true
+		}
+		return
+	}
+	keyword := n.Keyword()
+	if len(n.Children) >= 2 && !n.Children[1].IsList() && cilDeclarations[keyword] {
+		name := n.Children[1].Atom
+		cs.declared[scope+name] = true
+		if keyword == "block" {
+			scope = scope + name + "."
+		}
+		rest := n.Children[2:]
+		// Macro parameters are local to the macro
+		if keyword == "macro" && len(rest) > 0 {
+			cs.declareParams(rest[0])
+			rest = rest[1:]
+		}
+		for _, child := range rest {
+			cs.collect(child, scope)
+		}
+		return
+	}
+	for _, child := range n.Children {
+		cs.collect(child, scope)
+	}
+}
+
+func (cs *cilSymbols) declareParams(params *cil.Node) {
+	for _, p := range params.Children {
+		if len(p.Children) == 2 && !p.Children[1].IsList() {
+			cs.params[p.Children[1].Atom] = true
+		}
+	}
+}
+
+// dependencyGraph holds the policies that each policy in a batch depends on
+type dependencyGraph map[string][]string
+
+// dependenciesOf works out the dependencies of the policies installed by
+// `batch`. Dependencies are either declared explicitly, or inferred from a
+// policy referencing a symbol declared by another policy in the batch.
+func dependenciesOf(batch []PolicyAction) dependencyGraph {
+	symbols := make(map[string]*cilSymbols)
+	for _, action := range batch {
+		pi, ok := unwrapAction(action).(*policyInstall)
+		if !ok {
+			continue
+		}
+		src, err := pi.source()
+		if err != nil {
+			continue
+		}
+		syms, err := scanCILSymbols(src)
+		if err != nil {
+			continue
+		}
+		symbols[action.affectedPolicy()] = syms
+	}
+
+	// Symbols declared by more than one policy are ambiguous, and
+	// don't tell us anything.
+	declarers := make(map[string]string)
+	for policy, syms := range symbols {
+		for sym := range syms.declared {
+			if other, ok := declarers[sym]; ok && other != policy {
+				declarers[sym] = ""
+				continue
+			}
+			declarers[sym] = policy
+		}
+	}
+
+	graph := make(dependencyGraph)
+	for policy, syms := range symbols {
+		deps := make(map[string]bool)
+		for _, req := range syms.requires {
+			deps[req] = true
+		}
+		for ref := range syms.referenced {
+			if syms.declared[ref] || syms.params[ref] {
+				continue
+			}
+			for _, sym := range qualifiedPrefixes(ref) {
+				if declarer := declarers[sym]; declarer != "" && declarer != policy {
+					deps[declarer] = true
+				}
+			}
+		}
+		for dep := range deps {
+			graph[policy] = append(graph[policy], dep)
+		}
+		sort.Strings(graph[policy])
+	}
+	return graph
+}
+
+// qualifiedPrefixes returns `a`, `a.b` and `a.b.c` for `a.b.c`
+func qualifiedPrefixes(sym string) []string {
+	parts := strings.Split(sym, ".")
+	prefixes := make([]string, 0, len(parts))
+	for i := range parts {
+		prefixes = append(prefixes, strings.Join(parts[:i+1], "."))
+	}
+	return prefixes
+}
+
+// orderByDependencies sorts the batch so that policies get installed after
+// the policies they depend on. Otherwise, the order of the batch is kept.
+// Policies in a dependency cycle are left in their original order.
+func orderByDependencies(batch []PolicyAction, graph dependencyGraph) []PolicyAction {
+	inBatch := make(map[string]bool)
+	for _, action := range batch {
+		if policy := action.affectedPolicy(); policy != "" {
+			inBatch[policy] = true
+		}
+	}
+
+	ordered := make([]PolicyAction, 0, len(batch))
+	placed := make(map[int]bool)
+	done := make(map[string]bool)
+	for len(ordered) < len(batch) {
+		progress := false
+		for i, action := range batch {
+			if placed[i] || !dependenciesMet(action.affectedPolicy(), graph, inBatch, done) {
+				continue
+			}
+			ordered = append(ordered, action)
+			placed[i] = true
+			done[action.affectedPolicy()] = true
+			progress = true
+		}
+		if progress {
+			continue
+		}
+		// There's a cycle; place the first remaining action to break it
+		for i, action := range batch {
+			if !placed[i] {
+				ordered = append(ordered, action)
+				placed[i] = true
+				done[action.affectedPolicy()] = true
+				break
+			}
+		}
+	}
+	return ordered
+}
+
+func dependenciesMet(policy string, graph dependencyGraph, inBatch, done map[string]bool) bool {
+	for _, dep := range graph[policy] {
+		if inBatch[dep] && !done[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// blockingDependency returns a dependency of the policy that failed, either
+// in the current batch or before it, if any.
+func blockingDependency(policy string, graph dependencyGraph, failed map[string]bool,
+	ds datastore.ReadOnlyDataStore,
+) string {
+	for _, dep := range graph[policy] {
+		if failed[dep] {
+			return dep
+		}
+		ps, err := ds.Get(dep)
+		if err == nil && (ps.Status == datastore.FailedStatus || ps.Status == datastore.BlockedStatus) {
+			return dep
+		}
+	}
+	return ""
+}
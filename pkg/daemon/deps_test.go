@@ -0,0 +1,152 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule/test"
+	"github.com/go-logr/logr"
+)
+
+func writePolicy(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := getPolicyPath(name, dir)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDependencyOrdering(t *testing.T) {
+	moddir := t.TempDir()
+	batch := []PolicyAction{
+		newInstallAction(writePolicy(t, moddir, "app", `
+(type app_t)
+(allow app_t zbase_t (file (read)))
+`)),
+		newInstallAction(writePolicy(t, moddir, "explicit", `
+; selinuxd:requires zbase
+(type explicit_t)
+`)),
+		newInstallAction(writePolicy(t, moddir, "inherits", `
+(block inherits
+    (blockinherit ztemplate.container)
+    (macro local_macro ((type zbase_t))
+        (allow zbase_t self (file (read))))
+)
+`)),
+		newInstallAction(writePolicy(t, moddir, "standalone", `(type standalone_t)`)),
+		newInstallAction(writePolicy(t, moddir, "ztemplate", `
+(block ztemplate
+    (blockinherit zbase)
+    (block container (type process)))
+`)),
+		newInstallAction(writePolicy(t, moddir, "zbase", `
+(type zbase_t)
+(block zbase (type process))
+`)),
+	}
+
+	graph := dependenciesOf(batch)
+	expected := map[string][]string{
+		"app":       {"zbase"},
+		"explicit":  {"zbase"},
+		"inherits":  {"ztemplate"},
+		"ztemplate": {"zbase"},
+	}
+	for policy, deps := range expected {
+		if len(graph[policy]) != len(deps) || graph[policy][0] != deps[0] {
+			t.Errorf("expected %s to depend on %v, got: %v", policy, deps, graph[policy])
+		}
+	}
+	for _, policy := range []string{"standalone", "zbase"} {
+		if len(graph[policy]) != 0 {
+			t.Errorf("expected %s to have no dependencies, got: %v", policy, graph[policy])
+		}
+	}
+
+	order := make([]string, 0, len(batch))
+	for _, action := range orderByDependencies(batch, graph) {
+		order = append(order, action.key())
+	}
+	position := make(map[string]int)
+	for i, policy := range order {
+		position[policy] = i
+	}
+	for policy, deps := range graph {
+		for _, dep := range deps {
+			if position[dep] > position[policy] {
+				t.Errorf("expected %s to be installed before %s, got: %v", dep, policy, order)
+			}
+		}
+	}
+	if position["standalone"] > position["ztemplate"] {
+		t.Errorf("expected the order of independent policies to be kept, got: %v", order)
+	}
+}
+
+func TestDependencyCycle(t *testing.T) {
+	moddir := t.TempDir()
+	batch := []PolicyAction{
+		newInstallAction(writePolicy(t, moddir, "first", "(type first_t)\n(allow first_t second_t (file (read)))")),
+		newInstallAction(writePolicy(t, moddir, "second", "(type second_t)\n(allow second_t first_t (file (read)))")),
+	}
+	if ordered := orderByDependencies(batch, dependenciesOf(batch)); len(ordered) != len(batch) {
+		t.Fatalf("expected every policy to be installed, got: %v", ordered)
+	}
+}
+
+func TestBlockedDependents(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	batch := []PolicyAction{
+		newInstallAction(writePolicy(t, moddir, "app", "(type app_t)\n(allow app_t base_t (file (read)))")),
+		newInstallAction(writePolicy(t, moddir, "standalone", "(type standalone_t)")),
+		newInstallAction(writePolicy(t, moddir, "base", "(type base_t)")),
+	}
+	sh.FailInstalls("base", 1)
+	results := applyBatch(testConfig(moddir), sh, ds, batch, logr.Discard())
+
+	for _, res := range results {
+		if res.action.key() == "app" && !errors.Is(res.err, errBlocked) {
+			t.Errorf("expected 'app' to be blocked, got: %v", res.err)
+		}
+	}
+	if sh.IsModuleInstalled("app") {
+		t.Errorf("expected 'app' not to be attempted")
+	}
+	if !sh.IsModuleInstalled("standalone") {
+		t.Errorf("expected 'standalone' to be installed")
+	}
+
+	ps, err := ds.Get("app")
+	if err != nil {
+		t.Fatalf("Unable to get policy status: %s", err)
+	}
+	if ps.Status != datastore.BlockedStatus {
+		t.Errorf("expected 'app' to be blocked, got: %+v", ps)
+	}
+
+	// Once the dependency is fixed, the dependent goes through
+	results = applyBatch(testConfig(moddir), sh, ds, []PolicyAction{
+		asRetry(batch[2]),
+		asRetry(batch[0]),
+	}, logr.Discard())
+	for _, res := range results {
+		if res.err != nil {
+			t.Errorf("unexpected error applying %s: %s", res.action, res.err)
+		}
+	}
+	if !sh.IsModuleInstalled("app") {
+		t.Errorf("expected 'app' to be installed")
+	}
+}
@@ -2,8 +2,10 @@ package daemon
 
 import (
 	"os"
+	"path/filepath"
 
-	"github.com/fsnotify/fsnotify"
+	"github.com/containers/selinuxd/pkg/inotify"
+	"github.com/containers/selinuxd/pkg/utils"
 )
 
 type fileOperationDispatch uint8
@@ -13,31 +15,76 @@ const (
 	dispatchDirectoryAddition
 	dispatchRemoval
 	dispatchSymlink
+	dispatchBookkeeping
+	dispatchAtomicUpdate
+	dispatchTemporary
+	dispatchIncomplete
 	dispatchUnkown
 )
 
-func dispatch(e fsnotify.Event) fileOperationDispatch {
-	// Since the file was removed, we can't stat
+// dispatch tells what to do about the event. Files are only added once
+// they're complete, i.e. once they're closed after being written, or moved
+// in, so a slow writer doesn't get a partial policy installed.
+func dispatch(e inotify.Event, opts ScanOptions) fileOperationDispatch {
+	if opts.FollowSymlinks && utils.IsBookkeepingEntry(e.Name) {
+		// The `..data` symlink is renamed over the old one
+		if filepath.Base(e.Name) == atomicDataDir && e.Op.Has(inotify.Create|inotify.MovedTo) {
+			return dispatchAtomicUpdate
+		}
+		return dispatchBookkeeping
+	}
+
+	// Editors and tools write to temporary files and rename them
+	// over the actual file; we only care about the latter.
+	if utils.IsTemporaryFile(e.Name) {
+		return dispatchTemporary
+	}
+
+	finfo, err := os.Lstat(e.Name)
+
+	// Since the file was removed or renamed, we can't stat
 	// the file or directory, so we have a generic removal
-	// dispatcher
-	if e.Op&fsnotify.Remove != 0 {
-		return dispatchRemoval
+	// dispatcher. If we can stat it, it was replaced in the
+	// meantime (e.g. by an editor doing an atomic save), and
+	// the replacement gets its own events once it's complete.
+	if e.Op.Has(inotify.Remove | inotify.Rename) {
+		if err != nil {
+			return dispatchRemoval
+		}
+		return dispatchIncomplete
 	}
 
-	finfo, err := os.Stat(e.Name)
 	if err != nil {
 		return dispatchUnkown
 	}
 
 	if finfo.Mode()&os.ModeSymlink == os.ModeSymlink {
-		return dispatchSymlink
+		if !opts.FollowSymlinks {
+			return dispatchSymlink
+		}
+		// We only follow symlinks to files
+		if _, ok := resolvePolicySymlink(e.Name); !ok {
+			return dispatchUnkown
+		}
+		// Symlinks are created with their destination
+		if e.Op.Has(inotify.Create | inotify.MovedTo) {
+			return dispatchFileAddition
+		}
+		return dispatchUnkown
 	}
 
-	if e.Op&(fsnotify.Write|fsnotify.Create) != 0 {
-		if finfo.IsDir() {
+	if finfo.IsDir() {
+		if e.Op.Has(inotify.Create | inotify.MovedTo) {
 			return dispatchDirectoryAddition
 		}
+		return dispatchUnkown
+	}
+	if e.Op.Has(inotify.CloseWrite | inotify.MovedTo) {
 		return dispatchFileAddition
 	}
+	// The file is still being written
+	if e.Op.Has(inotify.Create | inotify.Write) {
+		return dispatchIncomplete
+	}
 	return dispatchUnkown
 }
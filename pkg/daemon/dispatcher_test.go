@@ -0,0 +1,49 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/selinuxd/pkg/inotify"
+)
+
+func TestDispatch(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.cil")
+	if err := os.WriteFile(existing, []byte("(type test_t)"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.cil")
+	inDir := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	tests := []struct {
+		name  string
+		event inotify.Event
+		want  fileOperationDispatch
+	}{
+		{"created file", inotify.Event{Name: existing, Op: inotify.Create}, dispatchIncomplete},
+		{"written file", inotify.Event{Name: existing, Op: inotify.Write}, dispatchIncomplete},
+		{"closed file", inotify.Event{Name: existing, Op: inotify.CloseWrite}, dispatchFileAddition},
+		{"file moved in", inotify.Event{Name: existing, Op: inotify.MovedTo}, dispatchFileAddition},
+		{"removed file", inotify.Event{Name: missing, Op: inotify.Remove}, dispatchRemoval},
+		{"file renamed away", inotify.Event{Name: missing, Op: inotify.Rename}, dispatchRemoval},
+		{"file renamed and replaced", inotify.Event{Name: existing, Op: inotify.Rename}, dispatchIncomplete},
+		{"created directory", inotify.Event{Name: dir, Op: inotify.Create}, dispatchDirectoryAddition},
+		{"directory moved in", inotify.Event{Name: dir, Op: inotify.MovedTo}, dispatchDirectoryAddition},
+		{"vim swap file", inotify.Event{Name: inDir(".existing.cil.swp"), Op: inotify.Create}, dispatchTemporary},
+		{"backup file", inotify.Event{Name: inDir("existing.cil~"), Op: inotify.Create}, dispatchTemporary},
+		{"temporary file", inotify.Event{Name: inDir("existing.cil.tmp"), Op: inotify.Rename}, dispatchTemporary},
+		{"emacs lock file", inotify.Event{Name: inDir(".#existing.cil"), Op: inotify.Create}, dispatchTemporary},
+		{"chmod", inotify.Event{Name: existing, Op: inotify.Chmod}, dispatchUnkown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dispatch(tt.event, ScanOptions{}); got != tt.want {
+				t.Errorf("dispatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package daemon
+
+import (
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containers/selinuxd/pkg/cil"
+	"github.com/containers/selinuxd/pkg/utils"
+)
+
+// policySource holds the files that make up a policy, as they were read
+// for an install. The checks and the install work on these contents, so
+// the policy file is read and decompressed once per install.
+type policySource struct {
+	path string
+	// data is the contents of the policy file
+	data []byte
+	// fc is the contents of the file contexts of a type-enforcement
+	// source, or nil if there are none
+	fc []byte
+	// iface is the contents of the interfaces of a type-enforcement
+	// source, or nil if there are none
+	iface []byte
+	// module is the decompressed module, once it was needed
+	module []byte
+}
+
+func readPolicySource(path string) (*policySource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy: %w", err)
+	}
+	src := &policySource{path: path, data: data}
+	if utils.IsSourcePolicy(path) {
+		if src.fc, err = readCompanion(utils.FileContextsPath(path)); err != nil {
+			return nil, fmt.Errorf("reading file contexts: %w", err)
+		}
+		if src.iface, err = readCompanion(utils.InterfacePath(path)); err != nil {
+			return nil, fmt.Errorf("reading interfaces: %w", err)
+		}
+	}
+	return src, nil
+}
+
+// readCompanion returns the contents of the companion file in `path`, or
+// nil if there's none
+func readCompanion(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err //nolint:wrapcheck // this is wrapped by the caller
+}
+
+// checksum returns the same checksum as utils.PolicyChecksum, out of the
+// contents that were read
+func (src *policySource) checksum() []byte {
+	cs := sha512.Sum512(src.data)
+	return utils.SourceChecksum(cs[:], companionSum(src.fc), companionSum(src.iface))
+}
+
+// companionSum returns the checksum of the contents of a companion file,
+// or nil if there's none
+func companionSum(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	cs := sha512.Sum512(data)
+	return cs[:]
+}
+
+// moduleData returns the module held in the policy file, decompressing it
+// the first time
+func (src *policySource) moduleData() ([]byte, error) {
+	if src.module == nil {
+		module, err := utils.DecompressPolicy(src.path, src.data)
+		if err != nil {
+			return nil, err //nolint:wrapcheck // DecompressPolicy already adds context
+		}
+		src.module = module
+	}
+	return src.module, nil
+}
+
+// stagePolicy returns the path of a file that the module handler can
+// install the policy in `src` from. The module is written into a temporary
+// `<policy>.<language>` file, decompressed if needed, as the handlers
+// derive the module name and language from the file name. Type-enforcement
+// sources are compiled into a temporary `<policy>.pp` module package.
+// Either way, the file is a copy that nobody else can change. The returned
+// cleanup function must be called once the file is no longer needed.
+func stagePolicy(cfg applyConfig, src *policySource, policyName string) (string, func(), error) {
+	path := src.path
+	dir, err := os.MkdirTemp("", "selinuxd-")
+	if err != nil {
+		return "", nil, fmt.Errorf("staging policy %s: %w", path, err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	var staged string
+	if utils.IsSourcePolicy(path) {
+		staged, err = compilePolicy(cfg, src, policyName, dir)
+	} else {
+		staged, err = decompressPolicy(src, policyName, dir)
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return staged, cleanup, nil
+}
+
+// decompressPolicy writes the module of the policy in `src` into a
+// `<policy>.<language>` file in `dir`, and returns its path. Modules that
+// aren't compressed are written as they are.
+func decompressPolicy(src *policySource, policyName, dir string) (string, error) {
+	data, err := src.moduleData()
+	if err != nil {
+		return "", err
+	}
+	staged := filepath.Join(dir, policyName+"."+utils.PolicyLanguage(src.path))
+	if err := os.WriteFile(staged, data, 0o600); err != nil {
+		return "", fmt.Errorf("staging policy %s: %w", src.path, err)
+	}
+	return staged, nil
+}
+
+// validatePolicy checks the syntax of CIL policies. Malformed policies are
+// refused before they reach the module handler, as they'd otherwise fail
+// the whole transaction they're committed in.
+func validatePolicy(src *policySource) error {
+	if utils.PolicyLanguage(src.path) != "cil" {
+		return nil
+	}
+	data, err := src.moduleData()
+	if err != nil {
+		return err
+	}
+	if _, err := cil.Validate(data); err != nil {
+		return fmt.Errorf("invalid policy %s: %w", src.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package daemon
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/selinuxd/pkg/cil"
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule/test"
+	"github.com/containers/selinuxd/pkg/utils"
+	"github.com/go-logr/logr"
+)
+
+// bzip2ContentPP is "(type pp_t)" compressed with bzip2, as the standard
+// library can't compress it.
+const bzip2ContentPP = "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\xfc\xde\x4b\xe9\x00\x00\x00\x93\x80" +
+	"\x40\x60\x00\x00\x82\x00\x44\x20\x20\x00\x22\x03\x1a\x84\x30\x21\x74\x96\x30\x7e\x2e\xe4" +
+	"\x8a\x70\xa1\x21\xf9\xbc\x97\xd2"
+
+func gzipContent(t *testing.T, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressedPolicies(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	files := map[string][]byte{
+		"gzipped.cil.gz": gzipContent(t, "(type gzipped_t)"),
+		"bzipped.pp.bz2": []byte(bzip2ContentPP),
+	}
+	expected := map[string]string{
+		"gzipped": "(type gzipped_t)",
+		"bzipped": "(type pp_t)",
+	}
+	languages := map[string]string{
+		"gzipped": "cil",
+		"bzipped": "pp",
+	}
+
+	for file, data := range files {
+		path := filepath.Join(moddir, file)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newInstallAction(path).do(testConfig(moddir), sh, ds); err != nil {
+			t.Fatalf("unexpected error installing %s: %s", file, err)
+		}
+	}
+
+	modules, err := sh.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != len(expected) {
+		t.Fatalf("expected %d modules, got: %+v", len(expected), modules)
+	}
+	for _, mod := range modules {
+		if mod.Language != languages[mod.Name] {
+			t.Errorf("expected module %s to have language %s, got: %s", mod.Name, languages[mod.Name], mod.Language)
+		}
+		data, err := sh.Extract(mod.Name, mod.Priority)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected[mod.Name] {
+			t.Errorf("expected module %s to be installed decompressed, got: %q", mod.Name, data)
+		}
+	}
+
+	t.Run("The checksum should be taken from the file on disk", func(t *testing.T) {
+		path := filepath.Join(moddir, "gzipped.cil.gz")
+		cs, err := utils.Checksum(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps, err := ds.Get("gzipped")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if !bytes.Equal(ps.Checksum, cs) || ps.SourcePath != path {
+			t.Fatalf("unexpected status: %+v", ps)
+		}
+	})
+
+	t.Run("A corrupted file should fail to install", func(t *testing.T) {
+		path := filepath.Join(moddir, "corrupted.cil.gz")
+		if err := os.WriteFile(path, []byte("(type corrupted_t)"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newInstallAction(path).do(testConfig(moddir), sh, ds); err == nil {
+			t.Fatalf("expected an error installing a corrupted file")
+		}
+		ps, err := ds.Get("corrupted")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if ps.Status != datastore.FailedStatus {
+			t.Fatalf("expected the policy to be marked as failed, got: %+v", ps)
+		}
+	})
+
+	t.Run("A file that decompresses into too much data should fail to install", func(t *testing.T) {
+		// Concatenated gzip members decompress as one stream
+		const chunk = 1 << 20
+		member := gzipContent(t, string(make([]byte, chunk)))
+		bomb := bytes.Repeat(member, utils.MaxPolicySize/chunk+1)
+		path := filepath.Join(moddir, "bomb.cil.gz")
+		if err := os.WriteFile(path, bomb, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newInstallAction(path).do(testConfig(moddir), sh, ds); !errors.Is(err, utils.ErrPolicyTooLarge) {
+			t.Fatalf("expected the policy to be too large, got: %v", err)
+		}
+		if sh.IsModuleInstalled("bomb") {
+			t.Fatalf("expected the policy not to be installed")
+		}
+	})
+}
+
+func TestInvalidPolicySyntax(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	valid := writePolicy(t, moddir, "valid", "(type valid_t)")
+	invalid := writePolicy(t, moddir, "invalid", "(type invalid_t)\n(type other_t\n")
+	results := applyBatch(testConfig(moddir), sh, ds, []PolicyAction{
+		newInstallAction(valid),
+		newInstallAction(invalid),
+	}, logr.Discard())
+
+	if results[0].err != nil {
+		t.Errorf("unexpected error installing the valid policy: %s", results[0].err)
+	}
+	if !errors.Is(results[1].err, cil.ErrSyntax) {
+		t.Errorf("expected a syntax error, got: %v", results[1].err)
+	}
+	if sh.CommitCalls() != 1 {
+		t.Errorf("expected the batch to be committed at once, got %d commits", sh.CommitCalls())
+	}
+	if !sh.IsModuleInstalled("valid") || sh.IsModuleInstalled("invalid") {
+		t.Errorf("expected only the valid policy to be installed")
+	}
+
+	ps, err := ds.Get("invalid")
+	if err != nil {
+		t.Fatalf("Unable to get policy status: %s", err)
+	}
+	if ps.Status != datastore.FailedStatus || !strings.Contains(ps.Message, invalid+": 2:1: unterminated list") {
+		t.Fatalf("expected the syntax error in the status, got: %+v", ps)
+	}
+}
+
+func TestInvalidModuleName(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds := datastore.NewMemory()
+	defer ds.Close()
+
+	valid := writePolicy(t, moddir, "valid", "(type valid_t)")
+	invalid := writePolicy(t, moddir, "1st", "(type first_t)")
+	results := applyBatch(testConfig(moddir), sh, ds, []PolicyAction{
+		newInstallAction(valid),
+		newInstallAction(invalid),
+	}, logr.Discard())
+
+	if results[0].err != nil {
+		t.Errorf("unexpected error installing the valid policy: %s", results[0].err)
+	}
+	if !errors.Is(results[1].err, utils.ErrInvalidName) {
+		t.Errorf("expected the module name to be refused, got: %v", results[1].err)
+	}
+	if sh.CommitCalls() != 1 {
+		t.Errorf("expected the batch to be committed at once, got %d commits", sh.CommitCalls())
+	}
+	if !sh.IsModuleInstalled("valid") || sh.IsModuleInstalled("1st") {
+		t.Errorf("expected only the valid policy to be installed")
+	}
+
+	ps, err := ds.Get("1st")
+	if err != nil {
+		t.Fatalf("Unable to get policy status: %s", err)
+	}
+	if ps.Status != datastore.FailedStatus {
+		t.Fatalf("expected the policy to fail, got: %+v", ps)
+	}
+}
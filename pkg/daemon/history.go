@@ -0,0 +1,29 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+)
+
+// historyEntry returns the entry that records `action` on the policy at
+// time `at`, as described by its status
+func historyEntry(action datastore.HistoryAction, ps datastore.PolicyStatus, at time.Time) datastore.HistoryEntry {
+	return datastore.HistoryEntry{
+		Time:     at,
+		Action:   action,
+		Status:   ps.Status,
+		Checksum: ps.Checksum,
+		Message:  ps.Message,
+	}
+}
+
+// recordHistory adds the entry to the history of the policy, keeping as
+// many entries as the configured retention allows
+func recordHistory(cfg applyConfig, ds datastore.DataStore, policy string, entry datastore.HistoryEntry) error {
+	if err := ds.AppendHistory(policy, entry, cfg.history); err != nil {
+		return fmt.Errorf("failed recording policy history: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule/test"
+)
+
+func TestPolicyHistory(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+	cfg := testConfig(moddir)
+
+	policy := writePolicy(t, moddir, "web", "(type web_t)")
+	if _, err := newInstallAction(policy).do(cfg, sh, ds); err != nil {
+		t.Fatalf("unexpected error installing the policy: %s", err)
+	}
+	writePolicy(t, moddir, "web", "(type web_t)\n(type other_t)")
+	sh.FailInstalls("web", 1)
+	if _, err := newInstallAction(policy).do(cfg, sh, ds); err == nil {
+		t.Fatalf("expected the update to fail")
+	}
+	if _, err := newRetryAction(policy, 1).do(cfg, sh, ds); err != nil {
+		t.Fatalf("unexpected error updating the policy: %s", err)
+	}
+	if err := os.Remove(policy); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newRemoveAction(policy).do(cfg, sh, ds); err != nil {
+		t.Fatalf("unexpected error removing the policy: %s", err)
+	}
+
+	history, err := ds.History("web")
+	if err != nil {
+		t.Fatalf("Unable to get policy history: %s", err)
+	}
+	expected := []datastore.HistoryAction{
+		datastore.HistoryInstall,
+		datastore.HistoryFailure,
+		datastore.HistoryUpdate,
+		datastore.HistoryRemove,
+	}
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d history entries, got: %+v", len(expected), history)
+	}
+	for i, entry := range history {
+		if entry.Action != expected[i] {
+			t.Errorf("expected entry %d to be %s, got: %+v", i, expected[i], entry)
+		}
+		if len(entry.Checksum) == 0 || entry.Time.IsZero() {
+			t.Errorf("expected entry %d to have a checksum and a time, got: %+v", i, entry)
+		}
+	}
+	if history[1].Status != datastore.FailedStatus || history[1].Message == "" {
+		t.Errorf("expected the failure to be recorded with its reason, got: %+v", history[1])
+	}
+	if history[1].Time.Before(history[0].Time) {
+		t.Errorf("expected the entries to be sorted by time, got: %+v", history)
+	}
+}
+
+func TestFailedRemovalHistory(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+	cfg := testConfig(moddir)
+
+	policy := writePolicy(t, moddir, "web", "(type web_t)")
+	if _, err := newInstallAction(policy).do(cfg, sh, ds); err != nil {
+		t.Fatalf("unexpected error installing the policy: %s", err)
+	}
+	if err := os.Remove(policy); err != nil {
+		t.Fatal(err)
+	}
+	sh.FailRemovals("web", 1)
+	if _, err := newRemoveAction(policy).do(cfg, sh, ds); !errors.Is(err, test.ErrTestRemove) {
+		t.Fatalf("expected the removal to fail, got: %v", err)
+	}
+
+	ps, err := ds.Get("web")
+	if err != nil {
+		t.Fatalf("expected the policy to be kept in the datastore: %s", err)
+	}
+	if ps.Status != datastore.FailedStatus || ps.Message == "" || ps.LastUpdated == nil {
+		t.Errorf("expected the failed removal to be persisted, got: %+v", ps)
+	}
+	if ps.OwnedPriority == 0 {
+		t.Errorf("expected the module to stay owned, got: %+v", ps)
+	}
+	history, err := ds.History("web")
+	if err != nil {
+		t.Fatalf("Unable to get policy history: %s", err)
+	}
+	last := history[len(history)-1]
+	if last.Action != datastore.HistoryFailure || !last.Time.Equal(*ps.LastUpdated) {
+		t.Errorf("expected the failure to be recorded at the time of the status, got: %+v", last)
+	}
+
+	// The removal is done once the file's deletion is processed again
+	if _, err := newRemoveAction(policy).do(cfg, sh, ds); err != nil {
+		t.Fatalf("unexpected error removing the policy: %s", err)
+	}
+	if sh.IsModuleInstalled("web") {
+		t.Errorf("expected the module to be removed")
+	}
+}
@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	seiface "github.com/containers/selinuxd/pkg/semodule/interface"
+)
+
+const (
+	// DefaultModulePath is the directory that policies are read from
+	// when no other one is configured.
+	DefaultModulePath = "/etc/selinux.d"
+	// DefaultPriority is the priority that policies are installed at,
+	// unless their module directory says otherwise.
+	DefaultPriority = seiface.DefaultPriority
+	// maxPriority is the highest priority that SELinux modules support
+	maxPriority = 999
+)
+
+var ErrInvalidModuleDir = errors.New("invalid module directory")
+
+// ModuleDir is a directory that policies are read from, and the priority
+// they get installed at. Policies in a directory with a higher priority
+// override the ones with the same name in a directory with a lower one.
+type ModuleDir struct {
+	Path     string
+	Priority uint16
+}
+
+// ModuleDirs are the directories that selinuxd manages policies from
+type ModuleDirs []ModuleDir
+
+// DefaultModuleDirs returns the module directories used when none
+// are configured.
+func DefaultModuleDirs() ModuleDirs {
+	return ModuleDirs{{Path: DefaultModulePath, Priority: DefaultPriority}}
+}
+
+// ParseModuleDir parses a module directory in the `path[:priority]`
+// format. The priority defaults to DefaultPriority.
+func ParseModuleDir(spec string) (ModuleDir, error) {
+	md := ModuleDir{Path: spec, Priority: DefaultPriority}
+	if idx := strings.LastIndex(spec, ":"); idx >= 0 {
+		prio, err := strconv.ParseUint(spec[idx+1:], 10, 16)
+		if err != nil || prio == 0 || prio > maxPriority {
+			return md, fmt.Errorf("%w: %s: the priority must be between 1 and %d",
+				ErrInvalidModuleDir, spec, maxPriority)
+		}
+		md.Path = spec[:idx]
+		md.Priority = uint16(prio)
+	}
+	if md.Path == "" {
+		return md, fmt.Errorf("%w: %s: empty path", ErrInvalidModuleDir, spec)
+	}
+	md.Path = filepath.Clean(md.Path)
+	return md, nil
+}
+
+// lookup returns the module directory that holds `path`. If directories
+// are nested, the innermost one is returned.
+func (mds ModuleDirs) lookup(path string) (ModuleDir, bool) {
+	var found ModuleDir
+	ok := false
+	for _, md := range mds {
+		if isWithin(md.Path, path) && (!ok || len(md.Path) > len(found.Path)) {
+			found = md
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// priorityOf returns the priority that the policy in `path` is installed at
+func (mds ModuleDirs) priorityOf(path string) uint16 {
+	if md, ok := mds.lookup(path); ok {
+		return md.Priority
+	}
+	return mds.defaultPriority()
+}
+
+// defaultPriority is the priority of policies that are known by name only
+func (mds ModuleDirs) defaultPriority() uint16 {
+	if len(mds) == 0 {
+		return DefaultPriority
+	}
+	return mds[0].Priority
+}
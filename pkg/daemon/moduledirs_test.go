@@ -0,0 +1,133 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule/test"
+)
+
+func TestParseModuleDir(t *testing.T) {
+	valid := map[string]ModuleDir{
+		"/etc/selinux.d":        {Path: "/etc/selinux.d", Priority: DefaultPriority},
+		"/etc/selinux.d/":       {Path: "/etc/selinux.d", Priority: DefaultPriority},
+		"/usr/share/vendor:200": {Path: "/usr/share/vendor", Priority: 200},
+		"/etc/admin:999":        {Path: "/etc/admin", Priority: 999},
+	}
+	for spec, expected := range valid {
+		md, err := ParseModuleDir(spec)
+		if err != nil {
+			t.Errorf("unexpected error parsing %s: %s", spec, err)
+			continue
+		}
+		if md != expected {
+			t.Errorf("expected %s to be parsed as %+v, got: %+v", spec, expected, md)
+		}
+	}
+
+	for _, spec := range []string{"", ":400", "/etc/selinux.d:0", "/etc/selinux.d:1000", "/etc/selinux.d:high"} {
+		if _, err := ParseModuleDir(spec); !errors.Is(err, ErrInvalidModuleDir) {
+			t.Errorf("expected %q to be invalid, got: %v", spec, err)
+		}
+	}
+}
+
+func TestModuleDirsLookup(t *testing.T) {
+	dirs := ModuleDirs{
+		{Path: "/etc/selinux.d", Priority: 400},
+		{Path: "/usr/share/selinuxd", Priority: 200},
+		{Path: "/etc/selinux.d/nested", Priority: 500},
+	}
+
+	expected := map[string]uint16{
+		"/etc/selinux.d/a.cil":        400,
+		"/usr/share/selinuxd/b.cil":   200,
+		"/etc/selinux.d/nested/c.cil": 500,
+		"/etc/selinux.d.bak/d.cil":    400,
+	}
+	for path, prio := range expected {
+		if got := dirs.priorityOf(path); got != prio {
+			t.Errorf("expected %s to have priority %d, got: %d", path, prio, got)
+		}
+	}
+	if _, ok := dirs.lookup("/etc/selinux.d.bak/d.cil"); ok {
+		t.Errorf("expected a path outside the module directories not to be found")
+	}
+}
+
+func TestPriorityOverride(t *testing.T) {
+	vendorDir := t.TempDir()
+	adminDir := t.TempDir()
+	dirs := ModuleDirs{
+		{Path: vendorDir, Priority: 200},
+		{Path: adminDir, Priority: 400},
+	}
+	sh := test.NewSEModuleTestHandler()
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	vendor := writePolicy(t, vendorDir, "web", "(type web_t)")
+	if _, err := newInstallAction(vendor).do(applyConfig{dirs: dirs}, sh, ds); err != nil {
+		t.Fatalf("unexpected error installing the vendor policy: %s", err)
+	}
+	if prios := sh.ModulePriorities("web"); !slices.Equal(prios, []uint16{200}) {
+		t.Fatalf("expected the policy to be installed at priority 200, got: %v", prios)
+	}
+
+	t.Run("A directory with a higher priority should override the policy", func(t *testing.T) {
+		admin := writePolicy(t, adminDir, "web", "(type web_t)\n(type admin_t)")
+		if _, err := newInstallAction(admin).do(applyConfig{dirs: dirs}, sh, ds); err != nil {
+			t.Fatalf("unexpected error installing the admin policy: %s", err)
+		}
+		if prios := sh.ModulePriorities("web"); !slices.Equal(prios, []uint16{400}) {
+			t.Fatalf("expected the policy to be installed at priority 400, got: %v", prios)
+		}
+		ps, err := ds.Get("web")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if ps.SourcePath != admin || ps.ModuleDir != adminDir || ps.Priority != 400 {
+			t.Fatalf("expected the policy to be provided by %s, got: %+v", admin, ps)
+		}
+		if !slices.Equal(ps.Conflicts, []string{vendor}) {
+			t.Fatalf("expected the vendor policy to be kept as a conflict, got: %v", ps.Conflicts)
+		}
+	})
+
+	t.Run("A directory with a lower priority shouldn't override the policy", func(t *testing.T) {
+		writePolicy(t, vendorDir, "web", "(type web_t)\n(type vendor_t)")
+		if _, err := newInstallAction(vendor).do(applyConfig{dirs: dirs}, sh, ds); !errors.Is(err, errPolicyConflict) {
+			t.Fatalf("expected a conflict, got: %v", err)
+		}
+		if prios := sh.ModulePriorities("web"); !slices.Equal(prios, []uint16{400}) {
+			t.Fatalf("expected the policy to stay at priority 400, got: %v", prios)
+		}
+	})
+
+	t.Run("Removing the override should bring back the vendor policy", func(t *testing.T) {
+		admin := getPolicyPath("web", adminDir)
+		if err := os.Remove(admin); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newRemoveAction(admin).do(applyConfig{dirs: dirs}, sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if prios := sh.ModulePriorities("web"); !slices.Equal(prios, []uint16{200}) {
+			t.Fatalf("expected the policy to be installed at priority 200, got: %v", prios)
+		}
+		ps, err := ds.Get("web")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if ps.SourcePath != vendor || ps.Priority != 200 || len(ps.Conflicts) != 0 {
+			t.Fatalf("expected the policy to be provided by %s, got: %+v", vendor, ps)
+		}
+	})
+}
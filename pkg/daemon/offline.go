@@ -0,0 +1,158 @@
+package daemon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	seiface "github.com/containers/selinuxd/pkg/semodule/interface"
+	"github.com/containers/selinuxd/pkg/utils"
+)
+
+// CheckProblem is an inconsistency between the datastore, the policy files
+// and the installed modules
+type CheckProblem string
+
+const (
+	// CheckUntracked is for policy files without a datastore entry
+	CheckUntracked CheckProblem = "untracked"
+	// CheckOrphaned is for datastore entries without a policy file
+	CheckOrphaned CheckProblem = "orphaned"
+	// CheckOutdated is for policies whose file changed since it was last
+	// processed
+	CheckOutdated CheckProblem = "outdated"
+	// CheckMissingModule is for policies whose module selinuxd installed,
+	// but isn't installed anymore
+	CheckMissingModule CheckProblem = "missing-module"
+	// CheckUnreadable is for datastore entries that can't be read
+	CheckUnreadable CheckProblem = "unreadable"
+	// CheckUnreadableFile is for policy files that can't be read
+	CheckUnreadableFile CheckProblem = "unreadable-file"
+)
+
+// CheckIssue describes an inconsistency found by CheckDataStore
+type CheckIssue struct {
+	Policy  string       `json:"policy"`
+	Problem CheckProblem `json:"problem"`
+	Detail  string       `json:"detail,omitempty"`
+}
+
+// CheckDataStore compares the datastore with the policy files in `dirs` and
+// the modules reported by the handler, the same way reconciliation passes do,
+// but only reports the inconsistencies instead of repairing them. It's meant
+// to be used while the daemon is stopped.
+func CheckDataStore(dirs ModuleDirs, opts ScanOptions, sh seiface.Handler, ds datastore.ReadOnlyDataStore,
+) ([]CheckIssue, error) {
+	files, err := policyFilesInDirs(dirs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded, err := loadedModules(sh)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := ds.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing datastore entries: %w", err)
+	}
+
+	issues := make([]CheckIssue, 0)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := files[name]
+		p, getErr := ds.Get(name)
+		switch {
+		case errors.Is(getErr, datastore.ErrPolicyNotFound):
+			issues = append(issues, CheckIssue{name, CheckUntracked, path})
+			continue
+		case getErr != nil:
+			issues = append(issues, CheckIssue{name, CheckUnreadable, getErr.Error()})
+			continue
+		}
+		// Other files might provide the same policy, the one in the
+		// datastore is the owner
+		if p.SourcePath != "" && p.SourcePath != path {
+			if _, statErr := os.Stat(p.SourcePath); statErr == nil {
+				path = p.SourcePath
+			}
+		}
+
+		if owned := ownedPriority(p); owned != 0 && !loaded[owned][name] {
+			issues = append(issues, CheckIssue{name, CheckMissingModule, fmt.Sprintf("priority %d", owned)})
+		}
+		cs, csErr := utils.PolicyChecksum(path)
+		if csErr != nil {
+			issues = append(issues, CheckIssue{name, CheckUnreadableFile, csErr.Error()})
+			continue
+		}
+		if !bytes.Equal(p.Checksum, cs) {
+			issues = append(issues, CheckIssue{name, CheckOutdated, path})
+		}
+	}
+
+	for _, name := range orphanedPolicies(files, stored) {
+		issues = append(issues, CheckIssue{name, CheckOrphaned, ""})
+	}
+
+	return issues, nil
+}
+
+// RebuildReport describes the outcome of RebuildDataStore
+type RebuildReport struct {
+	AdoptReport
+	// Kept lists the policies whose entry was kept, as selinuxd installed
+	// their module, which is still there. The daemon installs them again,
+	// or removes their module, once it starts, as it would otherwise.
+	Kept []string `json:"kept"`
+}
+
+// RebuildDataStore regenerates the datastore from the policy files in `dirs`
+// and the installed modules. Every entry is dropped, except for the ones
+// of the modules that selinuxd installed and are still installed, as their
+// ownership couldn't be recovered otherwise. The modules that match their
+// policy file, and that `admission` admits, are adopted. The history is kept. The rest of the policies
+// are installed by the daemon once it starts. It's meant to be used while
+// the daemon is stopped.
+func RebuildDataStore(dirs ModuleDirs, opts ScanOptions, admission AdmissionOptions, sh seiface.Handler,
+	ds datastore.DataStore, history datastore.HistoryRetention,
+) (*RebuildReport, error) {
+	loaded, err := loadedModules(sh)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := ds.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing datastore entries: %w", err)
+	}
+	kept := make([]string, 0)
+	for _, name := range stored {
+		// Entries that can't be read are dropped along with the rest
+		if p, getErr := ds.Get(name); getErr == nil {
+			if owned := ownedPriority(p); owned != 0 && loaded[owned][name] {
+				kept = append(kept, name)
+				continue
+			}
+		}
+		if err := ds.Remove(name); err != nil {
+			return nil, fmt.Errorf("rebuilding datastore: %w", err)
+		}
+	}
+
+	report, err := adopt(applyConfig{dirs: dirs, AdmissionOptions: admission, history: history}, opts, sh, ds)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(kept)
+	return &RebuildReport{AdoptReport: *report, Kept: kept}, nil
+}
@@ -0,0 +1,130 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule/test"
+	"github.com/containers/selinuxd/pkg/utils"
+)
+
+func TestOfflineDataStore(t *testing.T) {
+	moddir := t.TempDir()
+	cfg := testConfig(moddir)
+	sh := test.NewSEModuleTestHandler()
+	ds := datastore.NewMemory()
+	defer ds.Close()
+
+	installed := writePolicy(t, moddir, "installed", "(type installed_t)")
+	changed := writePolicy(t, moddir, "changed", "(type changed_t)")
+	removed := writePolicy(t, moddir, "removed", "(type removed_t)")
+	for _, path := range []string{installed, changed, removed} {
+		if _, err := newInstallAction(path).do(cfg, sh, ds); err != nil {
+			t.Fatalf("unexpected error installing %s: %s", path, err)
+		}
+	}
+	// "changed" is edited, "removed" gets removed by hand, "gone" loses
+	// its file, and "untracked" was never seen
+	writePolicy(t, moddir, "changed", "(type changed_t)\n(type extra_t)")
+	if err := sh.Remove("removed", DefaultPriority); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Put(datastore.PolicyStatus{Policy: "gone", Status: datastore.InstalledStatus}); err != nil {
+		t.Fatal(err)
+	}
+	writePolicy(t, moddir, "untracked", "(type untracked_t)")
+
+	t.Run("Checking should report every inconsistency", func(t *testing.T) {
+		issues, err := CheckDataStore(cfg.dirs, ScanOptions{}, sh, ds.GetReadOnly())
+		if err != nil {
+			t.Fatalf("unexpected error checking the datastore: %s", err)
+		}
+		expected := []CheckIssue{
+			{"changed", CheckOutdated, changed},
+			{"removed", CheckMissingModule, "priority 350"},
+			{"untracked", CheckUntracked, getPolicyPath("untracked", moddir)},
+			{"gone", CheckOrphaned, ""},
+		}
+		if !slices.Equal(issues, expected) {
+			t.Errorf("unexpected issues:\n%+v\nexpected:\n%+v", issues, expected)
+		}
+	})
+
+	t.Run("Checking should report the policy files that can't be read", func(t *testing.T) {
+		// The file contexts of the source can't be read
+		broken := filepath.Join(moddir, "broken.te")
+		if err := os.WriteFile(broken, []byte("policy_module(broken, 1.0)"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		fc := utils.FileContextsPath(broken)
+		if err := os.Mkdir(fc, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(fc)
+		defer os.Remove(broken)
+		if err := ds.Put(datastore.PolicyStatus{Policy: "broken", Status: datastore.FailedStatus}); err != nil {
+			t.Fatal(err)
+		}
+		defer ds.Remove("broken") //nolint:errcheck // the rebuild drops it anyway
+		_, readErr := utils.PolicyChecksum(broken)
+		if readErr == nil {
+			t.Fatalf("expected the policy not to be readable")
+		}
+
+		issues, err := CheckDataStore(cfg.dirs, ScanOptions{}, sh, ds.GetReadOnly())
+		if err != nil {
+			t.Fatalf("unexpected error checking the datastore: %s", err)
+		}
+		expected := []CheckIssue{
+			{"broken", CheckUnreadableFile, readErr.Error()},
+			{"changed", CheckOutdated, changed},
+			{"removed", CheckMissingModule, "priority 350"},
+			{"untracked", CheckUntracked, getPolicyPath("untracked", moddir)},
+			{"gone", CheckOrphaned, ""},
+		}
+		if !slices.Equal(issues, expected) {
+			t.Errorf("unexpected issues:\n%+v\nexpected:\n%+v", issues, expected)
+		}
+	})
+
+	t.Run("Rebuilding should keep the owned modules and adopt the matching ones", func(t *testing.T) {
+		// "untracked" matches the module installed by hand, and
+		// "handmade" doesn't
+		if err := sh.Install(getPolicyPath("untracked", moddir), DefaultPriority); err != nil {
+			t.Fatal(err)
+		}
+		if err := sh.Install(writePolicy(t, t.TempDir(), "handmade", "(type handmade_t)"), DefaultPriority); err != nil {
+			t.Fatal(err)
+		}
+		writePolicy(t, moddir, "handmade", "(type handmade_t)\n(type extra_t)")
+
+		report, err := RebuildDataStore(cfg.dirs, ScanOptions{}, AdmissionOptions{}, sh, ds, datastore.HistoryRetention{})
+		if err != nil {
+			t.Fatalf("unexpected error rebuilding the datastore: %s", err)
+		}
+		if !slices.Equal(report.Kept, []string{"changed", "installed"}) ||
+			!slices.Equal(report.Adopted, []string{"untracked"}) || !slices.Equal(report.Mismatched, []string{"handmade"}) {
+			t.Errorf("unexpected report: %+v", report)
+		}
+		stored, err := ds.List()
+		if err != nil {
+			t.Fatalf("unexpected error listing the policies: %s", err)
+		}
+		if !slices.Equal(stored, []string{"changed", "installed", "untracked"}) {
+			t.Errorf("expected the kept and adopted policies to be recorded, got: %v", stored)
+		}
+		// The module of "changed" is still owned, so the daemon updates it
+		ps, err := ds.Get("changed")
+		if err != nil || ownedPriority(ps) != DefaultPriority {
+			t.Errorf("expected the ownership of the module to be kept, got: %+v, %v", ps, err)
+		}
+		// The history from before the rebuild is kept
+		entries, err := ds.History("removed")
+		if err != nil || len(entries) == 0 {
+			t.Errorf("expected the history to be kept, got: %v, %v", entries, err)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+)
+
+// OwnershipOptions control how selinuxd treats the modules that it didn't
+// install, e.g. the ones shipped by the distribution or installed by hand.
+type OwnershipOptions struct {
+	// OverwriteUnowned allows installing over, and removing, modules that
+	// selinuxd doesn't own.
+	OverwriteUnowned bool
+	// AdoptExisting makes selinuxd adopt the modules that match their file
+	// on startup, instead of refusing to install over them.
+	AdoptExisting bool
+}
+
+var errNotOwned = errors.New("module is not owned by selinuxd")
+
+// applyConfig is the configuration that policy actions are applied with
+type applyConfig struct {
+	dirs ModuleDirs
+	OwnershipOptions
+	AdmissionOptions
+	// compile compiles type-enforcement sources. It defaults to
+	// checkmodule and semodule_package.
+	compile compileFunc
+	// history is how long the history of each policy is kept for
+	history datastore.HistoryRetention
+	// ctx stops the compilers once it's done. It defaults to a context
+	// that is never done.
+	ctx context.Context
+}
+
+// context returns the context that the policies are applied in
+func (cfg applyConfig) context() context.Context {
+	if cfg.ctx != nil {
+		return cfg.ctx
+	}
+	return context.Background()
+}
+
+// ownedPriority returns the priority of the module that selinuxd installed
+// for the policy, or zero if it doesn't own one.
+func ownedPriority(p datastore.PolicyStatus) uint16 {
+	if p.OwnedPriority != 0 {
+		return p.OwnedPriority
+	}
+	// Entries from before ownership was tracked are only marked as
+	// installed if selinuxd installed the module.
+	if p.Status == datastore.InstalledStatus {
+		return priorityOrDefault(p.Priority)
+	}
+	return 0
+}
+
+// owns tells whether selinuxd installed the policy's module at `priority`
+func owns(p datastore.PolicyStatus, priority uint16) bool {
+	owned := ownedPriority(p)
+	return owned != 0 && owned == priority
+}
@@ -0,0 +1,133 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule/test"
+)
+
+func TestUnownedModules(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	// "container" is shipped by the distribution, and "custom" was
+	// installed by hand at the priority selinuxd uses.
+	distroDir := t.TempDir()
+	if err := sh.Install(writePolicy(t, distroDir, "container", "(type container_t)"), 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.Install(writePolicy(t, distroDir, "custom", "(type custom_t)"), DefaultPriority); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("A module at another priority shouldn't be touched", func(t *testing.T) {
+		policy := writePolicy(t, moddir, "container", "(type container_t)\n(type extra_t)")
+		if _, err := newInstallAction(policy).do(testConfig(moddir), sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := os.Remove(policy); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newRemoveAction(policy).do(testConfig(moddir), sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if prios := sh.ModulePriorities("container"); !slices.Equal(prios, []uint16{100}) {
+			t.Fatalf("expected only the distribution's module to be left, got: %v", prios)
+		}
+	})
+
+	policy := writePolicy(t, moddir, "custom", "(type custom_t)\n(type extra_t)")
+
+	t.Run("Installing over an unowned module should be refused", func(t *testing.T) {
+		if _, err := newInstallAction(policy).do(testConfig(moddir), sh, ds); !errors.Is(err, errNotOwned) {
+			t.Fatalf("expected the install to be refused, got: %v", err)
+		}
+		ps, err := ds.Get("custom")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if ps.Status != datastore.RefusedStatus || ps.OwnedPriority != 0 {
+			t.Fatalf("expected a refused status, got: %+v", ps)
+		}
+	})
+
+	t.Run("Installing should fail if the modules can't be listed", func(t *testing.T) {
+		sh.FailNextList()
+		if _, err := newInstallAction(policy).do(testConfig(moddir), sh, ds); !errors.Is(err, test.ErrTestList) {
+			t.Fatalf("expected the install to fail, got: %v", err)
+		}
+		ps, err := ds.Get("custom")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if ps.Status != datastore.FailedStatus || ps.OwnedPriority != 0 || ps.Attempt != 1 {
+			t.Fatalf("expected a failed status, got: %+v", ps)
+		}
+		if data, err := sh.Extract("custom", DefaultPriority); err != nil || string(data) != "(type custom_t)" {
+			t.Fatalf("expected the unowned module to be left in place, got: %q", data)
+		}
+	})
+
+	t.Run("Removing the file shouldn't remove an unowned module", func(t *testing.T) {
+		if err := os.Remove(policy); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newRemoveAction(policy).do(testConfig(moddir), sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !sh.IsModuleInstalled("custom") {
+			t.Fatalf("expected the module to be left in place")
+		}
+		if _, err := ds.Get("custom"); !errors.Is(err, datastore.ErrPolicyNotFound) {
+			t.Fatalf("expected the policy to be removed from the datastore, got: %v", err)
+		}
+	})
+
+	t.Run("Unowned modules should be overwritten if allowed", func(t *testing.T) {
+		cfg := testConfig(moddir)
+		cfg.OverwriteUnowned = true
+		writePolicy(t, moddir, "custom", "(type custom_t)\n(type extra_t)")
+		if _, err := newInstallAction(policy).do(cfg, sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		ps, err := ds.Get("custom")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if ps.Status != datastore.InstalledStatus || ps.OwnedPriority != DefaultPriority || len(ps.OwnedChecksum) == 0 {
+			t.Fatalf("expected the module to be owned, got: %+v", ps)
+		}
+
+		// Once owned, the module is managed as usual
+		if err := os.Remove(policy); err != nil {
+			t.Fatal(err)
+		}
+		sh.FailNextList()
+		if _, err := newRemoveAction(policy).do(testConfig(moddir), sh, ds); !errors.Is(err, test.ErrTestList) {
+			t.Fatalf("expected the removal to fail, got: %v", err)
+		}
+		ps, err = ds.Get("custom")
+		if err != nil {
+			t.Fatalf("expected the policy to be kept in the datastore: %s", err)
+		}
+		if ps.Status != datastore.FailedStatus || ps.OwnedPriority != DefaultPriority {
+			t.Fatalf("expected the module to stay owned, got: %+v", ps)
+		}
+		if _, err := newRemoveAction(policy).do(testConfig(moddir), sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if sh.IsModuleInstalled("custom") {
+			t.Fatalf("expected the module to be removed")
+		}
+	})
+}
@@ -0,0 +1,214 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDebounceWindow is how long the queue waits for a burst of
+	// operations to settle before handing them over to be applied.
+	DefaultDebounceWindow = 500 * time.Millisecond
+	// maxDebounceFactor limits how many windows a burst may be delayed by,
+	// so a steady stream of operations doesn't starve the installer.
+	maxDebounceFactor = 10
+)
+
+// ActionAdder accepts policy operations to be applied
+type ActionAdder interface {
+	Add(action PolicyAction)
+}
+
+type queuedAction struct {
+	key    string
+	action PolicyAction
+}
+
+// ActionQueue is a work queue of policy operations, keyed by policy file.
+// Only the latest operation for a given file is kept; e.g. a policy that
+// is created and deleted before the queue is processed results in a single
+// removal. Files aren't keyed by policy name, as several files might
+// provide the same policy, e.g. in module directories of different
+// priorities. Operations on a policy known by name only, e.g. the removal
+// of an orphaned policy, are superseded by a later operation on a file
+// that provides the same policy. Operations without a key are always kept.
+//
+// Adding to the queue never blocks, and the operations are handed over in
+// batches once no operation was added for the debounce window.
+type ActionQueue struct {
+	mu       sync.Mutex
+	items    []queuedAction
+	index    map[string]int
+	firstAdd time.Time
+	lastAdd  time.Time
+	// delayed holds the timers of the operations added with AddAfter
+	delayed  map[string]*time.Timer
+	shutdown bool
+	window   time.Duration
+	// signal wakes up the consumer whenever the queue changes
+	signal chan struct{}
+}
+
+// NewActionQueue returns a queue that debounces bursts of operations
+// over `window`.
+func NewActionQueue(window time.Duration) *ActionQueue {
+	return &ActionQueue{
+		index:   make(map[string]int),
+		delayed: make(map[string]*time.Timer),
+		window:  window,
+		signal:  make(chan struct{}, 1),
+	}
+}
+
+// Add queues the operation, replacing any pending operation for the same
+// policy, including the delayed ones.
+func (q *ActionQueue) Add(action PolicyAction) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if key := action.key(); key != "" {
+		if timer, ok := q.delayed[key]; ok {
+			timer.Stop()
+			delete(q.delayed, key)
+		}
+	}
+	q.add(action)
+}
+
+// AddAfter queues the operation once `delay` has passed, unless another
+// operation for the same policy is added in the meantime.
+func (q *ActionQueue) AddAfter(action PolicyAction, delay time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.shutdown {
+		return
+	}
+
+	key := action.key()
+	if timer, ok := q.delayed[key]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if key != "" {
+			if q.delayed[key] != timer {
+				return
+			}
+			delete(q.delayed, key)
+		}
+		q.add(action)
+	})
+	if key != "" {
+		q.delayed[key] = timer
+	}
+}
+
+// add needs to be called with the lock held
+func (q *ActionQueue) add(action PolicyAction) {
+	if q.shutdown {
+		return
+	}
+
+	now := time.Now()
+	if len(q.items) == 0 {
+		q.firstAdd = now
+	}
+	q.lastAdd = now
+
+	key := action.key()
+	idx, ok := q.index[key]
+	if policy := action.affectedPolicy(); !ok && key != "" && policy != key {
+		// Policies known by name only are keyed by their name
+		if idx, ok = q.index[policy]; ok {
+			delete(q.index, policy)
+			q.index[key] = idx
+			q.items[idx].key = key
+		}
+	}
+	if ok && key != "" {
+		q.items[idx].action = supersede(q.items[idx].action, action)
+	} else {
+		if key != "" {
+			q.index[key] = len(q.items)
+		}
+		q.items = append(q.items, queuedAction{key, action})
+	}
+	q.notify()
+}
+
+// ShutDown stops the queue from accepting operations. The pending ones
+// are still handed over, but the delayed ones are dropped.
+func (q *ActionQueue) ShutDown() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.shutdown = true
+	for key, timer := range q.delayed {
+		timer.Stop()
+		delete(q.delayed, key)
+	}
+	q.notify()
+}
+
+// Len returns the number of pending operations
+func (q *ActionQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// notify needs to be called with the lock held
+func (q *ActionQueue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// get waits for a burst of operations to settle and returns them in the
+// order they were first queued. It also returns whether the queue is still
+// accepting operations.
+func (q *ActionQueue) get() ([]PolicyAction, bool) {
+	for {
+		q.mu.Lock()
+		var wait time.Duration
+		switch {
+		case len(q.items) == 0 && q.shutdown:
+			q.mu.Unlock()
+			return nil, false
+		case len(q.items) > 0:
+			quiet := time.Since(q.lastAdd)
+			pending := time.Since(q.firstAdd)
+			maxPending := q.window * maxDebounceFactor
+			if q.shutdown || quiet >= q.window || pending >= maxPending {
+				batch, open := q.drain(), !q.shutdown
+				q.mu.Unlock()
+				return batch, open
+			}
+			wait = min(q.window-quiet, maxPending-pending)
+		}
+		q.mu.Unlock()
+
+		if wait == 0 {
+			<-q.signal
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-q.signal:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// drain needs to be called with the lock held
+func (q *ActionQueue) drain() []PolicyAction {
+	batch := make([]PolicyAction, 0, len(q.items))
+	for _, item := range q.items {
+		batch = append(batch, item.action)
+	}
+	q.items = nil
+	q.index = make(map[string]int)
+	return batch
+}
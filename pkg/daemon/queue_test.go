@@ -0,0 +1,133 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func batchStrings(batch []PolicyAction) []string {
+	out := make([]string, 0, len(batch))
+	for _, action := range batch {
+		out = append(out, action.String())
+	}
+	return out
+}
+
+func TestActionQueue(t *testing.T) {
+	t.Run("Operations on the same policy are coalesced", func(t *testing.T) {
+		q := NewActionQueue(0)
+		q.Add(newInstallAction("/policies/first.cil"))
+		q.Add(newInstallAction("/policies/second.cil"))
+		q.Add(newRemoveAction("/policies/first.cil"))
+		q.Add(newInstallAction("/policies/second.cil"))
+
+		batch, open := q.get()
+		if !open {
+			t.Fatalf("expected the queue to be open")
+		}
+		got := batchStrings(batch)
+		if len(got) != 2 || got[0] != "remove - /policies/first.cil" || got[1] != "install - /policies/second.cil" {
+			t.Fatalf("expected a removal of 'first' and an install of 'second', got: %v", got)
+		}
+		if q.Len() != 0 {
+			t.Fatalf("expected the queue to be empty, got %d items", q.Len())
+		}
+	})
+
+	t.Run("Operations on a policy file supersede the ones on the policy by name", func(t *testing.T) {
+		q := NewActionQueue(0)
+		q.Add(newRemovePolicyAction("first"))
+		q.Add(newRemovePolicyAction("second"))
+		q.Add(newInstallAction("/policies/first.cil"))
+		q.Add(newRemoveAction("/policies/first.cil"))
+
+		batch, _ := q.get()
+		got := batchStrings(batch)
+		if len(got) != 2 || got[0] != "remove - /policies/first.cil" || got[1] != "remove - second" {
+			t.Fatalf("expected a removal of the 'first' file and of the 'second' policy, got: %v", got)
+		}
+
+		q.Add(newRemovePolicyAction("first"))
+		q.Add(newInstallAction("/policies/first.cil"))
+		batch, _ = q.get()
+		if got := batchStrings(batch); len(got) != 1 || got[0] != "install - /policies/first.cil" {
+			t.Fatalf("expected the install to supersede the removal, got: %v", got)
+		}
+	})
+
+	t.Run("Files providing the same policy aren't coalesced", func(t *testing.T) {
+		q := NewActionQueue(0)
+		q.Add(newInstallAction("/vendor/test.cil"))
+		q.Add(newInstallAction("/admin/test.cil"))
+
+		batch, _ := q.get()
+		if got := batchStrings(batch); len(got) != 2 {
+			t.Fatalf("expected an install of each file, got: %v", got)
+		}
+	})
+
+	t.Run("Reconciliation passes are never coalesced", func(t *testing.T) {
+		q := NewActionQueue(0)
+		q.Add(newReconcileAction(ScanOptions{}, nil))
+		q.Add(newReconcileAction(ScanOptions{}, nil))
+
+		batch, _ := q.get()
+		if len(batch) != 2 {
+			t.Fatalf("expected two reconciliation passes, got: %v", batchStrings(batch))
+		}
+	})
+
+	t.Run("Adding doesn't block, and bursts are debounced", func(t *testing.T) {
+		window := 100 * time.Millisecond
+		q := NewActionQueue(window)
+		start := time.Now()
+		for i := 0; i < 1000; i++ {
+			q.Add(newInstallAction("/policies/test.cil"))
+		}
+
+		batch, _ := q.get()
+		if len(batch) != 1 {
+			t.Fatalf("expected a single install, got: %v", batchStrings(batch))
+		}
+		if elapsed := time.Since(start); elapsed < window {
+			t.Fatalf("expected the batch to be debounced for %s, got it after %s", window, elapsed)
+		}
+	})
+
+	t.Run("Pending operations are handed over after shutting down", func(t *testing.T) {
+		q := NewActionQueue(time.Hour)
+		q.Add(newInstallAction("/policies/test.cil"))
+		q.ShutDown()
+		q.Add(newInstallAction("/policies/other.cil"))
+
+		batch, open := q.get()
+		if open {
+			t.Fatalf("expected the queue to be shut down")
+		}
+		if got := batchStrings(batch); len(got) != 1 || got[0] != "install - /policies/test.cil" {
+			t.Fatalf("expected the pending install, got: %v", got)
+		}
+		if batch, open := q.get(); open || len(batch) != 0 {
+			t.Fatalf("expected no further operations, got: %v", batchStrings(batch))
+		}
+	})
+
+	t.Run("Superseded startup operations are still tracked", func(t *testing.T) {
+		q := NewActionQueue(0)
+		scan := newInitialScan(q, logr.Discard())
+		scan.Add(newInstallAction("/policies/test.cil"))
+		scan.finish()
+		q.Add(newRemoveAction("/policies/test.cil"))
+
+		batch, _ := q.get()
+		if got := batchStrings(batch); len(got) != 1 || got[0] != "remove - /policies/test.cil" {
+			t.Fatalf("expected the removal to supersede the install, got: %v", got)
+		}
+		notifyApplied(batch)
+		if scan.pending != 0 {
+			t.Fatalf("expected no pending startup operations, got: %d", scan.pending)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package daemon
+
+import (
+	"sync"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/utils"
+	"github.com/go-logr/logr"
+)
+
+// ReadyStatus is the response of the status server's ready endpoint
+type ReadyStatus struct {
+	// Ready tells whether the policies found on startup were processed
+	Ready bool `json:"ready"`
+	// Installed is the number of policies found on startup that are installed
+	Installed int `json:"installed"`
+	// Failed is the number of policies found on startup that failed to install
+	Failed int `json:"failed"`
+	// Pending is the number of startup operations yet to be processed
+	Pending int `json:"pending"`
+}
+
+// appliedNotifier is implemented by actions that need to know when they've
+// been fully applied; that is, when the batch they were part of was committed.
+type appliedNotifier interface {
+	applied()
+}
+
+// initialScan keeps track of the operations issued when the daemon starts
+// up, in order to know when the daemon is ready.
+type initialScan struct {
+	mu    sync.Mutex
+	queue ActionAdder
+	// policies are the names of the policies found on startup. Several
+	// files might provide the same policy, e.g. in different module
+	// directories, or a file might be scanned twice.
+	policies map[string]bool
+	pending  int
+	scanned  bool
+	l        logr.Logger
+}
+
+func newInitialScan(queue ActionAdder, l logr.Logger) *initialScan {
+	return &initialScan{queue: queue, policies: make(map[string]bool), l: l}
+}
+
+// Add forwards the operation to the queue, keeping track of it
+func (is *initialScan) Add(action PolicyAction) {
+	is.mu.Lock()
+	is.pending++
+	if pi, ok := action.(*policyInstall); ok {
+		if policy, err := utils.PolicyNameFromPath(pi.path); err == nil {
+			is.policies[policy] = true
+		}
+	}
+	is.mu.Unlock()
+	is.queue.Add(&trackedAction{action, is})
+}
+
+// finish marks the end of the startup operations
+func (is *initialScan) finish() {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	is.scanned = true
+	is.logIfReady()
+}
+
+func (is *initialScan) done() {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	is.pending--
+	is.logIfReady()
+}
+
+// logIfReady needs to be called with the lock held
+func (is *initialScan) logIfReady() {
+	if is.scanned && is.pending == 0 {
+		is.l.Info("The policies found on startup were processed. selinuxd is ready.")
+	}
+}
+
+// status returns the readiness of the daemon. In `strict` mode the daemon
+// is not considered ready while any of the policies found on startup is
+// in a failed state.
+func (is *initialScan) status(ds datastore.ReadOnlyDataStore, strict bool) ReadyStatus {
+	is.mu.Lock()
+	rs := ReadyStatus{
+		Ready:   is.scanned && is.pending == 0,
+		Pending: is.pending,
+	}
+	policies := make([]string, 0, len(is.policies))
+	for policy := range is.policies {
+		policies = append(policies, policy)
+	}
+	is.mu.Unlock()
+
+	for _, policy := range policies {
+		ps, err := ds.Get(policy)
+		if err != nil {
+			continue
+		}
+		switch ps.Status {
+		case datastore.InstalledStatus:
+			rs.Installed++
+		case datastore.FailedStatus, datastore.BlockedStatus, datastore.RefusedStatus, datastore.RejectedStatus:
+			rs.Failed++
+		}
+	}
+
+	if strict && rs.Failed > 0 {
+		rs.Ready = false
+	}
+	return rs
+}
+
+// trackedAction wraps an operation issued on startup
+type trackedAction struct {
+	PolicyAction
+	scan *initialScan
+}
+
+func (ta *trackedAction) applied() {
+	ta.scan.done()
+}
+
+// supersede returns the action to keep queued when `newer` replaces the
+// pending `old` one. Startup operations that get replaced still count as
+// done, and their replacement is tracked in their stead.
+func supersede(old, newer PolicyAction) PolicyAction {
+	ta, ok := old.(*trackedAction)
+	if !ok {
+		return newer
+	}
+	if _, ok := newer.(*trackedAction); ok {
+		ta.applied()
+		return newer
+	}
+	return &trackedAction{newer, ta.scan}
+}
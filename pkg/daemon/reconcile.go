@@ -0,0 +1,283 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	seiface "github.com/containers/selinuxd/pkg/semodule/interface"
+	"github.com/containers/selinuxd/pkg/utils"
+	"github.com/go-logr/logr"
+)
+
+const DefaultReconcileInterval = 10 * time.Minute
+
+// ReconcileReport describes the drift that a reconciliation pass found
+// between the module directory, the datastore and the installed modules,
+// and what was done to repair it.
+type ReconcileReport struct {
+	// Installed lists the policies that were (re-)installed
+	Installed []string `json:"installed"`
+	// Removed lists the policies that no longer had a backing file
+	Removed []string `json:"removed"`
+	// Failed lists the policies that couldn't be repaired
+	Failed []string `json:"failed"`
+}
+
+type reconcileResult struct {
+	report *ReconcileReport
+	err    error
+}
+
+// Defines a reconciliation pass. It's executed as a regular policy action
+// so it's serialized with the rest of the policy operations; this way
+// we never compare states while an operation is in flight.
+type policyReconcile struct {
+	opts ScanOptions
+	// result is optional, and receives the outcome of the pass
+	result chan<- reconcileResult
+}
+
+// newReconcileAction will execute a reconciliation pass. If `result` is
+// not nil, the report will be sent through it.
+func newReconcileAction(opts ScanOptions, result chan<- reconcileResult) PolicyAction {
+	return &policyReconcile{opts, result}
+}
+
+func (pr *policyReconcile) String() string {
+	return "reconcile"
+}
+
+// key is empty, as reconciliation passes are never coalesced; each
+// one of them might have a caller waiting for its report.
+func (pr *policyReconcile) key() string {
+	return ""
+}
+
+func (pr *policyReconcile) affectedPolicy() string {
+	return ""
+}
+
+// standalone keeps the pass out of transactional batches, so that its
+// report is sent once, and only lists committed operations
+func (pr *policyReconcile) standalone() {}
+
+// send hands the result to the caller, if it's still waiting for it
+func (pr *policyReconcile) send(res reconcileResult) {
+	if pr.result == nil {
+		return
+	}
+	select {
+	case pr.result <- res:
+	default:
+	}
+}
+
+func (pr *policyReconcile) do(cfg applyConfig, sh seiface.Handler, ds datastore.DataStore) (string, error) {
+	report, err := reconcile(cfg, pr.opts, sh, ds)
+	pr.send(reconcileResult{report, err})
+	if err != nil {
+		return "", fmt.Errorf("reconciling policies: %w", err)
+	}
+	// The history of policies that see no more actions is pruned here
+	if err := ds.PruneHistory(cfg.history); err != nil {
+		return "", fmt.Errorf("reconciling policies: %w", err)
+	}
+	if len(report.Failed) > 0 {
+		return "", fmt.Errorf("%w: %v", errReconcileIncomplete, report.Failed)
+	}
+	return fmt.Sprintf("Reconciliation done. Installed: %v. Removed: %v",
+		report.Installed, report.Removed), nil
+}
+
+var errReconcileIncomplete = errors.New("unable to repair policies")
+
+type policyRepair struct {
+	policy string
+	action PolicyAction
+	// done is where the policy is reported if the repair succeeds
+	done *[]string
+}
+
+// reconcile compares the policy files in the module directories with the datastore
+// and the module set reported by the handler, and repairs the drift:
+// * policies without a datastore entry, or whose file changed, get installed.
+// * policies that are marked as installed but are missing from the
+// system get re-installed.
+// * datastore entries without a backing file get removed.
+func reconcile(cfg applyConfig, opts ScanOptions, sh seiface.Handler, ds datastore.DataStore,
+) (*ReconcileReport, error) {
+	files, err := policyFilesInDirs(cfg.dirs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded, err := loadedModules(sh)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := ds.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing datastore entries: %w", err)
+	}
+
+	report := &ReconcileReport{
+		Installed: []string{},
+		Removed:   []string{},
+		Failed:    []string{},
+	}
+	repairs := make([]policyRepair, 0)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := files[name]
+		p, getErr := ds.Get(name)
+		// Other files might provide the same policy, the one in the
+		// datastore is the owner
+		if getErr == nil && p.SourcePath != "" && p.SourcePath != path {
+			if _, statErr := os.Stat(p.SourcePath); statErr == nil {
+				path = p.SourcePath
+			}
+		}
+		switch {
+		case errors.Is(getErr, datastore.ErrPolicyNotFound):
+			repairs = append(repairs, policyRepair{name, newInstallAction(path), &report.Installed})
+		case getErr != nil:
+			return nil, fmt.Errorf("couldn't access datastore: %w", getErr)
+		case p.Status == datastore.InstalledStatus && !loaded[ownedPriority(p)][name]:
+			repairs = append(repairs, policyRepair{name, newReinstallAction(path), &report.Installed})
+		default:
+			cs, csErr := utils.PolicyChecksum(path)
+			if csErr != nil {
+				return nil, fmt.Errorf("reconciling policy %s: %w", name, csErr)
+			}
+			if !bytes.Equal(p.Checksum, cs) {
+				repairs = append(repairs, policyRepair{name, newInstallAction(path), &report.Installed})
+			}
+		}
+	}
+
+	for _, name := range orphanedPolicies(files, stored) {
+		repairs = append(repairs, policyRepair{name, newRemovePolicyAction(name), &report.Removed})
+	}
+
+	for _, r := range repairs {
+		if _, err := r.action.do(cfg, sh, ds); err != nil {
+			report.Failed = append(report.Failed, r.policy)
+			continue
+		}
+		*r.done = append(*r.done, r.policy)
+	}
+
+	return report, nil
+}
+
+// loadedModules returns the modules installed at each priority
+func loadedModules(sh seiface.Handler) (map[uint16]map[string]bool, error) {
+	modules, err := sh.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing installed modules: %w", err)
+	}
+	loaded := make(map[uint16]map[string]bool)
+	for _, mod := range modules {
+		if loaded[mod.Priority] == nil {
+			loaded[mod.Priority] = make(map[string]bool)
+		}
+		loaded[mod.Priority][mod.Name] = true
+	}
+	return loaded, nil
+}
+
+// priorityOrDefault returns DefaultPriority for the entries that were
+// stored before priorities were tracked.
+func priorityOrDefault(priority uint16) uint16 {
+	if priority == 0 {
+		return DefaultPriority
+	}
+	return priority
+}
+
+// policyFilesInDirs returns a map of policy names to the paths of the
+// policy files found in `dirs`. Files that aren't policies are ignored.
+// If several files have the same policy name, the one in the directory
+// with the highest priority is kept; on a tie, the first one.
+func policyFilesInDirs(dirs ModuleDirs, opts ScanOptions) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, md := range dirs {
+		err := walkPolicyFiles(md.Path, opts, nil, func(path string) {
+			policy, nameErr := utils.PolicyNameFromPath(path)
+			if nameErr != nil {
+				return
+			}
+			if current, ok := files[policy]; !ok || dirs.priorityOf(path) > dirs.priorityOf(current) {
+				files[policy] = path
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// orphanedPolicies returns the policies from the datastore that don't have
+// a backing file anymore.
+func orphanedPolicies(files map[string]string, stored []string) []string {
+	orphans := make([]string, 0)
+	for _, name := range stored {
+		if _, ok := files[name]; !ok {
+			orphans = append(orphans, name)
+		}
+	}
+	return orphans
+}
+
+// reconcilePeriodically issues a reconciliation pass every `interval`
+// until the context is done. A zero or negative interval disables it.
+func reconcilePeriodically(ctx context.Context, interval time.Duration, opts ScanOptions,
+	policyops ActionAdder, logger logr.Logger,
+) {
+	rlog := logger.WithName("reconciler")
+	if interval <= 0 {
+		rlog.Info("Periodic reconciliation is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rlog.Info("Triggering periodic reconciliation")
+			policyops.Add(newReconcileAction(opts, nil))
+		}
+	}
+}
+
+// requestReconcile issues a reconciliation pass and waits for its report
+func requestReconcile(ctx context.Context, opts ScanOptions, policyops ActionAdder,
+) (*ReconcileReport, error) {
+	result := make(chan reconcileResult, 1)
+	policyops.Add(newReconcileAction(opts, result))
+
+	select {
+	case res := <-result:
+		return res.report, res.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for reconciliation: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,126 @@
+package daemon
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule/test"
+	"github.com/go-logr/logr"
+)
+
+func TestReconcile(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	// "dropped" is installed according to the datastore, but got removed
+	// from the system behind selinuxd's back.
+	installPolicy("dropped", moddir, t)
+	if _, err := newInstallAction(getPolicyPath("dropped", moddir)).do(testConfig(moddir), sh, ds); err != nil {
+		t.Fatalf("Unable to install policy: %s", err)
+	}
+	if err := sh.Remove("dropped", DefaultPriority); err != nil {
+		t.Fatalf("Unable to remove module: %s", err)
+	}
+
+	// "missed" was never seen by the daemon
+	installPolicy("missed", moddir, t)
+
+	// "stale" has no backing file anymore
+	if err := ds.Put(datastore.PolicyStatus{Policy: "stale", Status: datastore.InstalledStatus}); err != nil {
+		t.Fatalf("Unable to persist policy status: %s", err)
+	}
+
+	report, err := reconcile(testConfig(moddir), ScanOptions{}, sh, ds)
+	if err != nil {
+		t.Fatalf("Unexpected reconciliation error: %s", err)
+	}
+
+	if len(report.Installed) != 2 || report.Installed[0] != "dropped" || report.Installed[1] != "missed" {
+		t.Errorf("expected 'dropped' and 'missed' to be installed, got: %v", report.Installed)
+	}
+	if len(report.Removed) != 1 || report.Removed[0] != "stale" {
+		t.Errorf("expected 'stale' to be removed, got: %v", report.Removed)
+	}
+	if len(report.Failed) != 0 {
+		t.Errorf("expected no failures, got: %v", report.Failed)
+	}
+
+	for _, mod := range []string{"dropped", "missed"} {
+		if !sh.IsModuleInstalled(mod) {
+			t.Errorf("expected module %s to be installed", mod)
+		}
+	}
+	if _, err := ds.Get("stale"); err == nil {
+		t.Errorf("expected 'stale' to be removed from the datastore")
+	}
+
+	// A second pass should find nothing to do
+	report, err = reconcile(testConfig(moddir), ScanOptions{}, sh, ds)
+	if err != nil {
+		t.Fatalf("Unexpected reconciliation error: %s", err)
+	}
+	if len(report.Installed)+len(report.Removed)+len(report.Failed) != 0 {
+		t.Errorf("expected no drift, got: %+v", report)
+	}
+}
+
+func TestReconcileInFailedBatch(t *testing.T) {
+	moddir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds := datastore.NewMemory()
+	defer ds.Close()
+
+	installPolicy("first", moddir, t)
+	installPolicy("second", moddir, t)
+
+	reconciled := make(chan reconcileResult, 1)
+	// The requester of this adoption pass gave up, and nobody drains its
+	// channel anymore
+	abandoned := make(chan adoptResult, 1)
+	abandoned <- adoptResult{}
+
+	sh.FailNextCommit()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		applyBatch(testConfig(moddir), sh, ds, []PolicyAction{
+			newInstallAction(getPolicyPath("first", moddir)),
+			newReconcileAction(ScanOptions{}, reconciled),
+			newInstallAction(getPolicyPath("second", moddir)),
+			newAdoptAction(ScanOptions{}, abandoned),
+		}, logr.Discard())
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("applying the batch blocked")
+	}
+
+	select {
+	case res := <-reconciled:
+		if res.err != nil {
+			t.Errorf("unexpected reconciliation error: %s", res.err)
+		}
+	default:
+		t.Fatalf("expected the reconciliation report to be sent")
+	}
+	select {
+	case <-reconciled:
+		t.Errorf("expected the reconciliation report to be sent once")
+	default:
+	}
+	for _, mod := range []string{"first", "second"} {
+		if !sh.IsModuleInstalled(mod) {
+			t.Errorf("expected module %s to be installed", mod)
+		}
+	}
+}
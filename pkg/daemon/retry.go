@@ -0,0 +1,111 @@
+package daemon
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/containers/selinuxd/pkg/cil"
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/utils"
+	"github.com/go-logr/logr"
+)
+
+const (
+	// DefaultMaxInstallAttempts is how many times a policy install is
+	// attempted before giving up on it.
+	DefaultMaxInstallAttempts = 5
+	defaultRetryInterval      = 2 * time.Second
+	defaultMaxRetryInterval   = 5 * time.Minute
+)
+
+// RetryOptions defines how failed policy installs are retried. Installs
+// fail for transient reasons too, e.g. contention on the SELinux store
+// lock or a dependency that is not installed yet.
+type RetryOptions struct {
+	// MaxAttempts is how many times an install is attempted before giving
+	// up. Values lower than 2 disable retries.
+	MaxAttempts int
+	// InitialInterval is the delay before the first retry, which grows
+	// exponentially with each attempt up to MaxInterval.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+}
+
+// DefaultRetryOptions returns the retry options used by the daemon
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		MaxAttempts:     DefaultMaxInstallAttempts,
+		InitialInterval: defaultRetryInterval,
+		MaxInterval:     defaultMaxRetryInterval,
+	}
+}
+
+// delay returns how long to wait before the retry that follows `attempt`
+// failed attempts.
+func (ro RetryOptions) delay(attempt int) time.Duration {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = ro.InitialInterval
+	b.MaxInterval = ro.MaxInterval
+	b.MaxElapsedTime = 0
+	b.Reset()
+
+	var d time.Duration
+	for i := 0; i < attempt; i++ {
+		d = b.NextBackOff()
+	}
+	return d
+}
+
+// scheduleRetries re-queues the installs that failed or were blocked by a
+// failed dependency, as long as they haven't reached the maximum number of
+// attempts. The time of the next retry is recorded in the datastore.
+func scheduleRetries(results []actionResult, opts RetryOptions, ds datastore.DataStore, policyops *ActionQueue,
+	ilog logr.Logger,
+) {
+	if opts.MaxAttempts < 2 {
+		return
+	}
+	for _, res := range results {
+		if res.err == nil {
+			continue
+		}
+		pi, ok := unwrapAction(res.action).(*policyInstall)
+		if !ok {
+			continue
+		}
+		// Compiling or parsing the same source fails the same way. The
+		// next change to the source triggers another install.
+		if errors.Is(res.err, errCompile) || errors.Is(res.err, cil.ErrSyntax) {
+			continue
+		}
+		policy, err := utils.PolicyNameFromPath(pi.path)
+		if err != nil {
+			continue
+		}
+		// Only retry installs that got as far as the handler; the rest
+		// (e.g. the file is gone) won't fix themselves.
+		ps, err := ds.Get(policy)
+		if err != nil || (ps.Status != datastore.FailedStatus && ps.Status != datastore.BlockedStatus) {
+			continue
+		}
+		// Files refused because of a conflict are not retried
+		if ps.SourcePath != "" && ps.SourcePath != pi.path {
+			continue
+		}
+
+		if ps.Attempt >= opts.MaxAttempts {
+			ilog.Info("Giving up on installing policy", "policy", policy, "attempts", ps.Attempt)
+			continue
+		}
+
+		delay := opts.delay(ps.Attempt)
+		next := time.Now().Add(delay)
+		ps.NextRetry = &next
+		if err := ds.Put(ps); err != nil {
+			ilog.Error(err, "Unable to record the next install retry", "policy", policy)
+		}
+		ilog.Info("Retrying policy install", "policy", policy, "attempt", ps.Attempt+1, "in", delay.String())
+		policyops.AddAfter(newRetryAction(pi.path, ps.Attempt), delay)
+	}
+}
@@ -0,0 +1,112 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule/test"
+	"github.com/go-logr/logr"
+)
+
+var errRetryPending = errors.New("retry still pending")
+
+func TestRetryFailedInstalls(t *testing.T) {
+	moddir := t.TempDir()
+	opts := RetryOptions{
+		MaxAttempts:     3,
+		InitialInterval: 10 * time.Millisecond,
+		MaxInterval:     50 * time.Millisecond,
+	}
+
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	sh := test.NewSEModuleTestHandler()
+	policyops := NewActionQueue(time.Millisecond)
+	go InstallPolicies(context.Background(), testModuleDirs(moddir), sh, ds, policyops, opts, OwnershipOptions{},
+		AdmissionOptions{}, datastore.HistoryRetention{}, logr.Discard())
+	defer policyops.ShutDown()
+
+	waitForStatus := func(policy string, check func(datastore.PolicyStatus) error) {
+		t.Helper()
+		err := backoff.Retry(func() error {
+			ps, err := ds.Get(policy)
+			if err != nil {
+				return err
+			}
+			return check(ps)
+		}, backoff.WithMaxRetries(backoff.NewConstantBackOff(50*time.Millisecond), 20))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	t.Run("A transient failure should be retried", func(t *testing.T) {
+		installPolicy("transient", moddir, t)
+		sh.FailInstalls("transient", 2)
+		policyops.Add(newInstallAction(getPolicyPath("transient", moddir)))
+
+		waitForStatus("transient", func(ps datastore.PolicyStatus) error {
+			if ps.Status != datastore.InstalledStatus {
+				return fmt.Errorf("%w: %+v", errRetryPending, ps)
+			}
+			return nil
+		})
+
+		ps, err := ds.Get("transient")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if ps.Attempt != 0 || ps.NextRetry != nil {
+			t.Fatalf("expected the retry state to be cleared, got: %+v", ps)
+		}
+	})
+
+	t.Run("A persistent failure should be given up on", func(t *testing.T) {
+		installPolicy("persistent", moddir, t)
+		sh.FailInstalls("persistent", 10)
+		policyops.Add(newInstallAction(getPolicyPath("persistent", moddir)))
+
+		waitForStatus("persistent", func(ps datastore.PolicyStatus) error {
+			if ps.Attempt != opts.MaxAttempts {
+				return fmt.Errorf("%w: %+v", errRetryPending, ps)
+			}
+			return nil
+		})
+
+		ps, err := ds.Get("persistent")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if ps.Status != datastore.FailedStatus || ps.NextRetry != nil {
+			t.Fatalf("expected a failed status without further retries, got: %+v", ps)
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	opts := RetryOptions{
+		MaxAttempts:     10,
+		InitialInterval: time.Second,
+		MaxInterval:     4 * time.Second,
+	}
+
+	// The delays are randomized by up to half of the interval
+	if d := opts.delay(1); d < opts.InitialInterval/2 || d > opts.InitialInterval*3/2 {
+		t.Fatalf("unexpected delay for the first retry: %s", d)
+	}
+	for attempt := 2; attempt < opts.MaxAttempts; attempt++ {
+		if d := opts.delay(attempt); d < opts.InitialInterval/2 || d > opts.MaxInterval*3/2 {
+			t.Fatalf("unexpected delay for attempt %d: %s", attempt, d)
+		}
+	}
+}
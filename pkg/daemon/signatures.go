@@ -0,0 +1,191 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/inotify"
+	seiface "github.com/containers/selinuxd/pkg/semodule/interface"
+	"github.com/containers/selinuxd/pkg/signature"
+	"github.com/containers/selinuxd/pkg/utils"
+	"github.com/go-logr/logr"
+)
+
+// verifySignatures checks the detached signatures of the files that make up
+// the policy in `src`: the file itself, and the file contexts and interfaces
+// of type-enforcement sources. The contents that were read are verified, as
+// they're the ones that get installed.
+func verifySignatures(opts AdmissionOptions, src *policySource) error {
+	if opts.Keys == nil {
+		return nil
+	}
+	if _, err := opts.Keys.VerifyDetached(src.path, src.data); err != nil {
+		return fmt.Errorf("%w: %w", errRejected, err)
+	}
+	if src.fc != nil {
+		if _, err := opts.Keys.VerifyDetached(utils.FileContextsPath(src.path), src.fc); err != nil {
+			return fmt.Errorf("%w: %w", errRejected, err)
+		}
+	}
+	if src.iface != nil {
+		if _, err := opts.Keys.VerifyDetached(utils.InterfacePath(src.path), src.iface); err != nil {
+			return fmt.Errorf("%w: %w", errRejected, err)
+		}
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// policyOfCompanion returns the policy file that the file in `path`
+// belongs to, if it exists: file contexts and interfaces belong to their
+// type-enforcement source, and signatures to the file they sign.
+func policyOfCompanion(path string) (string, bool) {
+	if filepath.Ext(path) == signature.Extension {
+		signed := strings.TrimSuffix(path, signature.Extension)
+		if te, ok := sourceOfCompanion(signed); ok {
+			return te, true
+		}
+		if _, err := utils.PolicyNameFromPath(signed); err != nil || !fileExists(signed) {
+			return "", false
+		}
+		return signed, true
+	}
+	return sourceOfCompanion(path)
+}
+
+// Defines a signature verification pass, issued when the trusted keys
+// change. Installed policies whose signature no longer verifies are
+// removed, and rejected policies are given another chance.
+type policyVerify struct{}
+
+func newVerifyAction() PolicyAction {
+	return &policyVerify{}
+}
+
+func (pv *policyVerify) String() string {
+	return "verify signatures"
+}
+
+// key is constant, as a single pass covers any number of key changes
+func (pv *policyVerify) key() string {
+	return "verify-signatures"
+}
+
+func (pv *policyVerify) affectedPolicy() string {
+	return ""
+}
+
+func (pv *policyVerify) do(cfg applyConfig, sh seiface.Handler, ds datastore.DataStore) (string, error) {
+	if cfg.Keys == nil {
+		return "No action needed; signatures aren't required", nil
+	}
+	policies, err := ds.List()
+	if err != nil {
+		return "", fmt.Errorf("verifying signatures: listing datastore entries: %w", err)
+	}
+	sort.Strings(policies)
+
+	revoked := make([]string, 0)
+	readmitted := make([]string, 0)
+	var errs []error
+	for _, policy := range policies {
+		p, err := ds.Get(policy)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("couldn't access datastore: %w", err))
+			continue
+		}
+		if p.SourcePath == "" {
+			continue
+		}
+		switch p.Status {
+		case datastore.InstalledStatus:
+			src, err := readPolicySource(p.SourcePath)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			verifyErr := verifySignatures(cfg.AdmissionOptions, src)
+			if verifyErr == nil {
+				continue
+			}
+			if err := revoke(cfg, sh, ds, p, verifyErr); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			revoked = append(revoked, policy)
+		case datastore.RejectedStatus:
+			if _, err := newInstallAction(p.SourcePath).do(cfg, sh, ds); err == nil {
+				readmitted = append(readmitted, policy)
+			}
+		case datastore.FailedStatus, datastore.BlockedStatus, datastore.RefusedStatus:
+			// These are retried or re-evaluated on their own
+		}
+	}
+
+	msg := fmt.Sprintf("Signature verification done. Revoked: %v. Readmitted: %v", revoked, readmitted)
+	if err := errors.Join(errs...); err != nil {
+		return msg, fmt.Errorf("verifying signatures: %w", err)
+	}
+	return msg, nil
+}
+
+// revoke removes the module of an installed policy whose signature doesn't
+// verify anymore, and marks the policy as rejected.
+func revoke(cfg applyConfig, sh seiface.Handler, ds datastore.DataStore, p datastore.PolicyStatus, reason error,
+) error {
+	if owned := ownedPriority(p); owned != 0 {
+		if err := removeModule(sh, p.Policy, owned); err != nil {
+			return fmt.Errorf("revoking policy %s: %w", p.Policy, err)
+		}
+	}
+	now := time.Now()
+	p.Status = datastore.RejectedStatus
+	p.Message = reason.Error()
+	p.LastUpdated = &now
+	p.OwnedPriority = 0
+	p.OwnedChecksum = nil
+	if err := ds.Put(p); err != nil {
+		return fmt.Errorf("failed persisting status in datastore: %w", err)
+	}
+	return recordHistory(cfg, ds, p.Policy, historyEntry(datastore.HistoryRemove, p, now))
+}
+
+// watchKeys reloads the trusted keys when they change, e.g. when they're
+// rotated, and issues a verification pass with the new ones.
+func watchKeys(watcher *inotify.Watcher, opts AdmissionOptions, policyops ActionAdder, logger logr.Logger) {
+	klog := logger.WithName("key-watcher")
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// Keys that are still being written are reloaded once
+			// they're complete
+			if !event.Op.Has(inotify.CloseWrite | inotify.MovedTo | inotify.Remove | inotify.Rename) {
+				continue
+			}
+			if err := opts.Keys.Reload(); err != nil {
+				klog.Error(err, "Unable to reload the trusted keys, keeping the current ones")
+				continue
+			}
+			klog.Info("The trusted keys changed. Verifying the signatures of the policies")
+			policyops.Add(newVerifyAction())
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			klog.Error(err, "Error watching the trusted keys")
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package daemon
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/selinuxd/pkg/datastore"
+	"github.com/containers/selinuxd/pkg/semodule/test"
+	"github.com/containers/selinuxd/pkg/signature"
+)
+
+func writeTrustedKey(t *testing.T, dir string, key ed25519.PublicKey) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, "trusted.pem"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func signFile(t *testing.T, path string, key ed25519.PrivateKey) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(signature.Path(path), ed25519.Sign(key, data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	moddir := t.TempDir()
+	keysDir := t.TempDir()
+	sh := test.NewSEModuleTestHandler()
+	ds, err := datastore.New(filepath.Join(t.TempDir(), "selinuxd.db"))
+	if err != nil {
+		t.Fatalf("Unable to get R/W datastore: %s", err)
+	}
+	defer ds.Close()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTrustedKey(t, keysDir, pub)
+	keys, err := signature.LoadKeySet(keysDir)
+	if err != nil {
+		t.Fatalf("unexpected error loading the keys: %s", err)
+	}
+	cfg := testConfig(moddir)
+	cfg.Keys = keys
+
+	policy := writePolicy(t, moddir, "signed", "(type signed_t)")
+
+	t.Run("A policy without a signature should be rejected", func(t *testing.T) {
+		if _, err := newInstallAction(policy).do(cfg, sh, ds); !errors.Is(err, errRejected) {
+			t.Fatalf("expected the policy to be rejected, got: %v", err)
+		}
+		ps, err := ds.Get("signed")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if ps.Status != datastore.RejectedStatus || sh.IsModuleInstalled("signed") {
+			t.Fatalf("expected the policy to be rejected, got: %+v", ps)
+		}
+	})
+
+	t.Run("Adding the signature should install the policy", func(t *testing.T) {
+		signFile(t, policy, priv)
+		signed, ok := policyOfCompanion(signature.Path(policy))
+		if !ok || signed != policy {
+			t.Fatalf("expected the signature to belong to %s, got: %s", policy, signed)
+		}
+		if _, err := newInstallAction(signed).do(cfg, sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !sh.IsModuleInstalled("signed") {
+			t.Fatalf("expected the policy to be installed")
+		}
+	})
+
+	newPub, newPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Rotating the keys should revoke the policy", func(t *testing.T) {
+		writeTrustedKey(t, keysDir, newPub)
+		if err := keys.Reload(); err != nil {
+			t.Fatalf("unexpected error reloading the keys: %s", err)
+		}
+		if _, err := newVerifyAction().do(cfg, sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		ps, err := ds.Get("signed")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if ps.Status != datastore.RejectedStatus || ps.OwnedPriority != 0 || sh.IsModuleInstalled("signed") {
+			t.Fatalf("expected the policy to be revoked, got: %+v", ps)
+		}
+
+		// Signing it again with the new key readmits it
+		signFile(t, policy, newPriv)
+		if _, err := newVerifyAction().do(cfg, sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !sh.IsModuleInstalled("signed") {
+			t.Fatalf("expected the policy to be readmitted")
+		}
+	})
+
+	t.Run("Removing the signature should remove the policy", func(t *testing.T) {
+		if err := os.Remove(signature.Path(policy)); err != nil {
+			t.Fatal(err)
+		}
+		// Changes to signatures are applied by re-installing the policy
+		if _, err := newReinstallAction(policy).do(cfg, sh, ds); !errors.Is(err, errRejected) {
+			t.Fatalf("expected the policy to be rejected, got: %v", err)
+		}
+		ps, err := ds.Get("signed")
+		if err != nil {
+			t.Fatalf("Unable to get policy status: %s", err)
+		}
+		if ps.Status != datastore.RejectedStatus || ps.OwnedPriority != 0 || sh.IsModuleInstalled("signed") {
+			t.Fatalf("expected the policy to be removed, got: %+v", ps)
+		}
+		entries, err := ds.History("signed")
+		if err != nil {
+			t.Fatalf("Unable to get policy history: %s", err)
+		}
+		if last := entries[len(entries)-1]; last.Action != datastore.HistoryRemove {
+			t.Fatalf("expected the removal in the history, got: %+v", last)
+		}
+	})
+
+	t.Run("The signed contents should be the ones installed", func(t *testing.T) {
+		signFile(t, policy, newPriv)
+		action := newInstallAction(policy)
+		if _, err := action.(*policyInstall).source(); err != nil {
+			t.Fatal(err)
+		}
+		// Swapping the file once it was read doesn't get the new
+		// contents installed
+		writePolicy(t, moddir, "signed", "(type unsigned_t)")
+		if _, err := action.do(cfg, sh, ds); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		data, err := sh.Extract("signed", DefaultPriority)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "(type signed_t)" {
+			t.Fatalf("expected the signed contents to be installed, got: %q", data)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package daemon
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/containers/selinuxd/pkg/datastore"
@@ -28,15 +30,25 @@ type StatusServerConfig struct {
 	EnableProfiling bool
 }
 
+// reconcileFunc triggers a reconciliation pass and returns its report
+type reconcileFunc func(ctx context.Context) (*ReconcileReport, error)
+
+// adoptFunc triggers an adoption pass and returns its report
+type adoptFunc func(ctx context.Context) (*AdoptReport, error)
+
 type statusServer struct {
-	cfg   StatusServerConfig
-	ds    datastore.ReadOnlyDataStore
-	l     logr.Logger
-	lst   net.Listener
-	ready bool
+	cfg       StatusServerConfig
+	ds        datastore.ReadOnlyDataStore
+	scan      *initialScan
+	reconcile reconcileFunc
+	adopt     adoptFunc
+	l         logr.Logger
+	lst       net.Listener
 }
 
-func initStatusServer(cfg StatusServerConfig, ds datastore.ReadOnlyDataStore, l logr.Logger) (*statusServer, error) {
+func initStatusServer(cfg StatusServerConfig, ds datastore.ReadOnlyDataStore, scan *initialScan,
+	reconcile reconcileFunc, adopt adoptFunc, l logr.Logger,
+) (*statusServer, error) {
 	if cfg.Path == "" {
 		cfg.Path = DefaultUnixSockAddr
 	}
@@ -48,11 +60,11 @@ func initStatusServer(cfg StatusServerConfig, ds datastore.ReadOnlyDataStore, l
 		return nil, fmt.Errorf("setting up socket: %w", err)
 	}
 
-	ss := &statusServer{cfg, ds, l, lst, false}
+	ss := &statusServer{cfg, ds, scan, reconcile, adopt, l, lst}
 	return ss, nil
 }
 
-func (ss *statusServer) Serve(readychan <-chan bool) error {
+func (ss *statusServer) Serve() error {
 	r := chi.NewRouter()
 	ss.initializeRoutes(r)
 
@@ -61,27 +73,25 @@ func (ss *statusServer) Serve(readychan <-chan bool) error {
 		ReadTimeout: readTimeout,
 	}
 
-	go ss.waitForReady(readychan)
-
 	if err := server.Serve(ss.lst); err != nil {
 		ss.l.Info("Server shutting down: %s", err)
 	}
 	return nil
 }
 
-func (ss *statusServer) waitForReady(readychan <-chan bool) {
-	ready := <-readychan
-	ss.ready = ready
-	ss.l.Info("Status Server got READY signal")
-}
-
 func (ss *statusServer) initializeRoutes(r chi.Router) {
 	// /policies/
 	r.Route("/policies", func(r chi.Router) {
 		r.Get("/", ss.listPoliciesHandler)
 		r.Get("/{policy}", ss.getPolicyStatusHandler)
+		r.Get("/{policy}/history", ss.getPolicyHistoryHandler)
 	})
 
+	r.Post("/reconcile", ss.reconcileHandler)
+	r.Post("/reconcile/", ss.reconcileHandler)
+	r.Post("/adopt", ss.adoptHandler)
+	r.Post("/adopt/", ss.adoptHandler)
+
 	r.Get("/ready", ss.readyStatusHandler)
 	r.Get("/ready/", ss.readyStatusHandler)
 	r.Get("/", ss.catchAllHandler)
@@ -134,17 +144,82 @@ func (ss *statusServer) getPolicyStatusHandler(w http.ResponseWriter, r *http.Re
 	}
 }
 
+func (ss *statusServer) getPolicyHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	policy := chi.URLParam(r, "policy")
+	history, err := ss.ds.History(policy)
+	if errors.Is(err, datastore.ErrPolicyNotFound) {
+		http.Error(w, "couldn't find the history of the requested policy", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ss.l.Error(err, "error getting history")
+		http.Error(w, "Cannot get history", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(history)
+	if err != nil {
+		ss.l.Error(err, "error writing history response")
+		http.Error(w, "Cannot get history", http.StatusInternalServerError)
+	}
+}
+
 func (ss *statusServer) readyStatusHandler(w http.ResponseWriter, r *http.Request) {
-	output := map[string]bool{
-		"ready": ss.ready,
+	strict := false
+	if strictParam := r.URL.Query().Get("strict"); strictParam != "" {
+		var err error
+		strict, err = strconv.ParseBool(strictParam)
+		if err != nil {
+			http.Error(w, "Invalid value for the 'strict' parameter", http.StatusBadRequest)
+			return
+		}
 	}
 
+	output := ss.scan.status(ss.ds, strict)
+
 	if err := json.NewEncoder(w).Encode(output); err != nil {
 		ss.l.Error(err, "error writing ready response")
 		http.Error(w, "Cannot get ready status", http.StatusInternalServerError)
 	}
 }
 
+func (ss *statusServer) reconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if ss.reconcile == nil {
+		http.Error(w, "Reconciliation is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	report, err := ss.reconcile(r.Context())
+	if err != nil {
+		ss.l.Error(err, "error reconciling policies")
+		http.Error(w, "Cannot reconcile policies", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		ss.l.Error(err, "error writing reconcile response")
+		http.Error(w, "Cannot reconcile policies", http.StatusInternalServerError)
+	}
+}
+
+func (ss *statusServer) adoptHandler(w http.ResponseWriter, r *http.Request) {
+	if ss.adopt == nil {
+		http.Error(w, "Adoption is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	report, err := ss.adopt(r.Context())
+	if err != nil {
+		ss.l.Error(err, "error adopting policies")
+		http.Error(w, "Cannot adopt policies", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		ss.l.Error(err, "error writing adopt response")
+		http.Error(w, "Cannot adopt policies", http.StatusInternalServerError)
+	}
+}
+
 func (ss *statusServer) catchAllHandler(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Invalid path", http.StatusBadRequest)
 }
@@ -172,12 +247,12 @@ func createSocket(path string, uid, gid int) (net.Listener, error) {
 	return listener, nil
 }
 
-func serveState(server *statusServer, readychan <-chan bool, logger logr.Logger) {
+func serveState(server *statusServer, logger logr.Logger) {
 	slog := logger.WithName("state-server")
 
 	slog.Info("Serving status", "path", server.cfg.Path, "uid", server.cfg.UID, "gid", server.cfg.GID)
 
-	if err := server.Serve(readychan); err != nil {
+	if err := server.Serve(); err != nil {
 		slog.Error(err, "Error starting status server")
 	}
 }
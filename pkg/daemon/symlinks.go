@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/selinuxd/pkg/utils"
+)
+
+// Kubernetes ConfigMap and Secret volumes are laid out as follows:
+//
+//	..2021_01_01_00_00_00.000000000/name.cil
+//	..data -> ..2021_01_01_00_00_00.000000000
+//	name.cil -> ..data/name.cil
+//
+// Updates are done by writing a new timestamped directory and atomically
+// renaming a new `..data` symlink over the old one.
+const atomicDataDir = "..data"
+
+// ScanOptions tunes how the module directory is traversed and watched
+type ScanOptions struct {
+	// FollowSymlinks makes selinuxd install the policies that symlinks
+	// point to, as opposed to ignoring them. This also enables support
+	// for the layout of Kubernetes ConfigMap and Secret volumes.
+	FollowSymlinks bool
+}
+
+// resolvePolicySymlink returns the file that the symlink points to. If the
+// symlink is dangling or doesn't point to a regular file, it returns false.
+func resolvePolicySymlink(path string) (string, bool) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false
+	}
+	info, err := os.Stat(target)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	return target, true
+}
+
+// symlinkDestination returns where the symlink points to, even if
+// the destination doesn't exist anymore.
+func symlinkDestination(path string) (string, bool) {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		return target, true
+	}
+	dest, err := os.Readlink(path)
+	if err != nil {
+		return "", false
+	}
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(filepath.Dir(path), dest)
+	}
+	return filepath.Clean(dest), true
+}
+
+// isWithin tells whether `path` is `dir` or is inside of it
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// linksTo returns the symlinks in `mpath` that point to `target`
+func linksTo(mpath, target string) []string {
+	links := make([]string, 0)
+	//nolint:errcheck // a partial result is good enough
+	filepath.Walk(mpath, func(path string, info os.FileInfo, err error) error {
+		if info == nil {
+			return nil
+		}
+		if path != mpath && utils.IsBookkeepingEntry(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		if dest, ok := symlinkDestination(path); ok && dest == target {
+			links = append(links, path)
+		}
+		return nil
+	})
+	return links
+}
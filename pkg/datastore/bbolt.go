@@ -1,43 +1,85 @@
 package datastore
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
 
+// lockTimeout is how long to wait for another process to release the
+// datastore. bbolt would wait forever otherwise.
+const lockTimeout = 5 * time.Second
+
+// conflictSeparator separates the paths of the conflicting files. Paths
+// can't contain NUL characters.
+const conflictSeparator = "\x00"
+
 type bboltDataStore struct {
 	root []byte
-	db   *bolt.DB
+	// history holds a bucket per policy, with its history entries keyed
+	// by their sequence number
+	history []byte
+	db      *bolt.DB
 }
 
 // New returns a new instance of a DataStore
 func newBboltDS(path string) (DataStore, error) {
 	ds := &bboltDataStore{
-		root: []byte("Policies-v1"),
+		root:    policiesBucket,
+		history: historyBucket,
 	}
 	// NOTE(jaosorior): We should use /tmp or /run as SELinux policies
 	// only persist in memory. We don't need to keep track of the policies
 	// in-between host reboots. This is only needed for daemon reboots.
-	db, err := bolt.Open(path, 0o600, nil) //nolint
-	if err != nil {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: lockTimeout}) //nolint
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("couldn't open datastore %s: %w", path, ErrDataStoreLocked)
+	} else if err != nil {
 		return nil, fmt.Errorf("couldn't create datastore: %w", err)
 	}
 	ds.db = db
 
-	err = ds.db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(ds.root)
-		if err != nil {
-			return fmt.Errorf("create bucket: %w", err)
-		}
-		return nil
-	})
-	if err != nil {
+	if err := ds.db.Update(migrate); err != nil {
+		// NOTE: The file stays locked until it's closed
+		db.Close() //nolint:errcheck // the initialization error is more relevant
 		return nil, fmt.Errorf("couldn't initialize datastore: %w", err)
 	}
 	return ds, nil
 }
 
+// newBboltReadOnlyDS opens the existing datastore in `path` without
+// writing to it. As it can't be migrated, a datastore with an older layout
+// is refused.
+func newBboltReadOnlyDS(path string) (ReadOnlyDataStore, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("couldn't open datastore %s: %w", path, ErrDataStoreNotFound)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: lockTimeout, ReadOnly: true})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("couldn't open datastore %s: %w", path, ErrDataStoreLocked)
+	} else if err != nil {
+		return nil, fmt.Errorf("couldn't open datastore: %w", err)
+	}
+
+	if err := db.View(checkSchema); err != nil {
+		db.Close() //nolint:errcheck // the schema error is more relevant
+		return nil, fmt.Errorf("couldn't open datastore %s: %w", path, err)
+	}
+	return &bboltDataStore{
+		root:    policiesBucket,
+		history: historyBucket,
+		db:      db,
+	}, nil
+}
+
 func (ds *bboltDataStore) Close() error {
 	if ds.db == nil {
 		return ErrDataStoreNotInitialized
@@ -62,23 +104,7 @@ func (ds *bboltDataStore) Put(status PolicyStatus) error {
 		if root == nil {
 			return ErrDataStoreNotInitialized
 		}
-		bkt, err := root.CreateBucketIfNotExists([]byte(status.Policy))
-		if err != nil {
-			return fmt.Errorf("couldn't create policy entry: %w", err)
-		}
-		err = bkt.Put([]byte("status"), []byte(status.Status))
-		if err != nil {
-			return fmt.Errorf("couldn't persist policy status: %w", err)
-		}
-		err = bkt.Put([]byte("msg"), []byte(status.Message))
-		if err != nil {
-			return fmt.Errorf("couldn't persist policy status message: %w", err)
-		}
-		err = bkt.Put([]byte("checksum"), status.Checksum)
-		if err != nil {
-			return fmt.Errorf("couldn't persist policy status message: %w", err)
-		}
-		return nil
+		return putStatus(root, status)
 	})
 	if err != nil {
 		return fmt.Errorf("couldn't put policy status: %w", err)
@@ -86,8 +112,91 @@ func (ds *bboltDataStore) Put(status PolicyStatus) error {
 	return nil
 }
 
+// putStatus stores the status in the policies bucket `root`
+func putStatus(root *bolt.Bucket, status PolicyStatus) error {
+	bkt, err := root.CreateBucketIfNotExists([]byte(status.Policy))
+	if err != nil {
+		return fmt.Errorf("couldn't create policy entry: %w", err)
+	}
+	err = bkt.Put([]byte("status"), []byte(status.Status))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy status: %w", err)
+	}
+	err = bkt.Put([]byte("msg"), []byte(status.Message))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy status message: %w", err)
+	}
+	err = bkt.Put([]byte("checksum"), status.Checksum)
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy status message: %w", err)
+	}
+	err = bkt.Put([]byte("attempt"), []byte(strconv.Itoa(status.Attempt)))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy install attempt: %w", err)
+	}
+	err = bkt.Put([]byte("nextRetry"), formatTime(status.NextRetry))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy next retry: %w", err)
+	}
+	err = bkt.Put([]byte("source"), []byte(status.SourcePath))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy source path: %w", err)
+	}
+	err = bkt.Put([]byte("conflicts"), []byte(strings.Join(status.Conflicts, conflictSeparator)))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy conflicts: %w", err)
+	}
+	err = bkt.Put([]byte("moduleDir"), []byte(status.ModuleDir))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy module directory: %w", err)
+	}
+	err = bkt.Put([]byte("priority"), []byte(strconv.Itoa(int(status.Priority))))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy priority: %w", err)
+	}
+	err = bkt.Put([]byte("ownedPriority"), []byte(strconv.Itoa(int(status.OwnedPriority))))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy owned priority: %w", err)
+	}
+	err = bkt.Put([]byte("ownedChecksum"), status.OwnedChecksum)
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy owned checksum: %w", err)
+	}
+	err = bkt.Put([]byte("firstInstalled"), formatTime(status.FirstInstalled))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy first install time: %w", err)
+	}
+	err = bkt.Put([]byte("lastUpdated"), formatTime(status.LastUpdated))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy last update time: %w", err)
+	}
+	err = bkt.Put([]byte("sourceSize"), []byte(strconv.FormatInt(status.SourceSize, 10)))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy source size: %w", err)
+	}
+	err = bkt.Put([]byte("sourceModTime"), formatTime(status.SourceModTime))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy source modification time: %w", err)
+	}
+	err = bkt.Put([]byte("sourceOwner"), []byte(status.SourceOwner))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy source owner: %w", err)
+	}
+	err = bkt.Put([]byte("backend"), []byte(status.Backend))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy backend: %w", err)
+	}
+	err = bkt.Put([]byte("installAttempts"), []byte(strconv.Itoa(status.InstallAttempts)))
+	if err != nil {
+		return fmt.Errorf("couldn't persist policy install attempts: %w", err)
+	}
+	return nil
+}
+
 func (ds *bboltDataStore) Get(policy string) (PolicyStatus, error) {
-	var status, msg, cs []byte
+	var status, msg, cs, attempt, nextRetry, source, conflicts, moduleDir, priority []byte
+	var ownedPriority, ownedChecksum []byte
+	var firstInstalled, lastUpdated, sourceSize, sourceModTime, sourceOwner, backend, installAttempts []byte
 	if ds.db == nil {
 		return PolicyStatus{}, ErrDataStoreNotInitialized
 	}
@@ -100,21 +209,114 @@ func (ds *bboltDataStore) Get(policy string) (PolicyStatus, error) {
 		if b == nil {
 			return fmt.Errorf("%w: %s", ErrPolicyNotFound, policy)
 		}
-		status = b.Get([]byte("status"))
-		msg = b.Get([]byte("msg"))
-		cs = b.Get([]byte("checksum"))
+		// NOTE: values returned by bbolt are only valid for the
+		// life of the transaction, so we need to copy them.
+		status = bytes.Clone(b.Get([]byte("status")))
+		msg = bytes.Clone(b.Get([]byte("msg")))
+		cs = bytes.Clone(b.Get([]byte("checksum")))
+		attempt = bytes.Clone(b.Get([]byte("attempt")))
+		nextRetry = bytes.Clone(b.Get([]byte("nextRetry")))
+		source = bytes.Clone(b.Get([]byte("source")))
+		conflicts = bytes.Clone(b.Get([]byte("conflicts")))
+		moduleDir = bytes.Clone(b.Get([]byte("moduleDir")))
+		priority = bytes.Clone(b.Get([]byte("priority")))
+		ownedPriority = bytes.Clone(b.Get([]byte("ownedPriority")))
+		ownedChecksum = bytes.Clone(b.Get([]byte("ownedChecksum")))
+		firstInstalled = bytes.Clone(b.Get([]byte("firstInstalled")))
+		lastUpdated = bytes.Clone(b.Get([]byte("lastUpdated")))
+		sourceSize = bytes.Clone(b.Get([]byte("sourceSize")))
+		sourceModTime = bytes.Clone(b.Get([]byte("sourceModTime")))
+		sourceOwner = bytes.Clone(b.Get([]byte("sourceOwner")))
+		backend = bytes.Clone(b.Get([]byte("backend")))
+		installAttempts = bytes.Clone(b.Get([]byte("installAttempts")))
 		return nil
 	})
 	if err != nil {
 		return PolicyStatus{}, fmt.Errorf("couldn't get policy status: %w", err)
 	}
 
-	return PolicyStatus{
-		Policy:   policy,
-		Status:   StatusType(status),
-		Message:  string(msg),
-		Checksum: cs,
-	}, nil
+	ps := PolicyStatus{
+		Policy:      policy,
+		Status:      StatusType(status),
+		Message:     string(msg),
+		Checksum:    cs,
+		SourcePath:  string(source),
+		ModuleDir:   string(moduleDir),
+		SourceOwner: string(sourceOwner),
+		Backend:     string(backend),
+	}
+	if len(ownedChecksum) > 0 {
+		ps.OwnedChecksum = ownedChecksum
+	}
+	if len(conflicts) > 0 {
+		ps.Conflicts = strings.Split(string(conflicts), conflictSeparator)
+	}
+	// NOTE: entries written by older versions don't have these keys
+	if len(attempt) > 0 {
+		ps.Attempt, err = strconv.Atoi(string(attempt))
+		if err != nil {
+			return PolicyStatus{}, fmt.Errorf("couldn't parse policy install attempt: %w", err)
+		}
+	}
+	if len(priority) > 0 {
+		prio, err := strconv.ParseUint(string(priority), 10, 16)
+		if err != nil {
+			return PolicyStatus{}, fmt.Errorf("couldn't parse policy priority: %w", err)
+		}
+		ps.Priority = uint16(prio)
+	}
+	if len(ownedPriority) > 0 {
+		prio, err := strconv.ParseUint(string(ownedPriority), 10, 16)
+		if err != nil {
+			return PolicyStatus{}, fmt.Errorf("couldn't parse policy owned priority: %w", err)
+		}
+		ps.OwnedPriority = uint16(prio)
+	}
+	if len(installAttempts) > 0 {
+		ps.InstallAttempts, err = strconv.Atoi(string(installAttempts))
+		if err != nil {
+			return PolicyStatus{}, fmt.Errorf("couldn't parse policy install attempts: %w", err)
+		}
+	}
+	if len(sourceSize) > 0 {
+		ps.SourceSize, err = strconv.ParseInt(string(sourceSize), 10, 64)
+		if err != nil {
+			return PolicyStatus{}, fmt.Errorf("couldn't parse policy source size: %w", err)
+		}
+	}
+	if ps.NextRetry, err = parseTime(nextRetry); err != nil {
+		return PolicyStatus{}, fmt.Errorf("couldn't parse policy next retry: %w", err)
+	}
+	if ps.FirstInstalled, err = parseTime(firstInstalled); err != nil {
+		return PolicyStatus{}, fmt.Errorf("couldn't parse policy first install time: %w", err)
+	}
+	if ps.LastUpdated, err = parseTime(lastUpdated); err != nil {
+		return PolicyStatus{}, fmt.Errorf("couldn't parse policy last update time: %w", err)
+	}
+	if ps.SourceModTime, err = parseTime(sourceModTime); err != nil {
+		return PolicyStatus{}, fmt.Errorf("couldn't parse policy source modification time: %w", err)
+	}
+	return ps, nil
+}
+
+// formatTime encodes an optional time. Unset times are stored as empty values.
+func formatTime(t *time.Time) []byte {
+	if t == nil {
+		return nil
+	}
+	return []byte(t.Format(time.RFC3339Nano))
+}
+
+// parseTime decodes a time stored by formatTime
+func parseTime(value []byte) (*time.Time, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(value))
+	if err != nil {
+		return nil, err //nolint:wrapcheck // this is wrapped by the caller
+	}
+	return &t, nil
 }
 
 func (ds *bboltDataStore) List() ([]string, error) {
@@ -141,10 +343,249 @@ func (ds *bboltDataStore) Remove(policy string) error {
 		if root == nil {
 			return ErrDataStoreNotInitialized
 		}
-		return root.DeleteBucket([]byte(policy))
+		err := root.DeleteBucket([]byte(policy))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return fmt.Errorf("%w: %s", ErrPolicyNotFound, policy)
+		}
+		return err //nolint:wrapcheck // this is wrapped below
 	})
 	if err != nil {
 		return fmt.Errorf("couldn't remove policy from db: %w", err)
 	}
 	return nil
 }
+
+func (ds *bboltDataStore) History(policy string) ([]HistoryEntry, error) {
+	if ds.db == nil {
+		return nil, ErrDataStoreNotInitialized
+	}
+	var entries []HistoryEntry
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(ds.history)
+		if root == nil {
+			return ErrDataStoreNotInitialized
+		}
+		b := root.Bucket([]byte(policy))
+		if b == nil {
+			return fmt.Errorf("%w: %s", ErrPolicyNotFound, policy)
+		}
+		var err error
+		_, entries, err = readHistory(b)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get policy history: %w", err)
+	}
+	return entries, nil
+}
+
+func (ds *bboltDataStore) AppendHistory(policy string, entry HistoryEntry, retention HistoryRetention) error {
+	if ds.db == nil {
+		return ErrDataStoreNotInitialized
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("couldn't encode history entry: %w", err)
+	}
+	err = ds.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(ds.history)
+		if root == nil {
+			return ErrDataStoreNotInitialized
+		}
+		b, err := appendHistory(root, policy, value)
+		if err != nil {
+			return err
+		}
+		_, err = pruneHistory(b, retention)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't append policy history: %w", err)
+	}
+	return nil
+}
+
+func (ds *bboltDataStore) PruneHistory(retention HistoryRetention) error {
+	if ds.db == nil {
+		return ErrDataStoreNotInitialized
+	}
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(ds.history)
+		if root == nil {
+			return ErrDataStoreNotInitialized
+		}
+		// NOTE: buckets can't be deleted while iterating over them
+		var policies [][]byte
+		if err := root.ForEach(func(k, _ []byte) error {
+			policies = append(policies, bytes.Clone(k))
+			return nil
+		}); err != nil {
+			return fmt.Errorf("couldn't list policy histories: %w", err)
+		}
+		for _, policy := range policies {
+			b := root.Bucket(policy)
+			if b == nil {
+				continue
+			}
+			left, err := pruneHistory(b, retention)
+			if err != nil {
+				return err
+			}
+			if left > 0 {
+				continue
+			}
+			if err := root.DeleteBucket(policy); err != nil {
+				return fmt.Errorf("couldn't remove policy history: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't prune policy history: %w", err)
+	}
+	return nil
+}
+
+func (ds *bboltDataStore) ListHistory() ([]string, error) {
+	if ds.db == nil {
+		return nil, ErrDataStoreNotInitialized
+	}
+	var output []string
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(ds.history)
+		if root == nil {
+			return ErrDataStoreNotInitialized
+		}
+		return root.ForEach(func(k, _ []byte) error {
+			output = append(output, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return output, fmt.Errorf("couldn't list policy histories: %w", err)
+	}
+	return output, nil
+}
+
+func (ds *bboltDataStore) RemoveHistory(policy string) error {
+	if ds.db == nil {
+		return ErrDataStoreNotInitialized
+	}
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(ds.history)
+		if root == nil {
+			return ErrDataStoreNotInitialized
+		}
+		err := root.DeleteBucket([]byte(policy))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return fmt.Errorf("%w: %s", ErrPolicyNotFound, policy)
+		}
+		return err //nolint:wrapcheck // this is wrapped below
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't remove policy history: %w", err)
+	}
+	return nil
+}
+
+// appendHistory adds the encoded entry to the history of the policy, in the
+// history bucket `root`. It returns the policy's bucket.
+func appendHistory(root *bolt.Bucket, policy string, value []byte) (*bolt.Bucket, error) {
+	b, err := root.CreateBucketIfNotExists([]byte(policy))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create policy history: %w", err)
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get history sequence: %w", err)
+	}
+	if err := b.Put(historyKey(seq), value); err != nil {
+		return nil, fmt.Errorf("couldn't persist history entry: %w", err)
+	}
+	return b, nil
+}
+
+// Replace swaps the policies and history buckets in a single transaction
+func (ds *bboltDataStore) Replace(dump *Dump) error {
+	if ds.db == nil {
+		return ErrDataStoreNotInitialized
+	}
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{ds.root, ds.history} {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return fmt.Errorf("couldn't clear datastore: %w", err)
+			}
+		}
+		root, err := tx.CreateBucket(ds.root)
+		if err != nil {
+			return fmt.Errorf("couldn't clear datastore: %w", err)
+		}
+		history, err := tx.CreateBucket(ds.history)
+		if err != nil {
+			return fmt.Errorf("couldn't clear datastore: %w", err)
+		}
+		for policy, ps := range dump.Policies {
+			ps.Policy = policy
+			if err := putStatus(root, ps); err != nil {
+				return fmt.Errorf("policy %s: %w", policy, err)
+			}
+		}
+		for policy, entries := range dump.History {
+			for _, entry := range entries {
+				value, err := json.Marshal(entry)
+				if err != nil {
+					return fmt.Errorf("couldn't encode history entry of policy %s: %w", policy, err)
+				}
+				if _, err := appendHistory(history, policy, value); err != nil {
+					return fmt.Errorf("history of policy %s: %w", policy, err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't replace datastore contents: %w", err)
+	}
+	return nil
+}
+
+// historyKey encodes sequence numbers so that they sort in order
+func historyKey(seq uint64) []byte {
+	key := make([]byte, 8) //nolint:mnd // the size of an uint64
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// readHistory returns the keys and the entries in a policy's history
+// bucket, oldest first
+func readHistory(b *bolt.Bucket) ([][]byte, []HistoryEntry, error) {
+	var keys [][]byte
+	var entries []HistoryEntry
+	err := b.ForEach(func(k, v []byte) error {
+		var entry HistoryEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return fmt.Errorf("couldn't parse history entry: %w", err)
+		}
+		keys = append(keys, bytes.Clone(k))
+		entries = append(entries, entry)
+		return nil
+	})
+	//nolint:wrapcheck // this is wrapped by the callers
+	return keys, entries, err
+}
+
+// pruneHistory drops the entries that `retention` doesn't keep, and
+// returns how many are left
+func pruneHistory(b *bolt.Bucket, retention HistoryRetention) (int, error) {
+	keys, entries, err := readHistory(b)
+	if err != nil {
+		return 0, err
+	}
+	expired := retention.expired(entries, time.Now())
+	for _, k := range keys[:expired] {
+		if err := b.Delete(k); err != nil {
+			return 0, fmt.Errorf("couldn't remove history entry: %w", err)
+		}
+	}
+	return len(keys) - expired, nil
+}
@@ -11,23 +11,52 @@ type StatusType string
 const (
 	InstalledStatus StatusType = "Installed"
 	FailedStatus    StatusType = "Failed"
+	// BlockedStatus is for policies that weren't installed because
+	// a policy they depend on failed.
+	BlockedStatus StatusType = "Blocked"
+	// RefusedStatus is for policies that weren't installed because a
+	// module that selinuxd doesn't own would be overwritten.
+	RefusedStatus StatusType = "Refused"
+	// RejectedStatus is for policies that weren't installed because they
+	// didn't pass the admission checks.
+	RejectedStatus StatusType = "Rejected"
 )
 
 var (
 	ErrPolicyNotFound          = errors.New("policy not found in datastore")
 	ErrDataStoreNotInitialized = errors.New("datastore not initialized")
+	// ErrDataStoreLocked is returned when another process, usually a
+	// running selinuxd, holds the datastore
+	ErrDataStoreLocked = errors.New("datastore is locked by another process")
+	// ErrDataStoreNotFound is returned when a datastore that's opened
+	// read-only doesn't exist, as it isn't created then
+	ErrDataStoreNotFound = errors.New("datastore doesn't exist")
 )
 
 type ReadOnlyDataStore interface {
 	Close() error
 	Get(policy string) (PolicyStatus, error)
 	List() ([]string, error)
+	// History returns the history of the policy, oldest entry first. It's
+	// kept after the policy is removed.
+	History(policy string) ([]HistoryEntry, error)
+	// ListHistory returns the policies that have a history, including
+	// the ones that were removed
+	ListHistory() ([]string, error)
 }
 
 type DataStore interface {
 	ReadOnlyDataStore
 	Put(status PolicyStatus) error
 	Remove(policy string) error
+	// AppendHistory adds an entry to the history of the policy, and drops
+	// the entries that `retention` doesn't keep anymore
+	AppendHistory(policy string, entry HistoryEntry, retention HistoryRetention) error
+	// PruneHistory drops the history entries of every policy that
+	// `retention` doesn't keep anymore
+	PruneHistory(retention HistoryRetention) error
+	// RemoveHistory drops the whole history of the policy
+	RemoveHistory(policy string) error
 	GetReadOnly() ReadOnlyDataStore
 }
 
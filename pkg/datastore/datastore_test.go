@@ -1,9 +1,17 @@
 package datastore
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
 )
 
 func getNewStorePath(t *testing.T) (dspath string, cleanup func()) {
@@ -198,3 +206,804 @@ func TestRemovePolicy(t *testing.T) {
 			len(policies), 0)
 	}
 }
+
+func TestStatusRetry(t *testing.T) {
+	nextRetry := time.Now().Add(time.Minute)
+	status := PolicyStatus{
+		Status:    FailedStatus,
+		Policy:    "my-policy",
+		Message:   "lock contention",
+		Attempt:   2,
+		NextRetry: &nextRetry,
+	}
+
+	path, filecleanup := getNewStorePath(t)
+	defer filecleanup()
+	ds, dscleanup := getNewStore(path, t)
+	defer dscleanup()
+
+	if err := ds.Put(status); err != nil {
+		t.Errorf("DataStore.PutStatus() error = %v", err)
+	}
+
+	rs, err := ds.Get(status.Policy)
+	if err != nil {
+		t.Fatalf("DataStore.GetStatus() error = %v", err)
+	}
+	if rs.Attempt != status.Attempt {
+		t.Errorf("DataStore.GetStatus() attempt didn't match. got: %d, expected: %d", rs.Attempt, status.Attempt)
+	}
+	if rs.NextRetry == nil || !rs.NextRetry.Equal(nextRetry) {
+		t.Errorf("DataStore.GetStatus() next retry didn't match. got: %v, expected: %s", rs.NextRetry, nextRetry)
+	}
+
+	// Once installed, there's nothing left to retry
+	status.Status = InstalledStatus
+	status.Attempt = 0
+	status.NextRetry = nil
+	if err := ds.Put(status); err != nil {
+		t.Errorf("DataStore.PutStatus() error = %v", err)
+	}
+	rs, err = ds.Get(status.Policy)
+	if err != nil {
+		t.Fatalf("DataStore.GetStatus() error = %v", err)
+	}
+	if rs.Attempt != 0 || rs.NextRetry != nil {
+		t.Errorf("DataStore.GetStatus() expected no retry state, got: %+v", rs)
+	}
+}
+
+func TestStatusConflicts(t *testing.T) {
+	status := PolicyStatus{
+		Status:     InstalledStatus,
+		Policy:     "web",
+		SourcePath: "/etc/selinux.d/teamA/web.cil",
+		ModuleDir:  "/etc/selinux.d",
+		Priority:   350,
+		Conflicts:  []string{"/etc/selinux.d/teamB/web.pp", "/etc/selinux.d/teamC/web.cil"},
+	}
+
+	path, filecleanup := getNewStorePath(t)
+	defer filecleanup()
+	ds, dscleanup := getNewStore(path, t)
+	defer dscleanup()
+
+	if err := ds.Put(status); err != nil {
+		t.Errorf("DataStore.PutStatus() error = %v", err)
+	}
+
+	rs, err := ds.Get(status.Policy)
+	if err != nil {
+		t.Fatalf("DataStore.GetStatus() error = %v", err)
+	}
+	if rs.SourcePath != status.SourcePath {
+		t.Errorf("DataStore.GetStatus() source path didn't match. got: %s, expected: %s", rs.SourcePath, status.SourcePath)
+	}
+	if rs.ModuleDir != status.ModuleDir || rs.Priority != status.Priority {
+		t.Errorf("DataStore.GetStatus() module directory didn't match. got: %s:%d, expected: %s:%d",
+			rs.ModuleDir, rs.Priority, status.ModuleDir, status.Priority)
+	}
+	if len(rs.Conflicts) != 2 || rs.Conflicts[0] != status.Conflicts[0] || rs.Conflicts[1] != status.Conflicts[1] {
+		t.Errorf("DataStore.GetStatus() conflicts didn't match. got: %v, expected: %v", rs.Conflicts, status.Conflicts)
+	}
+}
+
+func TestStatusOwnership(t *testing.T) {
+	status := PolicyStatus{
+		Status:        RefusedStatus,
+		Policy:        "container",
+		Checksum:      []byte("new"),
+		Priority:      400,
+		OwnedPriority: 350,
+		OwnedChecksum: []byte("old"),
+	}
+
+	path, filecleanup := getNewStorePath(t)
+	defer filecleanup()
+	ds, dscleanup := getNewStore(path, t)
+	defer dscleanup()
+
+	if err := ds.Put(status); err != nil {
+		t.Errorf("DataStore.PutStatus() error = %v", err)
+	}
+
+	rs, err := ds.Get(status.Policy)
+	if err != nil {
+		t.Fatalf("DataStore.GetStatus() error = %v", err)
+	}
+	if rs.Status != RefusedStatus {
+		t.Errorf("DataStore.GetStatus() status didn't match. got: %s, expected: %s", rs.Status, RefusedStatus)
+	}
+	if rs.OwnedPriority != status.OwnedPriority || !bytes.Equal(rs.OwnedChecksum, status.OwnedChecksum) {
+		t.Errorf("DataStore.GetStatus() ownership didn't match. got: %d:%s, expected: %d:%s",
+			rs.OwnedPriority, rs.OwnedChecksum, status.OwnedPriority, status.OwnedChecksum)
+	}
+}
+
+func TestStatusDetails(t *testing.T) {
+	firstInstalled := time.Now().Add(-time.Hour)
+	lastUpdated := time.Now()
+	modTime := time.Now().Add(-2 * time.Hour)
+	status := PolicyStatus{
+		Status:          InstalledStatus,
+		Policy:          "web",
+		Checksum:        []byte{0xca, 0xfe},
+		FirstInstalled:  &firstInstalled,
+		LastUpdated:     &lastUpdated,
+		SourceSize:      1 << 20,
+		SourceModTime:   &modTime,
+		SourceOwner:     "0:0",
+		Backend:         "semanage",
+		InstallAttempts: 3,
+		Priority:        400,
+		OwnedPriority:   400,
+	}
+
+	path, filecleanup := getNewStorePath(t)
+	defer filecleanup()
+	ds, dscleanup := getNewStore(path, t)
+	defer dscleanup()
+
+	if err := ds.Put(status); err != nil {
+		t.Errorf("DataStore.PutStatus() error = %v", err)
+	}
+
+	rs, err := ds.Get(status.Policy)
+	if err != nil {
+		t.Fatalf("DataStore.GetStatus() error = %v", err)
+	}
+	if rs.FirstInstalled == nil || !rs.FirstInstalled.Equal(firstInstalled) ||
+		rs.LastUpdated == nil || !rs.LastUpdated.Equal(lastUpdated) {
+		t.Errorf("DataStore.GetStatus() timestamps didn't match. got: %v %v, expected: %s %s",
+			rs.FirstInstalled, rs.LastUpdated, firstInstalled, lastUpdated)
+	}
+	if rs.SourceSize != status.SourceSize || rs.SourceOwner != status.SourceOwner ||
+		rs.SourceModTime == nil || !rs.SourceModTime.Equal(modTime) {
+		t.Errorf("DataStore.GetStatus() source details didn't match. got: %+v, expected: %+v", rs, status)
+	}
+	if rs.Backend != status.Backend || rs.InstallAttempts != status.InstallAttempts {
+		t.Errorf("DataStore.GetStatus() install details didn't match. got: %s:%d, expected: %s:%d",
+			rs.Backend, rs.InstallAttempts, status.Backend, status.InstallAttempts)
+	}
+
+	encoded, err := json.Marshal(rs)
+	if err != nil {
+		t.Fatalf("Unable to encode the status: %s", err)
+	}
+	if !strings.Contains(string(encoded), `"checksum":"cafe"`) {
+		t.Errorf("expected the checksum to be hex encoded, got: %s", encoded)
+	}
+	if !strings.Contains(string(encoded), `"sourceSize":1048576`) ||
+		!strings.Contains(string(encoded), `"installAttempts":3`) ||
+		!strings.Contains(string(encoded), `"priority":400`) ||
+		!strings.Contains(string(encoded), `"ownedPriority":400`) {
+		t.Errorf("expected the size, attempts and priorities to be numbers, got: %s", encoded)
+	}
+	var decoded PolicyStatus
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Unable to decode the status: %s", err)
+	}
+	if !bytes.Equal(decoded.Checksum, status.Checksum) || decoded.SourceSize != status.SourceSize ||
+		decoded.Priority != status.Priority || decoded.OwnedPriority != status.OwnedPriority {
+		t.Errorf("the status didn't survive a JSON round trip. got: %+v, expected: %+v", decoded, status)
+	}
+
+	// Priorities used to be encoded as strings
+	legacy := `{"status":"Installed","msg":"","priority":"400","ownedPriority":"350","sourceSize":10}`
+	if err := json.Unmarshal([]byte(legacy), &decoded); err != nil {
+		t.Fatalf("Unable to decode a status with string priorities: %s", err)
+	}
+	if decoded.Priority != 400 || decoded.OwnedPriority != 350 || decoded.SourceSize != 10 {
+		t.Errorf("unexpected status decoded from string priorities: %+v", decoded)
+	}
+	if err := json.Unmarshal([]byte(`{"priority":"high"}`), &decoded); err == nil {
+		t.Errorf("expected an invalid priority to fail decoding")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	path, filecleanup := getNewStorePath(t)
+	defer filecleanup()
+	ds, dscleanup := getNewStore(path, t)
+	defer dscleanup()
+
+	if _, err := ds.History("web"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Fatalf("expected no history, got: %v", err)
+	}
+
+	retention := HistoryRetention{MaxEntries: 3}
+	now := time.Now()
+	actions := []HistoryAction{HistoryInstall, HistoryUpdate, HistoryFailure, HistoryUpdate, HistoryRemove}
+	for i, action := range actions {
+		entry := HistoryEntry{Time: now.Add(time.Duration(i) * time.Second), Action: action, Checksum: []byte{byte(i)}}
+		if err := ds.AppendHistory("web", entry, retention); err != nil {
+			t.Fatalf("DataStore.AppendHistory() error = %v", err)
+		}
+	}
+	if err := ds.Put(PolicyStatus{Policy: "web", Status: InstalledStatus}); err != nil {
+		t.Fatalf("DataStore.Put() error = %v", err)
+	}
+	if err := ds.Remove("web"); err != nil {
+		t.Fatalf("DataStore.Remove() error = %v", err)
+	}
+
+	history, err := ds.History("web")
+	if err != nil {
+		t.Fatalf("DataStore.History() error = %v", err)
+	}
+	if len(history) != retention.MaxEntries {
+		t.Fatalf("expected %d entries to be kept, got: %+v", retention.MaxEntries, history)
+	}
+	for i, entry := range history {
+		expected := actions[len(actions)-retention.MaxEntries+i]
+		if entry.Action != expected || !bytes.Equal(entry.Checksum, []byte{byte(len(actions) - retention.MaxEntries + i)}) {
+			t.Errorf("expected entry %d to be %s, got: %+v", i, expected, entry)
+		}
+	}
+
+	// Old entries are dropped, and so are the histories left empty
+	old := HistoryEntry{Time: now.Add(-2 * time.Hour), Action: HistoryInstall}
+	if err := ds.AppendHistory("old", old, HistoryRetention{}); err != nil {
+		t.Fatalf("DataStore.AppendHistory() error = %v", err)
+	}
+	if err := ds.PruneHistory(HistoryRetention{MaxAge: time.Hour}); err != nil {
+		t.Fatalf("DataStore.PruneHistory() error = %v", err)
+	}
+	if _, err := ds.History("old"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("expected the old history to be dropped, got: %v", err)
+	}
+	if history, err := ds.History("web"); err != nil || len(history) != retention.MaxEntries {
+		t.Errorf("expected the recent history to be kept, got: %+v, %v", history, err)
+	}
+}
+
+var errTestMigration = errors.New("test migration failure")
+
+func TestSchemaMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "selinuxd.db")
+
+	// A datastore written before the schema version was recorded
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucket([]byte("Policies-v1"))
+		if err != nil {
+			return err
+		}
+		b, err := root.CreateBucket([]byte("legacy"))
+		if err != nil {
+			return err
+		}
+		for k, v := range map[string]string{"status": "Installed", "msg": "", "checksum": "123"} {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	ds, err := New(path)
+	if err != nil {
+		t.Fatalf("unexpected error migrating the datastore: %s", err)
+	}
+	ps, err := ds.Get("legacy")
+	if err != nil {
+		t.Fatalf("DataStore.GetStatus() error = %v", err)
+	}
+	if ps.Status != InstalledStatus || !bytes.Equal(ps.Checksum, []byte("123")) {
+		t.Errorf("expected the legacy entry to be kept, got: %+v", ps)
+	}
+	if err := ds.AppendHistory("legacy", HistoryEntry{Time: time.Now(), Action: HistoryInstall},
+		HistoryRetention{}); err != nil {
+		t.Errorf("expected the migrated datastore to hold history, got: %v", err)
+	}
+	ds.Close()
+
+	version := func() int {
+		t.Helper()
+		db, err := bolt.Open(path, 0o600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		var v int
+		if err := db.View(func(tx *bolt.Tx) error {
+			v, err = schemaVersion(tx)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	latest := SchemaVersion()
+	if v := version(); v != latest {
+		t.Fatalf("expected the datastore to be at version %d, got: %d", latest, v)
+	}
+
+	t.Run("A failed migration should leave the datastore untouched", func(t *testing.T) {
+		failing := migration{"fail halfway", func(tx *bolt.Tx) error {
+			if _, err := tx.CreateBucket([]byte("Partial")); err != nil {
+				return err
+			}
+			return errTestMigration
+		}}
+		defer func(saved []migration) { migrations = saved }(migrations)
+		migrations = append(slices.Clone(migrations), failing)
+
+		if _, err := New(path); !errors.Is(err, errTestMigration) {
+			t.Fatalf("expected the migration to fail, got: %v", err)
+		}
+		if v := version(); v != latest {
+			t.Fatalf("expected the datastore to stay at version %d, got: %d", latest, v)
+		}
+		db, err := bolt.Open(path, 0o600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if err := db.View(func(tx *bolt.Tx) error {
+			if tx.Bucket([]byte("Partial")) != nil {
+				return errTestMigration
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("expected the failed migration to be rolled back")
+		}
+	})
+
+	t.Run("A newer schema should be refused", func(t *testing.T) {
+		db, err := bolt.Open(path, 0o600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(metadataBucket).Put(schemaVersionKey, []byte("99"))
+		})
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := New(path); !errors.Is(err, ErrUnsupportedSchema) {
+			t.Fatalf("expected the schema to be unsupported, got: %v", err)
+		}
+	})
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	t.Run("A missing datastore should be refused, and not created", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, uri := range []string{
+			filepath.Join(dir, "plain.db"),
+			"bolt://" + filepath.Join(dir, "policy.db"),
+			"json://" + filepath.Join(dir, "state.json"),
+		} {
+			if _, err := OpenReadOnly(uri); !errors.Is(err, ErrDataStoreNotFound) {
+				t.Errorf("expected %s not to be found, got: %v", uri, err)
+			}
+		}
+		if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+			t.Errorf("expected no datastore to be created, got: %v, %v", entries, err)
+		}
+	})
+
+	t.Run("An existing datastore should be read", func(t *testing.T) {
+		for _, uri := range []string{
+			"bolt://" + filepath.Join(t.TempDir(), "policy.db"),
+			"json://" + filepath.Join(t.TempDir(), "state.json"),
+		} {
+			ds, err := Open(uri)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if err := ds.Put(PolicyStatus{Policy: "first", Status: InstalledStatus}); err != nil {
+				t.Fatalf("unexpected error putting the status: %s", err)
+			}
+			ds.Close()
+
+			rods, err := OpenReadOnly(uri)
+			if err != nil {
+				t.Fatalf("unexpected error opening %s read-only: %s", uri, err)
+			}
+			if ps, err := rods.Get("first"); err != nil || ps.Status != InstalledStatus {
+				t.Errorf("unexpected status in %s: %+v, %v", uri, ps, err)
+			}
+			if rw, ok := rods.(DataStore); ok {
+				if err := rw.Put(PolicyStatus{Policy: "second"}); err == nil {
+					t.Errorf("expected %s not to be written to", uri)
+				}
+			}
+			rods.Close()
+		}
+	})
+
+	t.Run("An outdated datastore should be refused, and not migrated", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "policy.db")
+		db, err := bolt.Open(path, 0o600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucket(policiesBucket)
+			return err
+		})
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := OpenReadOnly("bolt://" + path); !errors.Is(err, ErrOutdatedSchema) {
+			t.Fatalf("expected the datastore to be outdated, got: %v", err)
+		}
+		db, err = bolt.Open(path, 0o600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if err := db.View(func(tx *bolt.Tx) error {
+			if v, err := schemaVersion(tx); err != nil || v != 1 {
+				t.Errorf("expected the datastore to stay at version 1, got: %d, %v", v, err)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestStaged(t *testing.T) {
+	ds := NewMemory()
+	defer ds.Close()
+	if err := ds.Put(PolicyStatus{Policy: "kept", Status: InstalledStatus}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Put(PolicyStatus{Policy: "removed", Status: InstalledStatus}); err != nil {
+		t.Fatal(err)
+	}
+
+	staged := NewStaged(ds)
+	if err := staged.Put(PolicyStatus{Policy: "added", Status: FailedStatus}); err != nil {
+		t.Fatal(err)
+	}
+	if err := staged.Remove("removed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := staged.AppendHistory("added", HistoryEntry{Action: HistoryFailure}, HistoryRetention{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pending changes are seen through the staged datastore only
+	if policies, err := staged.List(); err != nil || !slices.Equal(policies, []string{"added", "kept"}) {
+		t.Errorf("unexpected staged policies: %v, %v", policies, err)
+	}
+	if _, err := staged.Get("removed"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("expected the removed policy not to be found, got: %v", err)
+	}
+	if entries, err := staged.History("added"); err != nil || len(entries) != 1 {
+		t.Errorf("unexpected staged history: %+v, %v", entries, err)
+	}
+	if policies, err := ds.List(); err != nil || !slices.Equal(policies, []string{"kept", "removed"}) {
+		t.Errorf("expected the datastore to be untouched, got: %v, %v", policies, err)
+	}
+
+	if err := staged.Commit(); err != nil {
+		t.Fatalf("unexpected error committing: %s", err)
+	}
+	if policies, err := ds.List(); err != nil || !slices.Equal(policies, []string{"added", "kept"}) {
+		t.Errorf("expected the changes to be committed, got: %v, %v", policies, err)
+	}
+	if entries, err := ds.History("added"); err != nil || len(entries) != 1 {
+		t.Errorf("expected the history to be committed, got: %+v, %v", entries, err)
+	}
+}
+
+// backendConformance checks the behaviour that every datastore backend
+// must share. `reopen` opens the datastore again, or is nil if it doesn't
+// outlive being closed.
+func backendConformance(t *testing.T, open func() (DataStore, error), reopen func() (DataStore, error)) {
+	t.Helper()
+	ds, err := open()
+	if err != nil {
+		t.Fatalf("unexpected error opening the datastore: %s", err)
+	}
+
+	if _, err := ds.Get("missing"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("expected a missing policy not to be found, got: %v", err)
+	}
+	if err := ds.Remove("missing"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("expected removing a missing policy to fail, got: %v", err)
+	}
+	if _, err := ds.History("missing"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("expected a missing policy to have no history, got: %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	status := PolicyStatus{
+		Policy:         "first",
+		Status:         InstalledStatus,
+		Message:        "all good",
+		Checksum:       []byte{0xca, 0xfe},
+		OwnedChecksum:  []byte{0xca, 0xfe},
+		Conflicts:      []string{"/other/first.cil"},
+		LastUpdated:    &now,
+		FirstInstalled: &now,
+		SourceSize:     42,
+	}
+	if err := ds.Put(status); err != nil {
+		t.Fatalf("unexpected error putting the status: %s", err)
+	}
+	// The stored status mustn't change along with the caller's copy
+	status.Conflicts[0] = "changed"
+	if err := ds.Put(PolicyStatus{Policy: "second", Status: FailedStatus}); err != nil {
+		t.Fatalf("unexpected error putting the status: %s", err)
+	}
+
+	got, err := ds.GetReadOnly().Get("first")
+	if err != nil {
+		t.Fatalf("unexpected error getting the status: %s", err)
+	}
+	if !bytes.Equal(got.Checksum, []byte{0xca, 0xfe}) || got.Conflicts[0] != "/other/first.cil" ||
+		got.LastUpdated == nil || !got.LastUpdated.Equal(now) || got.SourceSize != 42 {
+		t.Errorf("unexpected status: %+v", got)
+	}
+	if policies, err := ds.List(); err != nil || !slices.Equal(policies, []string{"first", "second"}) {
+		t.Errorf("unexpected policies: %v, %v", policies, err)
+	}
+
+	retention := HistoryRetention{MaxEntries: 2}
+	for _, action := range []HistoryAction{HistoryInstall, HistoryUpdate, HistoryRemove} {
+		if err := ds.AppendHistory("first", HistoryEntry{Time: now, Action: action}, retention); err != nil {
+			t.Fatalf("unexpected error appending history: %s", err)
+		}
+	}
+	if err := ds.Remove("first"); err != nil {
+		t.Fatalf("unexpected error removing the policy: %s", err)
+	}
+	if _, err := ds.Get("first"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("expected the removed policy not to be found, got: %v", err)
+	}
+
+	checkHistory := func(ds DataStore) {
+		t.Helper()
+		entries, err := ds.History("first")
+		if err != nil {
+			t.Fatalf("expected the history to outlive the policy, got: %s", err)
+		}
+		if len(entries) != 2 || entries[0].Action != HistoryUpdate || entries[1].Action != HistoryRemove {
+			t.Errorf("unexpected history: %+v", entries)
+		}
+	}
+	checkHistory(ds)
+	if histories, err := ds.ListHistory(); err != nil || !slices.Equal(histories, []string{"first"}) {
+		t.Errorf("unexpected policies with a history: %v, %v", histories, err)
+	}
+	if err := ds.RemoveHistory("missing"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("expected removing a missing history to fail, got: %v", err)
+	}
+
+	if reopen != nil {
+		if err := ds.Close(); err != nil {
+			t.Fatalf("unexpected error closing the datastore: %s", err)
+		}
+		ds, err = reopen()
+		if err != nil {
+			t.Fatalf("unexpected error reopening the datastore: %s", err)
+		}
+		if policies, err := ds.List(); err != nil || !slices.Equal(policies, []string{"second"}) {
+			t.Errorf("expected the policies to persist, got: %v, %v", policies, err)
+		}
+		checkHistory(ds)
+	}
+
+	if err := ds.PruneHistory(HistoryRetention{MaxAge: time.Nanosecond}); err != nil {
+		t.Fatalf("unexpected error pruning the history: %s", err)
+	}
+	if _, err := ds.History("first"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("expected the expired history to be pruned, got: %v", err)
+	}
+
+	if err := ds.Close(); err != nil {
+		t.Fatalf("unexpected error closing the datastore: %s", err)
+	}
+	if _, err := ds.Get("second"); err == nil {
+		t.Errorf("expected a closed datastore to fail")
+	}
+}
+
+func TestBackends(t *testing.T) {
+	t.Run("bolt", func(t *testing.T) {
+		uri := "bolt://" + filepath.Join(t.TempDir(), "policy.db")
+		open := func() (DataStore, error) { return Open(uri) }
+		backendConformance(t, open, open)
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		backendConformance(t, func() (DataStore, error) { return Open("memory://") }, nil)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		uri := "json://" + filepath.Join(t.TempDir(), "state.json")
+		open := func() (DataStore, error) { return Open(uri) }
+		backendConformance(t, open, open)
+	})
+
+	t.Run("A plain path should open a bbolt datastore", func(t *testing.T) {
+		ds, err := Open(filepath.Join(t.TempDir(), "policy.db"))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		ds.Close()
+	})
+
+	t.Run("Invalid URIs should be refused", func(t *testing.T) {
+		if _, err := Open("etcd://localhost"); !errors.Is(err, ErrUnknownBackend) {
+			t.Errorf("expected an unknown backend error, got: %v", err)
+		}
+		for _, uri := range []string{"bolt://", "json://", "memory://somewhere"} {
+			if _, err := Open(uri); !errors.Is(err, ErrInvalidURI) {
+				t.Errorf("expected %s to be invalid, got: %v", uri, err)
+			}
+		}
+	})
+
+	t.Run("A JSON datastore from a newer version should be refused", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state.json")
+		if err := os.WriteFile(path, []byte(`{"schemaVersion": 99}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open("json://" + path); !errors.Is(err, ErrUnsupportedSchema) {
+			t.Errorf("expected the datastore to be refused, got: %v", err)
+		}
+	})
+
+	t.Run("A registered backend should be opened by its scheme", func(t *testing.T) {
+		Register("test-memory", func(string) (DataStore, error) { return NewMemory(), nil })
+		if !slices.Contains(Schemes(), "test-memory") {
+			t.Errorf("expected the backend to be listed, got: %v", Schemes())
+		}
+		ds, err := Open("test-memory://")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		ds.Close()
+	})
+}
+
+func TestDumps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.db")
+	src, err := New(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer src.Close()
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, policy := range []string{"first", "second"} {
+		if err := src.Put(PolicyStatus{Policy: policy, Status: InstalledStatus, Checksum: []byte(policy)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for policy, action := range map[string]HistoryAction{"first": HistoryInstall, "removed": HistoryRemove} {
+		if err := src.AppendHistory(policy, HistoryEntry{Time: now, Action: action}, HistoryRetention{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dump, err := Export(src.GetReadOnly())
+	if err != nil {
+		t.Fatalf("unexpected error exporting: %s", err)
+	}
+	if len(dump.Policies) != 2 || len(dump.History) != 2 || dump.SchemaVersion != DumpVersion {
+		t.Fatalf("unexpected dump: %+v", dump)
+	}
+
+	t.Run("Importing should replace the contents of the datastore", func(t *testing.T) {
+		dst := NewMemory()
+		defer dst.Close()
+		if err := dst.Put(PolicyStatus{Policy: "stale", Status: FailedStatus}); err != nil {
+			t.Fatal(err)
+		}
+		stale := HistoryEntry{Time: now, Action: HistoryFailure}
+		if err := dst.AppendHistory("first", stale, HistoryRetention{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := Import(dst, dump); err != nil {
+			t.Fatalf("unexpected error importing: %s", err)
+		}
+		imported, err := Export(dst.GetReadOnly())
+		if err != nil {
+			t.Fatalf("unexpected error exporting: %s", err)
+		}
+		want, err := json.Marshal(dump)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(imported)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(want, got) {
+			t.Errorf("unexpected contents after importing:\n%s\nexpected:\n%s", got, want)
+		}
+	})
+
+	t.Run("Importing should work with every builtin backend", func(t *testing.T) {
+		for _, uri := range []string{
+			"bolt://" + filepath.Join(t.TempDir(), "import.db"),
+			"json://" + filepath.Join(t.TempDir(), "import.json"),
+		} {
+			dst, err := Open(uri)
+			if err != nil {
+				t.Fatalf("unexpected error opening %s: %s", uri, err)
+			}
+			if err := dst.Put(PolicyStatus{Policy: "stale", Status: FailedStatus}); err != nil {
+				t.Fatal(err)
+			}
+			if err := Import(dst, dump); err != nil {
+				t.Fatalf("unexpected error importing into %s: %s", uri, err)
+			}
+			policies, err := dst.List()
+			if err != nil || !slices.Equal(policies, []string{"first", "second"}) {
+				t.Errorf("unexpected policies in %s: %v, %v", uri, policies, err)
+			}
+			histories, err := dst.ListHistory()
+			if err != nil || !slices.Equal(histories, []string{"first", "removed"}) {
+				t.Errorf("unexpected histories in %s: %v, %v", uri, histories, err)
+			}
+			dst.Close()
+		}
+	})
+
+	t.Run("Invalid dumps should leave the datastore untouched", func(t *testing.T) {
+		dst, err := Open("json://" + filepath.Join(t.TempDir(), "invalid.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dst.Close()
+		if err := dst.Put(PolicyStatus{Policy: "kept", Status: InstalledStatus}); err != nil {
+			t.Fatal(err)
+		}
+		invalid := &Dump{
+			SchemaVersion: DumpVersion,
+			Policies:      dump.Policies,
+			History:       map[string][]HistoryEntry{"first": {{Action: HistoryInstall}}},
+		}
+		if err := Import(dst, invalid); !errors.Is(err, ErrInvalidDump) {
+			t.Fatalf("expected the dump to be refused, got: %v", err)
+		}
+		policies, err := dst.List()
+		if err != nil || !slices.Equal(policies, []string{"kept"}) {
+			t.Errorf("expected the datastore to be left as it was, got: %v, %v", policies, err)
+		}
+	})
+
+	t.Run("Dumps from a newer version should be refused", func(t *testing.T) {
+		dst := NewMemory()
+		defer dst.Close()
+		if err := Import(dst, &Dump{SchemaVersion: DumpVersion + 1}); !errors.Is(err, ErrUnsupportedSchema) {
+			t.Errorf("expected the dump to be refused, got: %v", err)
+		}
+	})
+
+	t.Run("Unreadable entries should be left out of the dump", func(t *testing.T) {
+		err := src.(*bboltDataStore).db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(policiesBucket).Bucket([]byte("second")).Put([]byte("priority"), []byte("garbage"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		partial, err := Export(src.GetReadOnly())
+		if err == nil || !strings.Contains(err.Error(), "second") {
+			t.Errorf("expected the unreadable entry to be reported, got: %v", err)
+		}
+		if _, ok := partial.Policies["first"]; !ok || len(partial.Policies) != 1 {
+			t.Errorf("expected the readable entries to be exported, got: %+v", partial.Policies)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DumpVersion is the version of the layout of dumps, which is also the
+// layout of the JSON datastore
+const DumpVersion = 1
+
+var (
+	// ErrInvalidDump is returned when importing a dump that couldn't be
+	// restored as it is
+	ErrInvalidDump = errors.New("invalid dump")
+	// ErrImportUnsupported is returned when importing a dump into a
+	// datastore that doesn't implement Replacer
+	ErrImportUnsupported = errors.New("the datastore can't import dumps")
+)
+
+// Ensure that the builtin datastores can import dumps
+var (
+	_ Replacer = &bboltDataStore{}
+	_ Replacer = &memoryDataStore{}
+)
+
+// Replacer is implemented by the datastores that can replace their whole
+// contents at once, which Import needs
+type Replacer interface {
+	// Replace discards the contents of the datastore, and stores the ones
+	// in `dump` instead. Either all of them are stored, or none is.
+	Replace(dump *Dump) error
+}
+
+// Dump holds the whole contents of a datastore, as exported by Export
+type Dump struct {
+	SchemaVersion int                       `json:"schemaVersion"`
+	Policies      map[string]PolicyStatus   `json:"policies"`
+	History       map[string][]HistoryEntry `json:"history"`
+}
+
+// Export copies the contents of `ds`. Entries that can't be read, e.g.
+// because the datastore is corrupted, are left out of the dump, and
+// reported in the error. The dump holds every other entry even then.
+func Export(ds ReadOnlyDataStore) (*Dump, error) {
+	dump := &Dump{
+		SchemaVersion: DumpVersion,
+		Policies:      make(map[string]PolicyStatus),
+		History:       make(map[string][]HistoryEntry),
+	}
+
+	policies, err := ds.List()
+	if err != nil {
+		return nil, fmt.Errorf("exporting datastore: %w", err)
+	}
+	histories, err := ds.ListHistory()
+	if err != nil {
+		return nil, fmt.Errorf("exporting datastore: %w", err)
+	}
+
+	var errs []error
+	for _, policy := range policies {
+		ps, err := ds.Get(policy)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("exporting policy %s: %w", policy, err))
+			continue
+		}
+		dump.Policies[policy] = ps
+	}
+	for _, policy := range histories {
+		entries, err := ds.History(policy)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("exporting history of policy %s: %w", policy, err))
+			continue
+		}
+		dump.History[policy] = entries
+	}
+	return dump, errors.Join(errs...)
+}
+
+// Import replaces the contents of `ds` with the ones in `dump`. The dump
+// is checked before the datastore is touched, and the datastore is left
+// as it was if the import fails.
+func Import(ds DataStore, dump *Dump) error {
+	if err := dump.validate(); err != nil {
+		return fmt.Errorf("importing datastore: %w", err)
+	}
+	r, ok := ds.(Replacer)
+	if !ok {
+		return fmt.Errorf("importing datastore: %w", ErrImportUnsupported)
+	}
+	if err := r.Replace(dump); err != nil {
+		return fmt.Errorf("importing datastore: %w", err)
+	}
+	return nil
+}
+
+// validate checks that every entry in the dump can be stored
+func (d *Dump) validate() error {
+	if d.SchemaVersion > DumpVersion {
+		return fmt.Errorf("%w: the dump is at version %d, but only versions up to %d are supported. "+
+			"It was probably exported by a newer selinuxd", ErrUnsupportedSchema, d.SchemaVersion, DumpVersion)
+	}
+	for policy, ps := range d.Policies {
+		switch {
+		case policy == "":
+			return fmt.Errorf("%w: a policy has no name", ErrInvalidDump)
+		// The entries are keyed by policy, which the status doesn't
+		// need to repeat
+		case ps.Policy != "" && ps.Policy != policy:
+			return fmt.Errorf("%w: the entry of policy %s is for policy %s", ErrInvalidDump, policy, ps.Policy)
+		}
+		for _, c := range ps.Conflicts {
+			if c == "" || strings.Contains(c, conflictSeparator) {
+				return fmt.Errorf("%w: policy %s has an invalid conflict %q", ErrInvalidDump, policy, c)
+			}
+		}
+	}
+	for policy, entries := range d.History {
+		if policy == "" {
+			return fmt.Errorf("%w: a history has no policy name", ErrInvalidDump)
+		}
+		for i, entry := range entries {
+			if entry.Action == "" || entry.Time.IsZero() {
+				return fmt.Errorf("%w: entry %d in the history of policy %s has no action or time",
+					ErrInvalidDump, i, policy)
+			}
+		}
+	}
+	return nil
+}
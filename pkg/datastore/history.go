@@ -0,0 +1,67 @@
+package datastore
+
+import "time"
+
+const (
+	// DefaultHistoryMaxEntries is how many history entries are kept per
+	// policy by default
+	DefaultHistoryMaxEntries = 100
+	// DefaultHistoryMaxAge is how long history entries are kept by default
+	DefaultHistoryMaxAge = 30 * 24 * time.Hour
+)
+
+type HistoryAction string
+
+const (
+	// HistoryInstall is recorded when a policy gets installed
+	HistoryInstall HistoryAction = "install"
+	// HistoryUpdate is recorded when the module of an installed policy
+	// gets replaced
+	HistoryUpdate HistoryAction = "update"
+	// HistoryRemove is recorded when a policy goes away
+	HistoryRemove HistoryAction = "remove"
+	// HistoryFailure is recorded when a policy couldn't be installed or
+	// removed. The status tells why.
+	HistoryFailure HistoryAction = "failure"
+)
+
+// HistoryEntry records an action taken on a policy. Unlike PolicyStatus,
+// entries are never overwritten, and outlive the removal of the policy.
+type HistoryEntry struct {
+	Time     time.Time     `json:"time"`
+	Action   HistoryAction `json:"action"`
+	Status   StatusType    `json:"status,omitempty"`
+	Checksum Checksum      `json:"checksum,omitempty"`
+	Message  string        `json:"msg,omitempty"`
+}
+
+// HistoryRetention defines which history entries are kept. Zero values
+// don't limit the history.
+type HistoryRetention struct {
+	// MaxEntries is how many entries are kept per policy
+	MaxEntries int
+	// MaxAge is how long entries are kept for
+	MaxAge time.Duration
+}
+
+// DefaultHistoryRetention returns the retention used by the daemon
+func DefaultHistoryRetention() HistoryRetention {
+	return HistoryRetention{
+		MaxEntries: DefaultHistoryMaxEntries,
+		MaxAge:     DefaultHistoryMaxAge,
+	}
+}
+
+// expired returns how many of the `entries`, oldest first, aren't kept
+func (hr HistoryRetention) expired(entries []HistoryEntry, now time.Time) int {
+	n := 0
+	if hr.MaxEntries > 0 && len(entries) > hr.MaxEntries {
+		n = len(entries) - hr.MaxEntries
+	}
+	if hr.MaxAge > 0 {
+		for n < len(entries) && now.Sub(entries[n].Time) > hr.MaxAge {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,109 @@
+package datastore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// newJSONDS returns a DataStore that's kept in memory, and written to the
+// JSON file in `path` on every change, as a Dump. It suits read-only root filesystems,
+// where `path` can be in a tmpfs. Unlike the bbolt datastore, the file
+// isn't locked, so it mustn't be shared by several processes.
+func newJSONDS(path string) (DataStore, error) {
+	st, err := readJSONState(path)
+	if err != nil {
+		return nil, err
+	}
+	persist := func(st memoryState) error {
+		return writeJSONState(path, st)
+	}
+	// The file is written right away, so that an unusable path is
+	// reported when opening the datastore
+	if err := persist(st); err != nil {
+		return nil, fmt.Errorf("couldn't create datastore: %w", err)
+	}
+	return &memoryDataStore{state: st, persist: persist}, nil
+}
+
+// errReadOnly is returned when writing to a datastore that was opened
+// read-only
+var errReadOnly = errors.New("the datastore is read-only")
+
+// newJSONReadOnlyDS reads the existing datastore in `path`. It's never
+// written to.
+func newJSONReadOnlyDS(path string) (ReadOnlyDataStore, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("couldn't open datastore %s: %w", path, ErrDataStoreNotFound)
+	}
+	st, err := readJSONState(path)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryDataStore{state: st, persist: func(memoryState) error {
+		return fmt.Errorf("couldn't write datastore %s: %w", path, errReadOnly)
+	}}, nil
+}
+
+func readJSONState(path string) (memoryState, error) {
+	st := newMemoryState()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	} else if err != nil {
+		return st, fmt.Errorf("couldn't read datastore: %w", err)
+	}
+
+	var js Dump
+	if err := json.Unmarshal(data, &js); err != nil {
+		return st, fmt.Errorf("couldn't parse datastore %s: %w", path, err)
+	}
+	if js.SchemaVersion > DumpVersion {
+		return st, fmt.Errorf("%w: the datastore is at version %d, but only versions up to %d are supported. "+
+			"It was probably written by a newer selinuxd", ErrUnsupportedSchema, js.SchemaVersion, DumpVersion)
+	}
+	for policy, ps := range js.Policies {
+		ps.Policy = policy
+		st.policies[policy] = ps
+	}
+	for policy, entries := range js.History {
+		st.history[policy] = entries
+	}
+	return st, nil
+}
+
+// writeJSONState replaces the datastore file atomically, so that it's
+// never left half-written
+func writeJSONState(path string, st memoryState) error {
+	data, err := json.MarshalIndent(Dump{
+		SchemaVersion: DumpVersion,
+		Policies:      st.policies,
+		History:       st.history,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't encode datastore: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("couldn't write datastore: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't write datastore: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// memoryState is the contents of a datastore that's kept in memory
+type memoryState struct {
+	policies map[string]PolicyStatus
+	history  map[string][]HistoryEntry
+}
+
+func newMemoryState() memoryState {
+	return memoryState{
+		policies: make(map[string]PolicyStatus),
+		history:  make(map[string][]HistoryEntry),
+	}
+}
+
+func (st memoryState) clone() memoryState {
+	c := newMemoryState()
+	for policy, ps := range st.policies {
+		c.policies[policy] = clonePolicyStatus(ps)
+	}
+	for policy, entries := range st.history {
+		c.history[policy] = cloneHistory(entries)
+	}
+	return c
+}
+
+type memoryDataStore struct {
+	mu     sync.RWMutex
+	state  memoryState
+	closed bool
+	// persist is called with the state that results from a change,
+	// before it replaces the current one. Changes that can't be
+	// persisted are discarded.
+	persist func(memoryState) error
+}
+
+// NewMemory returns a DataStore that's kept in memory, and is lost when
+// it's closed. It's meant for tests, and for embedders that don't need
+// the state to outlive the process.
+func NewMemory() DataStore {
+	return &memoryDataStore{state: newMemoryState()}
+}
+
+func (ds *memoryDataStore) Close() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.closed {
+		return ErrDataStoreNotInitialized
+	}
+	ds.closed = true
+	return nil
+}
+
+func (ds *memoryDataStore) GetReadOnly() ReadOnlyDataStore {
+	return ds
+}
+
+// view runs `fn` with the current state, which it must not modify
+func (ds *memoryDataStore) view(fn func(st memoryState) error) error {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	if ds.closed {
+		return ErrDataStoreNotInitialized
+	}
+	return fn(ds.state)
+}
+
+// update runs `fn` to change the state. If the datastore is persisted,
+// `fn` changes a copy of the state, which only replaces the current one
+// once it's persisted.
+func (ds *memoryDataStore) update(fn func(st *memoryState) error) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.closed {
+		return ErrDataStoreNotInitialized
+	}
+	next := ds.state
+	if ds.persist != nil {
+		next = ds.state.clone()
+	}
+	if err := fn(&next); err != nil {
+		return err
+	}
+	if ds.persist != nil {
+		if err := ds.persist(next); err != nil {
+			return err
+		}
+	}
+	ds.state = next
+	return nil
+}
+
+func (ds *memoryDataStore) Put(status PolicyStatus) error {
+	err := ds.update(func(st *memoryState) error {
+		st.policies[status.Policy] = clonePolicyStatus(status)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't put policy status: %w", err)
+	}
+	return nil
+}
+
+func (ds *memoryDataStore) Get(policy string) (PolicyStatus, error) {
+	var ps PolicyStatus
+	err := ds.view(func(st memoryState) error {
+		stored, ok := st.policies[policy]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrPolicyNotFound, policy)
+		}
+		ps = clonePolicyStatus(stored)
+		return nil
+	})
+	if err != nil {
+		return PolicyStatus{}, fmt.Errorf("couldn't get policy status: %w", err)
+	}
+	return ps, nil
+}
+
+func (ds *memoryDataStore) List() ([]string, error) {
+	var output []string
+	err := ds.view(func(st memoryState) error {
+		for policy := range st.policies {
+			output = append(output, policy)
+		}
+		return nil
+	})
+	if err != nil {
+		return output, fmt.Errorf("couldn't list policies: %w", err)
+	}
+	slices.Sort(output)
+	return output, nil
+}
+
+func (ds *memoryDataStore) Remove(policy string) error {
+	err := ds.update(func(st *memoryState) error {
+		if _, ok := st.policies[policy]; !ok {
+			return fmt.Errorf("%w: %s", ErrPolicyNotFound, policy)
+		}
+		delete(st.policies, policy)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't remove policy from db: %w", err)
+	}
+	return nil
+}
+
+func (ds *memoryDataStore) History(policy string) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	err := ds.view(func(st memoryState) error {
+		stored, ok := st.history[policy]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrPolicyNotFound, policy)
+		}
+		entries = cloneHistory(stored)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get policy history: %w", err)
+	}
+	return entries, nil
+}
+
+func (ds *memoryDataStore) AppendHistory(policy string, entry HistoryEntry, retention HistoryRetention) error {
+	entry.Checksum = bytes.Clone(entry.Checksum)
+	err := ds.update(func(st *memoryState) error {
+		entries := append(st.history[policy], entry)
+		st.history[policy] = entries[retention.expired(entries, time.Now()):]
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't append policy history: %w", err)
+	}
+	return nil
+}
+
+func (ds *memoryDataStore) PruneHistory(retention HistoryRetention) error {
+	err := ds.update(func(st *memoryState) error {
+		now := time.Now()
+		for policy, entries := range st.history {
+			entries = entries[retention.expired(entries, now):]
+			if len(entries) == 0 {
+				delete(st.history, policy)
+				continue
+			}
+			st.history[policy] = entries
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't prune policy history: %w", err)
+	}
+	return nil
+}
+
+func (ds *memoryDataStore) ListHistory() ([]string, error) {
+	var output []string
+	err := ds.view(func(st memoryState) error {
+		for policy := range st.history {
+			output = append(output, policy)
+		}
+		return nil
+	})
+	if err != nil {
+		return output, fmt.Errorf("couldn't list policy histories: %w", err)
+	}
+	slices.Sort(output)
+	return output, nil
+}
+
+func (ds *memoryDataStore) RemoveHistory(policy string) error {
+	err := ds.update(func(st *memoryState) error {
+		if _, ok := st.history[policy]; !ok {
+			return fmt.Errorf("%w: %s", ErrPolicyNotFound, policy)
+		}
+		delete(st.history, policy)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't remove policy history: %w", err)
+	}
+	return nil
+}
+
+func (ds *memoryDataStore) Replace(dump *Dump) error {
+	err := ds.update(func(st *memoryState) error {
+		*st = newMemoryState()
+		for policy, ps := range dump.Policies {
+			ps.Policy = policy
+			st.policies[policy] = clonePolicyStatus(ps)
+		}
+		for policy, entries := range dump.History {
+			if len(entries) > 0 {
+				st.history[policy] = cloneHistory(entries)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't replace datastore contents: %w", err)
+	}
+	return nil
+}
+
+// clonePolicyStatus returns a copy of `ps` that shares no memory with it,
+// as a stored status must not change along with the caller's copy
+func clonePolicyStatus(ps PolicyStatus) PolicyStatus {
+	ps.Checksum = bytes.Clone(ps.Checksum)
+	ps.OwnedChecksum = bytes.Clone(ps.OwnedChecksum)
+	ps.Conflicts = slices.Clone(ps.Conflicts)
+	ps.NextRetry = cloneTime(ps.NextRetry)
+	ps.FirstInstalled = cloneTime(ps.FirstInstalled)
+	ps.LastUpdated = cloneTime(ps.LastUpdated)
+	ps.SourceModTime = cloneTime(ps.SourceModTime)
+	return ps
+}
+
+func cloneHistory(entries []HistoryEntry) []HistoryEntry {
+	c := slices.Clone(entries)
+	for i := range c {
+		c[i].Checksum = bytes.Clone(c[i].Checksum)
+	}
+	return c
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
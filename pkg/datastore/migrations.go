@@ -0,0 +1,131 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	policiesBucket = []byte("Policies-v1")
+	historyBucket  = []byte("History-v1")
+	// metadataBucket holds information about the datastore itself
+	metadataBucket   = []byte("Metadata")
+	schemaVersionKey = []byte("schemaVersion")
+)
+
+var (
+	ErrUnsupportedSchema = errors.New("unsupported datastore schema")
+	// ErrOutdatedSchema is returned when a datastore that needs to be
+	// migrated is opened read-only
+	ErrOutdatedSchema = errors.New("outdated datastore schema")
+)
+
+// migration upgrades the datastore layout from one version to the next
+type migration struct {
+	description string
+	migrate     func(tx *bolt.Tx) error
+}
+
+// migrations holds the migration from version `i` to version `i+1` at
+// index `i`. Version 0 is an empty datastore. New migrations go at the end.
+var migrations = []migration{
+	{
+		description: "create the policies bucket",
+		migrate: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(policiesBucket)
+			//nolint:wrapcheck // this is wrapped by the caller
+			return err
+		},
+	},
+	{
+		description: "create the history bucket",
+		migrate: func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(historyBucket)
+			//nolint:wrapcheck // this is wrapped by the caller
+			return err
+		},
+	},
+}
+
+// SchemaVersion returns the version of the layout that the bbolt datastore
+// is written in. It's stored in the metadata bucket, and datastores with
+// an older layout are migrated when they're opened.
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// schemaVersion returns the version of the layout that the datastore is
+// written in. Datastores written before the version was recorded are
+// version 1 if they hold the policies bucket, and empty otherwise.
+func schemaVersion(tx *bolt.Tx) (int, error) {
+	meta := tx.Bucket(metadataBucket)
+	if meta == nil {
+		if tx.Bucket(policiesBucket) != nil {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	value := meta.Get(schemaVersionKey)
+	version, err := strconv.Atoi(string(value))
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("%w: invalid schema version %q", ErrUnsupportedSchema, value)
+	}
+	return version, nil
+}
+
+// migrate upgrades the layout of the datastore to SchemaVersion. As it
+// runs in a single transaction, the datastore is left untouched if any
+// of the migrations fails.
+func migrate(tx *bolt.Tx) error {
+	version, err := schemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	latest := SchemaVersion()
+	if err := checkNotNewer(version); err != nil {
+		return err
+	}
+	for ; version < latest; version++ {
+		m := migrations[version]
+		if err := m.migrate(tx); err != nil {
+			return fmt.Errorf("migrating datastore schema to version %d (%s): %w", version+1, m.description, err)
+		}
+	}
+
+	meta, err := tx.CreateBucketIfNotExists(metadataBucket)
+	if err != nil {
+		return fmt.Errorf("creating metadata bucket: %w", err)
+	}
+	if err := meta.Put(schemaVersionKey, []byte(strconv.Itoa(latest))); err != nil {
+		return fmt.Errorf("persisting schema version: %w", err)
+	}
+	return nil
+}
+
+// checkSchema checks that the datastore is written in the current layout,
+// for the datastores that are opened read-only, and thus can't be migrated
+func checkSchema(tx *bolt.Tx) error {
+	version, err := schemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if err := checkNotNewer(version); err != nil {
+		return err
+	}
+	if version < SchemaVersion() {
+		return fmt.Errorf("%w: the datastore is at version %d, and needs to be migrated to version %d "+
+			"by opening it read-write, e.g. by starting selinuxd", ErrOutdatedSchema, version, SchemaVersion())
+	}
+	return nil
+}
+
+func checkNotNewer(version int) error {
+	if latest := SchemaVersion(); version > latest {
+		return fmt.Errorf("%w: the datastore is at version %d, but only versions up to %d are supported. "+
+			"It was probably written by a newer selinuxd", ErrUnsupportedSchema, version, latest)
+	}
+	return nil
+}
@@ -1,10 +1,109 @@
 package datastore
 
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
 // PolicyStatus defines the status of a specific
 // policy in the datastore.
 type PolicyStatus struct {
-	Policy   string     `json:"-"`
-	Status   StatusType `json:"status"`
-	Message  string     `json:"msg"`
-	Checksum []byte     `json:"-"`
+	Policy  string     `json:"-"`
+	Status  StatusType `json:"status"`
+	Message string     `json:"msg"`
+	// Checksum is the checksum of the file that the policy was last
+	// installed from, or attempted to
+	Checksum Checksum `json:"checksum,omitempty"`
+	// Attempt is the number of consecutive failed attempts to install the policy
+	Attempt int `json:"attempt,omitempty"`
+	// NextRetry is when the install will be retried, if it will be
+	NextRetry *time.Time `json:"nextRetry,omitempty"`
+	// SourcePath is the file that provides the policy
+	SourcePath string `json:"sourcePath,omitempty"`
+	// ModuleDir is the module directory that SourcePath is in
+	ModuleDir string `json:"moduleDir,omitempty"`
+	// Priority is the priority that the policy is installed at
+	Priority uint16 `json:"priority,omitempty"`
+	// Conflicts are other files with the same policy name, which are
+	// refused while SourcePath provides the policy.
+	Conflicts []string `json:"conflicts,omitempty"`
+	// OwnedPriority is the priority of the module that selinuxd installed
+	// for the policy, if any. selinuxd only overwrites and removes the
+	// modules that it owns.
+	OwnedPriority uint16 `json:"ownedPriority,omitempty"`
+	// OwnedChecksum is the checksum of the file that the owned module
+	// was installed from
+	OwnedChecksum Checksum `json:"ownedChecksum,omitempty"`
+	// FirstInstalled is when selinuxd first installed or adopted the policy
+	FirstInstalled *time.Time `json:"firstInstalled,omitempty"`
+	// LastUpdated is when the policy was last installed, or its install
+	// last attempted
+	LastUpdated *time.Time `json:"lastUpdated,omitempty"`
+	// SourceSize, SourceModTime and SourceOwner describe SourcePath as it
+	// was when the policy was last updated. The owner is in the
+	// `uid:gid` format.
+	SourceSize    int64      `json:"sourceSize,omitempty"`
+	SourceModTime *time.Time `json:"sourceModTime,omitempty"`
+	SourceOwner   string     `json:"sourceOwner,omitempty"`
+	// Backend is the module handler back end that installed the policy
+	Backend string `json:"backend,omitempty"`
+	// InstallAttempts is the number of times that the current contents of
+	// SourcePath were installed, or attempted to, including retries
+	InstallAttempts int `json:"installAttempts,omitempty"`
+}
+
+// UnmarshalJSON decodes the status. The priorities used to be encoded as
+// strings, which are still accepted so existing datastores and dumps can
+// be read.
+func (ps *PolicyStatus) UnmarshalJSON(data []byte) error {
+	type plainStatus PolicyStatus
+	aux := struct {
+		*plainStatus
+		Priority      legacyPriority `json:"priority,omitempty"`
+		OwnedPriority legacyPriority `json:"ownedPriority,omitempty"`
+	}{plainStatus: (*plainStatus)(ps)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err //nolint:wrapcheck // the caller adds context
+	}
+	ps.Priority = uint16(aux.Priority)
+	ps.OwnedPriority = uint16(aux.OwnedPriority)
+	return nil
+}
+
+// legacyPriority is a priority encoded as a number, or as a string
+type legacyPriority uint16
+
+func (p *legacyPriority) UnmarshalJSON(data []byte) error {
+	if unquoted, err := strconv.Unquote(string(data)); err == nil {
+		data = []byte(unquoted)
+	}
+	value, err := strconv.ParseUint(string(data), 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid priority %s: %w", data, err)
+	}
+	*p = legacyPriority(value)
+	return nil
+}
+
+// Checksum is the checksum of a policy file. It's hex encoded in JSON.
+type Checksum []byte
+
+func (c Checksum) String() string {
+	return hex.EncodeToString(c)
+}
+
+func (c Checksum) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Checksum) UnmarshalText(text []byte) error {
+	decoded, err := hex.DecodeString(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid checksum: %w", err)
+	}
+	*c = decoded
+	return nil
 }
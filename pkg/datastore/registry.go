@@ -0,0 +1,132 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultURI is the datastore that the daemon uses when none is configured
+const DefaultURI = "bolt://" + DefaultDataStorePath
+
+var (
+	ErrUnknownBackend = errors.New("unknown datastore backend")
+	ErrInvalidURI     = errors.New("invalid datastore URI")
+)
+
+// Opener opens a datastore. `location` is the part of its URI that
+// follows `scheme://`, e.g. the path of the file that backs it.
+type Opener func(location string) (DataStore, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]Opener{
+		"bolt":   openBbolt,
+		"memory": openMemory,
+		"json":   openJSON,
+	}
+	// readOnlyBackends open the datastores of the backends that would
+	// otherwise create or migrate them
+	readOnlyBackends = map[string]func(location string) (ReadOnlyDataStore, error){
+		"bolt": openBboltReadOnly,
+		"json": openJSONReadOnly,
+	}
+)
+
+// Register makes a datastore backend available under `scheme`, replacing
+// the one that was registered with it before, if any. OpenReadOnly opens
+// its datastores with `open` too.
+func Register(scheme string, open Opener) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[scheme] = open
+	delete(readOnlyBackends, scheme)
+}
+
+// Schemes returns the schemes of the registered backends
+func Schemes() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	schemes := make([]string, 0, len(backends))
+	for scheme := range backends {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open opens the datastore that `uri` points to, e.g.
+// `bolt:///var/run/selinuxd.db`, `memory://` or
+// `json:///run/selinuxd/state.json`. URIs without a scheme are taken as
+// the path of a bbolt datastore.
+func Open(uri string) (DataStore, error) {
+	scheme, location, found := strings.Cut(uri, "://")
+	if !found {
+		return New(uri)
+	}
+
+	backendsMu.RLock()
+	open, ok := backends[scheme]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s. Available backends: %s", ErrUnknownBackend, scheme,
+			strings.Join(Schemes(), ", "))
+	}
+	return open(location)
+}
+
+// OpenReadOnly opens the existing datastore that `uri` points to for
+// reading, as Open does. Unlike Open, the bolt and json datastores aren't
+// created if they don't exist, and aren't written to, so the bolt ones
+// with an older layout are refused rather than migrated.
+func OpenReadOnly(uri string) (ReadOnlyDataStore, error) {
+	scheme, location, found := strings.Cut(uri, "://")
+	if !found {
+		return newBboltReadOnlyDS(uri)
+	}
+
+	backendsMu.RLock()
+	open, ok := readOnlyBackends[scheme]
+	backendsMu.RUnlock()
+	if !ok {
+		return Open(uri)
+	}
+	return open(location)
+}
+
+func openBbolt(location string) (DataStore, error) {
+	if location == "" {
+		return nil, fmt.Errorf("%w: the bolt backend needs a path", ErrInvalidURI)
+	}
+	return newBboltDS(location)
+}
+
+func openBboltReadOnly(location string) (ReadOnlyDataStore, error) {
+	if location == "" {
+		return nil, fmt.Errorf("%w: the bolt backend needs a path", ErrInvalidURI)
+	}
+	return newBboltReadOnlyDS(location)
+}
+
+func openMemory(location string) (DataStore, error) {
+	if location != "" {
+		return nil, fmt.Errorf("%w: the memory backend takes no location", ErrInvalidURI)
+	}
+	return NewMemory(), nil
+}
+
+func openJSON(location string) (DataStore, error) {
+	if location == "" {
+		return nil, fmt.Errorf("%w: the json backend needs a path", ErrInvalidURI)
+	}
+	return newJSONDS(location)
+}
+
+func openJSONReadOnly(location string) (ReadOnlyDataStore, error) {
+	if location == "" {
+		return nil, fmt.Errorf("%w: the json backend needs a path", ErrInvalidURI)
+	}
+	return newJSONReadOnlyDS(location)
+}
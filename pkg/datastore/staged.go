@@ -0,0 +1,154 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Staged holds the changes to a datastore until they're committed, e.g.
+// along with the semodule transaction they describe. Reads see the pending
+// changes. Nothing is written if it's dropped without committing.
+type Staged struct {
+	ds DataStore
+	// policies holds the pending status of the policies that changed. A
+	// nil status means that the policy was removed.
+	policies map[string]*PolicyStatus
+	// history holds the pending history entries of each policy
+	history map[string][]HistoryEntry
+	// changes replays the pending changes on the datastore, in order
+	changes []func(ds DataStore) error
+}
+
+// NewStaged returns a datastore that holds its changes until they're
+// committed to `ds`
+func NewStaged(ds DataStore) *Staged {
+	return &Staged{
+		ds:       ds,
+		policies: make(map[string]*PolicyStatus),
+		history:  make(map[string][]HistoryEntry),
+	}
+}
+
+// Commit writes the pending changes to the datastore. They're all
+// attempted, even if some fail.
+func (s *Staged) Commit() error {
+	var errs []error
+	for _, change := range s.changes {
+		if err := change(s.ds); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.policies = make(map[string]*PolicyStatus)
+	s.history = make(map[string][]HistoryEntry)
+	s.changes = nil
+	return errors.Join(errs...)
+}
+
+// Close doesn't close the underlying datastore, which belongs to the caller
+func (s *Staged) Close() error {
+	return nil
+}
+
+func (s *Staged) GetReadOnly() ReadOnlyDataStore {
+	return s
+}
+
+func (s *Staged) Get(policy string) (PolicyStatus, error) {
+	ps, ok := s.policies[policy]
+	if !ok {
+		//nolint:wrapcheck // this is a pass-through
+		return s.ds.Get(policy)
+	}
+	if ps == nil {
+		return PolicyStatus{}, fmt.Errorf("%w: %s", ErrPolicyNotFound, policy)
+	}
+	return clonePolicyStatus(*ps), nil
+}
+
+func (s *Staged) List() ([]string, error) {
+	policies, err := s.ds.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing policies: %w", err)
+	}
+	policies = slices.DeleteFunc(policies, func(policy string) bool {
+		_, changed := s.policies[policy]
+		return changed
+	})
+	for policy, ps := range s.policies {
+		if ps != nil {
+			policies = append(policies, policy)
+		}
+	}
+	slices.Sort(policies)
+	return policies, nil
+}
+
+func (s *Staged) Put(status PolicyStatus) error {
+	ps := clonePolicyStatus(status)
+	s.policies[status.Policy] = &ps
+	s.changes = append(s.changes, func(ds DataStore) error {
+		return ds.Put(ps)
+	})
+	return nil
+}
+
+func (s *Staged) Remove(policy string) error {
+	if _, err := s.Get(policy); err != nil {
+		return err
+	}
+	s.policies[policy] = nil
+	s.changes = append(s.changes, func(ds DataStore) error {
+		return ds.Remove(policy)
+	})
+	return nil
+}
+
+// History returns the committed history of the policy, followed by its
+// pending entries. The retention is applied once they're committed.
+func (s *Staged) History(policy string) ([]HistoryEntry, error) {
+	entries, err := s.ds.History(policy)
+	if err != nil && (!errors.Is(err, ErrPolicyNotFound) || len(s.history[policy]) == 0) {
+		return nil, err //nolint:wrapcheck // this is a pass-through
+	}
+	return append(entries, cloneHistory(s.history[policy])...), nil
+}
+
+func (s *Staged) ListHistory() ([]string, error) {
+	policies, err := s.ds.ListHistory()
+	if err != nil {
+		return nil, fmt.Errorf("listing policies with a history: %w", err)
+	}
+	for policy := range s.history {
+		if !slices.Contains(policies, policy) {
+			policies = append(policies, policy)
+		}
+	}
+	slices.Sort(policies)
+	return policies, nil
+}
+
+func (s *Staged) AppendHistory(policy string, entry HistoryEntry, retention HistoryRetention) error {
+	entry = cloneHistory([]HistoryEntry{entry})[0]
+	s.history[policy] = append(s.history[policy], entry)
+	s.changes = append(s.changes, func(ds DataStore) error {
+		return ds.AppendHistory(policy, entry, retention)
+	})
+	return nil
+}
+
+// PruneHistory only drops the entries once it's committed
+func (s *Staged) PruneHistory(retention HistoryRetention) error {
+	s.changes = append(s.changes, func(ds DataStore) error {
+		return ds.PruneHistory(retention)
+	})
+	return nil
+}
+
+// RemoveHistory only drops the history once it's committed
+func (s *Staged) RemoveHistory(policy string) error {
+	s.changes = append(s.changes, func(ds DataStore) error {
+		return ds.RemoveHistory(policy)
+	})
+	return nil
+}
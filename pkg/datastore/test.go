@@ -2,7 +2,7 @@ package datastore
 
 import "sync/atomic"
 
-// TestCountedDS a wrapper over the bbolt datastore
+// TestCountedDS a wrapper over a datastore
 // that contains counters which are meant to aid
 // in testing
 type TestCountedDS struct {
@@ -11,8 +11,10 @@ type TestCountedDS struct {
 	putCounter int32
 }
 
-func NewTestCountedDS(path string) (*TestCountedDS, error) {
-	ds, err := newBboltDS(path)
+// NewTestCountedDS wraps the datastore that `uri` points to. As with Open,
+// a plain path is taken as a bbolt datastore.
+func NewTestCountedDS(uri string) (*TestCountedDS, error) {
+	ds, err := Open(uri)
 	if err != nil {
 		return nil, err
 	}
@@ -59,6 +61,31 @@ func (tcds *TestCountedDS) Remove(policy string) error {
 	return tcds.ds.Remove(policy)
 }
 
+func (tcds *TestCountedDS) History(policy string) ([]HistoryEntry, error) {
+	//nolint:wrapcheck // let's not complicate the test code
+	return tcds.ds.History(policy)
+}
+
+func (tcds *TestCountedDS) AppendHistory(policy string, entry HistoryEntry, retention HistoryRetention) error {
+	//nolint:wrapcheck // let's not complicate the test code
+	return tcds.ds.AppendHistory(policy, entry, retention)
+}
+
+func (tcds *TestCountedDS) PruneHistory(retention HistoryRetention) error {
+	//nolint:wrapcheck // let's not complicate the test code
+	return tcds.ds.PruneHistory(retention)
+}
+
+func (tcds *TestCountedDS) ListHistory() ([]string, error) {
+	//nolint:wrapcheck // let's not complicate the test code
+	return tcds.ds.ListHistory()
+}
+
+func (tcds *TestCountedDS) RemoveHistory(policy string) error {
+	//nolint:wrapcheck // let's not complicate the test code
+	return tcds.ds.RemoveHistory(policy)
+}
+
 func (tcds *TestCountedDS) GetReadOnly() ReadOnlyDataStore {
 	return tcds.ds.GetReadOnly()
 }
@@ -0,0 +1,244 @@
+// Package inotify watches directories for changes. Unlike fsnotify, it
+// reports when a file that was open for writing is closed, so that only
+// completed writes are acted upon.
+package inotify
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrEventOverflow is reported when the kernel dropped events, as they
+// weren't read fast enough
+var ErrEventOverflow = errors.New("inotify event queue overflowed")
+
+// Op describes what happened to a file
+type Op uint32
+
+const (
+	// Create is reported when a file or directory is created
+	Create Op = 1 << iota
+	// Write is reported on every write, so the file might be incomplete
+	Write
+	// CloseWrite is reported when a file that was open for writing is
+	// closed
+	CloseWrite
+	// Remove is reported when a file or directory is deleted
+	Remove
+	// Rename is reported when a file or directory is moved away
+	Rename
+	// MovedTo is reported when a file or directory is moved in, e.g.
+	// renamed over an existing file
+	MovedTo
+	// Chmod is reported when the metadata of a file changes
+	Chmod
+)
+
+var opNames = []struct {
+	op   Op
+	name string
+}{
+	{Create, "CREATE"},
+	{Write, "WRITE"},
+	{CloseWrite, "CLOSE_WRITE"},
+	{Remove, "REMOVE"},
+	{Rename, "RENAME"},
+	{MovedTo, "MOVED_TO"},
+	{Chmod, "CHMOD"},
+}
+
+// Has tells whether the operation includes any of `ops`
+func (op Op) Has(ops Op) bool {
+	return op&ops != 0
+}
+
+func (op Op) String() string {
+	names := make([]string, 0, len(opNames))
+	for _, n := range opNames {
+		if op.Has(n.op) {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Event is a change to a file in a watched directory, or to the watched
+// directory itself
+type Event struct {
+	Name string
+	Op   Op
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%s: %s", e.Op, e.Name)
+}
+
+const watchMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_DELETE | unix.IN_DELETE_SELF |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_MOVE_SELF | unix.IN_ATTRIB
+
+// Watcher reports the changes in the directories added to it. Events and
+// Errors are closed once the watcher is closed.
+type Watcher struct {
+	Events chan Event
+	Errors chan error
+
+	fd   int
+	file *os.File
+	mu   sync.Mutex
+	// paths maps the watch descriptors to the directories they watch
+	paths map[int32]string
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewWatcher returns a watcher that doesn't watch anything yet
+func NewWatcher() (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("initializing inotify: %w", err)
+	}
+	w := &Watcher{
+		Events: make(chan Event),
+		Errors: make(chan error),
+		fd:     fd,
+		// The file is non-blocking, so reading it goes through the
+		// runtime poller and is interrupted by closing it. Its Fd method
+		// makes it blocking, hence `fd` is kept aside.
+		file:  os.NewFile(uintptr(fd), "inotify"),
+		paths: make(map[int32]string),
+		done:  make(chan struct{}),
+	}
+	go w.readEvents()
+	return w, nil
+}
+
+// Add watches the directory in `path`. Sub-directories aren't watched.
+func (w *Watcher) Add(path string) error {
+	path = filepath.Clean(path)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wd, err := unix.InotifyAddWatch(w.fd, path, watchMask)
+	if err != nil {
+		return fmt.Errorf("watching %s: %w", path, err)
+	}
+	w.paths[int32(wd)] = path //nolint:gosec // watch descriptors are int32 in the kernel
+	return nil
+}
+
+// Close stops watching, and closes Events and Errors
+func (w *Watcher) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		err = w.file.Close()
+	})
+	return err //nolint:wrapcheck // closing the file can't fail with anything actionable
+}
+
+func (w *Watcher) readEvents() {
+	defer close(w.Events)
+	defer close(w.Errors)
+
+	buf := make([]byte, 4096*unix.SizeofInotifyEvent)
+	for {
+		n, err := w.file.Read(buf)
+		if errors.Is(err, os.ErrClosed) {
+			return
+		}
+		if err != nil {
+			if !w.sendError(fmt.Errorf("reading inotify events: %w", err)) {
+				return
+			}
+			continue
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[offset:])) //nolint:gosec // it's an int32 in the kernel
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+nameLen]), "\x00")
+			offset = nameStart + nameLen
+
+			if mask&unix.IN_Q_OVERFLOW != 0 {
+				if !w.sendError(ErrEventOverflow) {
+					return
+				}
+				continue
+			}
+			path, ok := w.pathOf(wd, mask)
+			if !ok {
+				continue
+			}
+			if name != "" {
+				path = filepath.Join(path, name)
+			}
+			if op := toOp(mask); op != 0 && !w.sendEvent(Event{Name: path, Op: op}) {
+				return
+			}
+		}
+	}
+}
+
+// pathOf returns the directory watched by `wd`, and forgets about it
+// once the kernel stopped watching it
+func (w *Watcher) pathOf(wd int32, mask uint32) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	path, ok := w.paths[wd]
+	if mask&unix.IN_IGNORED != 0 {
+		delete(w.paths, wd)
+		return "", false
+	}
+	return path, ok
+}
+
+func toOp(mask uint32) Op {
+	var op Op
+	if mask&unix.IN_CREATE != 0 {
+		op |= Create
+	}
+	if mask&unix.IN_MODIFY != 0 {
+		op |= Write
+	}
+	if mask&unix.IN_CLOSE_WRITE != 0 {
+		op |= CloseWrite
+	}
+	if mask&(unix.IN_DELETE|unix.IN_DELETE_SELF) != 0 {
+		op |= Remove
+	}
+	if mask&(unix.IN_MOVED_FROM|unix.IN_MOVE_SELF) != 0 {
+		op |= Rename
+	}
+	if mask&unix.IN_MOVED_TO != 0 {
+		op |= MovedTo
+	}
+	if mask&unix.IN_ATTRIB != 0 {
+		op |= Chmod
+	}
+	return op
+}
+
+func (w *Watcher) sendEvent(e Event) bool {
+	select {
+	case w.Events <- e:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+func (w *Watcher) sendError(err error) bool {
+	select {
+	case w.Errors <- err:
+		return true
+	case <-w.done:
+		return false
+	}
+}
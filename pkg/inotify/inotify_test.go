@@ -0,0 +1,91 @@
+package inotify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const eventTimeout = 5 * time.Second
+
+// nextEvent returns the next event for `name`, skipping the others
+func nextEvent(t *testing.T, w *Watcher, name string) Event {
+	t.Helper()
+	timeout := time.After(eventTimeout)
+	for {
+		select {
+		case e := <-w.Events:
+			if e.Name == name {
+				return e
+			}
+		case err := <-w.Errors:
+			t.Fatalf("unexpected error: %s", err)
+		case <-timeout:
+			t.Fatalf("timed out waiting for an event on %s", name)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWatcher()
+	if err != nil {
+		t.Fatalf("unexpected error creating the watcher: %s", err)
+	}
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		t.Fatalf("unexpected error watching %s: %s", dir, err)
+	}
+
+	path := filepath.Join(dir, "test.cil")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, w, path); e.Op != Create {
+		t.Fatalf("expected the file to be created, got: %s", e)
+	}
+	if _, err := f.WriteString("(type test_t)"); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, w, path); e.Op != Write {
+		t.Fatalf("expected the file to be written, got: %s", e)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, w, path); e.Op != CloseWrite {
+		t.Fatalf("expected the file to be closed after writing, got: %s", e)
+	}
+
+	renamed := filepath.Join(dir, "renamed.cil")
+	if err := os.Rename(path, renamed); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, w, path); e.Op != Rename {
+		t.Fatalf("expected the file to be moved away, got: %s", e)
+	}
+	if e := nextEvent(t, w, renamed); e.Op != MovedTo {
+		t.Fatalf("expected the file to be moved in, got: %s", e)
+	}
+
+	if err := os.Remove(renamed); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, w, renamed); e.Op != Remove {
+		t.Fatalf("expected the file to be removed, got: %s", e)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error closing the watcher: %s", err)
+	}
+	select {
+	case _, ok := <-w.Events:
+		if ok {
+			t.Fatalf("expected the events channel to be closed")
+		}
+	case <-time.After(eventTimeout):
+		t.Fatalf("timed out waiting for the events channel to be closed")
+	}
+}
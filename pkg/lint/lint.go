@@ -0,0 +1,298 @@
+// Package lint checks policy files against the rules that selinuxd applies
+// when installing them. It doesn't need SELinux on the machine it runs on.
+package lint
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containers/selinuxd/pkg/admission"
+	"github.com/containers/selinuxd/pkg/cil"
+	"github.com/containers/selinuxd/pkg/signature"
+	"github.com/containers/selinuxd/pkg/utils"
+)
+
+// Severity tells whether a finding would make selinuxd refuse the policy
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// The identifiers of the rules that findings are reported for
+const (
+	RuleExtension     = "extension"
+	RuleModuleName    = "module-name"
+	RuleRead          = "read"
+	RuleSyntax        = "syntax"
+	RuleDuplicateName = "duplicate-name"
+	RuleSize          = "size"
+	RuleAdmission     = "admission"
+	RuleSignature     = "signature"
+)
+
+// Rules describes each of the rules that findings are reported for
+var Rules = map[string]string{
+	RuleExtension:     "The file must have a policy extension",
+	RuleModuleName:    "The module name must be accepted by semodule",
+	RuleRead:          "The file must be readable, and decompress if it's compressed",
+	RuleSyntax:        "CIL policies must be syntactically valid",
+	RuleDuplicateName: "Each module name must be provided by a single file per priority",
+	RuleSize:          "Policies must not exceed the size that selinuxd reads",
+	RuleAdmission:     "Policies must pass the admission rules",
+	RuleSignature:     "Policies, and their file contexts and interfaces, must be signed by a trusted key",
+}
+
+// ModuleDir is a directory that the daemon installs policies from, and the
+// priority it installs them at
+type ModuleDir struct {
+	Path     string
+	Priority uint16
+}
+
+// Options configure the checks
+type Options struct {
+	// Admission are the rules that the daemon admits policies with, if any
+	Admission *admission.Rules
+	// Keys are the keys that the daemon trusts the signatures of, if any
+	Keys *signature.KeySet
+	// ModuleDirs are the directories that the daemon installs policies
+	// from. Files with the same module name are only duplicates if they're
+	// installed at the same priority, as the higher one overrides the
+	// other otherwise. Files outside of them, or all the files if there
+	// are none, are installed at the priority of the first one.
+	ModuleDirs []ModuleDir
+}
+
+// DefaultOptions returns the options used when none are configured
+func DefaultOptions() Options {
+	return Options{}
+}
+
+// Finding is a problem found in a policy file
+type Finding struct {
+	Path     string   `json:"path"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// HasErrors tells whether any of the findings is an error
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Lint checks the policy files in `paths`. Directories are checked
+// recursively, skipping the files that selinuxd ignores too.
+func Lint(paths []string, opts Options) ([]Finding, error) {
+	l := &linter{opts: opts, findings: []Finding{}, names: make(map[string][]string)}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("linting %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			l.lintFile(path, true)
+			continue
+		}
+		if err := l.lintDir(path); err != nil {
+			return nil, err
+		}
+	}
+	l.checkDuplicates()
+
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		a, b := l.findings[i], l.findings[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return l.findings, nil
+}
+
+type linter struct {
+	opts     Options
+	findings []Finding
+	// names maps module names to the files that provide them
+	names map[string][]string
+}
+
+func (l *linter) report(path string, pos cil.Pos, rule string, severity Severity, format string, args ...any) {
+	l.findings = append(l.findings, Finding{
+		Path:     path,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Rule:     rule,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) lintDir(dir string) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && utils.IsBookkeepingEntry(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || utils.IsTemporaryFile(path) || utils.IsCompanionFile(path) {
+			return nil
+		}
+		l.lintFile(path, false)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("linting %s: %w", dir, err)
+	}
+	return nil
+}
+
+// lintFile checks a single file. Files with an unknown extension are
+// errors if they were explicitly requested, as selinuxd would ignore them.
+func (l *linter) lintFile(path string, explicit bool) {
+	name, err := utils.PolicyNameFromPath(path)
+	if err != nil {
+		severity := SeverityWarning
+		if explicit {
+			severity = SeverityError
+		}
+		l.report(path, cil.Pos{}, RuleExtension, severity, "%s", err)
+		return
+	}
+	l.names[name] = append(l.names[name], path)
+	if err := utils.ValidateModuleName(name); err != nil {
+		l.report(path, cil.Pos{}, RuleModuleName, SeverityError, "%s", err)
+	}
+	l.checkSignatures(path)
+
+	data, err := utils.ReadPolicy(path)
+	if errors.Is(err, utils.ErrPolicyTooLarge) {
+		l.report(path, cil.Pos{}, RuleSize, SeverityError, "%s", err)
+		return
+	} else if err != nil {
+		l.report(path, cil.Pos{}, RuleRead, SeverityError, "%s", err)
+		return
+	}
+	if utils.PolicyLanguage(path) == "cil" {
+		l.lintCIL(path, data)
+	} else if l.opts.Admission != nil && !l.opts.Admission.AllowUnchecked {
+		l.report(path, cil.Pos{}, RuleAdmission, SeverityError,
+			"%s: the admission rules can only be checked on CIL policies", admission.CheckUncheckedFormat)
+	}
+}
+
+// checkSignatures checks the detached signatures of the policy file, and
+// of the file contexts and interfaces of type-enforcement sources, as the
+// daemon does
+func (l *linter) checkSignatures(path string) {
+	if l.opts.Keys == nil {
+		return
+	}
+	signed := []string{path}
+	if utils.IsSourcePolicy(path) {
+		for _, companion := range []string{utils.FileContextsPath(path), utils.InterfacePath(path)} {
+			if _, err := os.Stat(companion); err == nil {
+				signed = append(signed, companion)
+			}
+		}
+	}
+	for _, p := range signed {
+		if _, err := l.opts.Keys.VerifyFile(p); err != nil {
+			l.report(p, cil.Pos{}, RuleSignature, SeverityError, "%s", err)
+		}
+	}
+}
+
+func (l *linter) lintCIL(path string, data []byte) {
+	stmts, err := cil.Validate(data)
+	var serr *cil.SyntaxError
+	if errors.As(err, &serr) {
+		l.report(path, serr.Pos, RuleSyntax, SeverityError, "%s", serr.Msg)
+		return
+	} else if err != nil {
+		l.report(path, cil.Pos{}, RuleSyntax, SeverityError, "%s", err)
+		return
+	}
+
+	if l.opts.Admission != nil {
+		for _, v := range l.opts.Admission.CheckCIL(stmts) {
+			l.report(path, v.Pos, RuleAdmission, SeverityError, "%s: %s", v.Check, v.Statement)
+		}
+	}
+}
+
+// checkDuplicates reports the files that provide the same module at the
+// same priority. selinuxd only installs one of them, and refuses the rest.
+func (l *linter) checkDuplicates() {
+	for name, paths := range l.names {
+		if len(paths) < 2 {
+			continue
+		}
+		for _, path := range paths {
+			priority := l.priorityOf(path)
+			others := make([]string, 0, len(paths)-1)
+			for _, other := range paths {
+				if other != path && l.priorityOf(other) == priority {
+					others = append(others, other)
+				}
+			}
+			if len(others) == 0 {
+				continue
+			}
+			l.report(path, cil.Pos{}, RuleDuplicateName, SeverityError,
+				"module '%s' is also provided by %s", name, strings.Join(others, ", "))
+		}
+	}
+}
+
+// priorityOf returns the priority that the daemon installs the file in
+// `path` at, as the daemon does: the one of the innermost module directory
+// that holds it.
+func (l *linter) priorityOf(path string) uint16 {
+	if len(l.opts.ModuleDirs) == 0 {
+		return 0
+	}
+	priority := l.opts.ModuleDirs[0].Priority
+	longest := -1
+	for _, md := range l.opts.ModuleDirs {
+		dir, err := filepath.Abs(md.Path)
+		if err != nil || !isWithin(dir, path) || len(dir) <= longest {
+			continue
+		}
+		priority = md.Priority
+		longest = len(dir)
+	}
+	return priority
+}
+
+func isWithin(dir, path string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,258 @@
+package lint
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/selinuxd/pkg/admission"
+	"github.com/containers/selinuxd/pkg/signature"
+	"github.com/containers/selinuxd/pkg/utils"
+)
+
+func writeFile(t *testing.T, path, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	valid := writeFile(t, filepath.Join(dir, "valid.cil"), "(type valid_t)\n")
+	broken := writeFile(t, filepath.Join(dir, "broken.cil"), "(type broken_t)\n(type other_t\n")
+	permissive := writeFile(t, filepath.Join(dir, "permissive.cil"),
+		"(block b\n    (type b_t)\n    (typepermissive b_t))\n")
+	badName := writeFile(t, filepath.Join(dir, "1st.cil"), "(type first_t)\n")
+	dupA := writeFile(t, filepath.Join(dir, "teamA", "web.cil"), "(type web_t)\n")
+	dupB := writeFile(t, filepath.Join(dir, "teamB", "web.cil"), "(type web_t)\n")
+	readme := writeFile(t, filepath.Join(dir, "README.md"), "# Policies\n")
+	writeFile(t, filepath.Join(dir, "valid.cil.swp"), "")
+	writeFile(t, filepath.Join(dir, "source.te"), "module source 1.0;\n")
+	writeFile(t, filepath.Join(dir, "source.fc"), "/srv/source -- gen_context(system_u:object_r:source_t,s0)\n")
+	writeFile(t, filepath.Join(dir, "valid.cil.sig"), "signature")
+	// The entries that Kubernetes uses to swap the contents of a volume
+	writeFile(t, filepath.Join(dir, "..2021_01_01", "valid.cil"), "(type valid_t)\n")
+	writeFile(t, filepath.Join(dir, "..data"), "")
+
+	findings, err := Lint([]string{dir}, DefaultOptions())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []Finding{
+		{Path: badName, Rule: RuleModuleName, Severity: SeverityError},
+		{Path: readme, Rule: RuleExtension, Severity: SeverityWarning},
+		{Path: broken, Line: 2, Column: 1, Rule: RuleSyntax, Severity: SeverityError},
+		{Path: dupA, Rule: RuleDuplicateName, Severity: SeverityError},
+		{Path: dupB, Rule: RuleDuplicateName, Severity: SeverityError},
+	}
+	if len(findings) != len(expected) {
+		t.Fatalf("expected %d findings, got: %+v", len(expected), findings)
+	}
+	for i, f := range findings {
+		e := expected[i]
+		if f.Path != e.Path || f.Line != e.Line || f.Column != e.Column || f.Rule != e.Rule || f.Severity != e.Severity {
+			t.Errorf("expected finding %+v, got: %+v", e, f)
+		}
+		if f.Path == valid || f.Path == permissive {
+			t.Errorf("expected no findings for the valid policies, got: %+v", f)
+		}
+	}
+	if !HasErrors(findings) {
+		t.Errorf("expected the findings to have errors")
+	}
+
+	t.Run("A file with an unknown extension is an error if requested explicitly", func(t *testing.T) {
+		findings, err := Lint([]string{readme, valid}, DefaultOptions())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(findings) != 1 || findings[0].Severity != SeverityError || findings[0].Rule != RuleExtension {
+			t.Fatalf("expected a single extension error, got: %+v", findings)
+		}
+	})
+
+	t.Run("The admission rules are applied if given", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Admission = &admission.Rules{ForbiddenTemplates: []string{"unconfined_template"}}
+		inherit := writeFile(t, filepath.Join(t.TempDir(), "inherit.cil"),
+			"(block b\n\t(blockinherit unconfined_template))\n")
+		module := writeFile(t, filepath.Join(t.TempDir(), "module.pp"), "binary")
+		findings, err := Lint([]string{inherit, module}, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(findings) != 2 {
+			t.Fatalf("expected two findings, got: %+v", findings)
+		}
+		for _, f := range findings {
+			if f.Rule != RuleAdmission || f.Severity != SeverityError {
+				t.Errorf("expected an admission error, got: %+v", f)
+			}
+		}
+	})
+
+	t.Run("Forbidden statements are checked with the admission rules", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Admission = &admission.Rules{ForbiddenStatements: []string{"typepermissive"}}
+		findings, err := Lint([]string{permissive}, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(findings) != 1 || findings[0].Rule != RuleAdmission || findings[0].Line != 3 || findings[0].Column != 5 {
+			t.Fatalf("expected a forbidden statement, got: %+v", findings)
+		}
+	})
+
+	t.Run("Signatures are checked if keys are given", func(t *testing.T) {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keysDir := t.TempDir()
+		writeFile(t, filepath.Join(keysDir, "trusted.pem"),
+			string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+		keys, err := signature.LoadKeySet(keysDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sign := func(path string) {
+			t.Helper()
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, signature.Path(path), string(ed25519.Sign(priv, data)))
+		}
+
+		sigDir := t.TempDir()
+		signed := writeFile(t, filepath.Join(sigDir, "signed.cil"), "(type signed_t)\n")
+		sign(signed)
+		unsigned := writeFile(t, filepath.Join(sigDir, "unsigned.cil"), "(type unsigned_t)\n")
+		te := writeFile(t, filepath.Join(sigDir, "source.te"), "module source 1.0;\n")
+		sign(te)
+		fc := writeFile(t, filepath.Join(sigDir, "source.fc"), "/srv/source -- gen_context(system_u:object_r:source_t,s0)\n")
+
+		opts := DefaultOptions()
+		opts.Keys = keys
+		findings, err := Lint([]string{sigDir}, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(findings) != 2 {
+			t.Fatalf("expected two findings, got: %+v", findings)
+		}
+		for i, path := range []string{fc, unsigned} {
+			if findings[i].Path != path || findings[i].Rule != RuleSignature || findings[i].Severity != SeverityError {
+				t.Errorf("expected a signature error for %s, got: %+v", path, findings[i])
+			}
+		}
+	})
+
+	t.Run("Policies over the size that selinuxd reads are errors", func(t *testing.T) {
+		// Concatenated gzip members decompress as one stream
+		const chunk = 1 << 20
+		var member bytes.Buffer
+		zw := gzip.NewWriter(&member)
+		if _, err := zw.Write(make([]byte, chunk)); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		big := writeFile(t, filepath.Join(t.TempDir(), "big.cil.gz"),
+			strings.Repeat(member.String(), utils.MaxPolicySize/chunk+1))
+		findings, err := Lint([]string{big}, DefaultOptions())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(findings) != 1 || findings[0].Rule != RuleSize || findings[0].Severity != SeverityError {
+			t.Fatalf("expected a size error, got: %+v", findings)
+		}
+	})
+
+	t.Run("Duplicates are only reported at the same priority", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.ModuleDirs = []ModuleDir{
+			{Path: filepath.Join(dir, "teamA"), Priority: 400},
+			{Path: filepath.Join(dir, "teamB"), Priority: 200},
+		}
+		findings, err := Lint([]string{dupA, dupB}, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(findings) != 0 {
+			t.Fatalf("expected the override not to be reported, got: %+v", findings)
+		}
+
+		opts.ModuleDirs[1].Priority = 400
+		findings, err = Lint([]string{dupA, dupB}, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(findings) != 2 || findings[0].Rule != RuleDuplicateName || findings[1].Rule != RuleDuplicateName {
+			t.Fatalf("expected the duplicates to be reported, got: %+v", findings)
+		}
+	})
+
+	t.Run("A valid policy has no findings", func(t *testing.T) {
+		findings, err := Lint([]string{valid}, DefaultOptions())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(findings) != 0 || HasErrors(findings) {
+			t.Fatalf("expected no findings, got: %+v", findings)
+		}
+	})
+}
+
+func TestWriteSARIF(t *testing.T) {
+	findings := []Finding{
+		{Path: "policies/broken.cil", Line: 2, Column: 1, Rule: RuleSyntax, Severity: SeverityError, Message: "oops"},
+		{Path: "policies/README.md", Rule: RuleExtension, Severity: SeverityWarning, Message: "not a policy"},
+	}
+	var buf bytes.Buffer
+	if err := WriteSARIF(&buf, findings); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatalf("unable to decode the SARIF log: %s", err)
+	}
+	if log.Version != sarifVersion || len(log.Runs) != 1 {
+		t.Fatalf("unexpected SARIF log: %s", buf.String())
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != len(Rules) || len(run.Results) != len(findings) {
+		t.Fatalf("unexpected SARIF run: %s", buf.String())
+	}
+	syntax := run.Results[0]
+	if syntax.RuleID != RuleSyntax || syntax.Level != "error" || syntax.Message.Text != "oops" {
+		t.Errorf("unexpected result: %+v", syntax)
+	}
+	region := syntax.Locations[0].PhysicalLocation.Region
+	if region == nil || region.StartLine != 2 || region.StartColumn != 1 {
+		t.Errorf("unexpected region: %+v", region)
+	}
+	if run.Results[1].Locations[0].PhysicalLocation.Region != nil {
+		t.Errorf("expected no region for findings without a position")
+	}
+}
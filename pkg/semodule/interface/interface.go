@@ -21,6 +21,8 @@ var (
 	ErrCannotRemoveModule = errors.New("cannot remove module")
 	// ErrCannotInstallModule is an error installing a SELinux module
 	ErrCannotInstallModule = errors.New("cannot install module")
+	// ErrCannotExtractModule is an error extracting a SELinux module
+	ErrCannotExtractModule = errors.New("cannot extract module")
 	// ErrCommit is an error when committing the changes to the SELinux policy
 	ErrCommit = errors.New("cannot commit changes to policy")
 )
@@ -33,17 +35,42 @@ func NewErrCannotInstallModule(mName string) error {
 	return fmt.Errorf("%w: %s", ErrCannotInstallModule, mName)
 }
 
+func NewErrCannotExtractModule(mName string) error {
+	return fmt.Errorf("%w: %s", ErrCannotExtractModule, mName)
+}
+
 func NewErrCommit(origErrVal int, msg string) error {
 	return fmt.Errorf("%w - error code: %d. message: %s", ErrCommit, origErrVal, msg)
 }
 
+// DefaultPriority is the priority that selinuxd installs modules at, unless
+// configured otherwise
+const DefaultPriority uint16 = 350
+
+// ModuleInfo describes a module installed in the system
+type ModuleInfo struct {
+	Name     string
+	Priority uint16
+	// Language is the language the module was written in, e.g. `cil` or `pp`
+	Language string
+	Enabled  bool
+}
+
 // Handler implements an interface to interact
 // with SELinux modules.
 type Handler interface {
+	// Name returns the name of the back end, e.g. `semanage`
+	Name() string
 	SetAutoCommit(bool)
-	Install(string) error
-	List() ([]string, error)
-	Remove(string) error
+	// Install installs the module in the given file at the given priority
+	Install(modulePath string, priority uint16) error
+	// List returns the modules installed at every priority
+	List() ([]ModuleInfo, error)
+	// Remove removes the module with the given name from the given priority
+	Remove(moduleName string, priority uint16) error
+	// Extract returns the module with the given name installed at the
+	// given priority, in the language it was installed from
+	Extract(moduleName string, priority uint16) ([]byte, error)
 	Commit() error
 	Close() error
 }
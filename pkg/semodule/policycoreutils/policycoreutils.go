@@ -5,7 +5,11 @@ package policycoreutils
 
 import (
 	"context"
+	"errors"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/containers/selinuxd/pkg/semodule/interface"
@@ -13,57 +17,133 @@ import (
 )
 
 type SEModulePcuHandler struct {
-	logger logr.Logger
+	logger     logr.Logger
+	autoCommit bool
+	// pending holds the semodule arguments of the operations that the
+	// next commit applies, while autoCommit is off
+	pending []string
+	// stageDir holds copies of the modules that are pending installation,
+	// as their files might be gone by the time they're committed
+	stageDir string
 }
 
 // Ensure that the test handler implements the Handler interface
 var _ seiface.Handler = &SEModulePcuHandler{}
 
 func runSemodule(opFlag string, policyArgs ...string) (string, error) {
+	return runSemoduleIn("", opFlag, policyArgs...)
+}
+
+// runSemoduleIn runs semodule in the given working directory, which is
+// where it writes extracted modules to
+func runSemoduleIn(dir, opFlag string, policyArgs ...string) (string, error) {
 	fullArgs := []string{opFlag}
 	fullArgs = append(fullArgs, policyArgs...)
 	cmd := exec.CommandContext(context.TODO(), "/usr/sbin/semodule", fullArgs...)
+	cmd.Dir = dir
 	out, err := cmd.CombinedOutput()
 	return string(out), err
 }
 
-func NewSEModulePcuHandler(logger logr.Logger) (*SEModulePcuHandler, error) {
-	return &SEModulePcuHandler{logger: logger}, nil
+// NewSEModulePcuHandler creates a handler that runs semodule. If
+// `autoCommit` is off, installs and removals are queued until Commit is
+// called, which applies them with a single semodule call, and so in a
+// single transaction.
+func NewSEModulePcuHandler(autoCommit bool, logger logr.Logger) (*SEModulePcuHandler, error) {
+	return &SEModulePcuHandler{logger: logger, autoCommit: autoCommit}, nil
+}
+
+func (smt *SEModulePcuHandler) Name() string {
+	return "policycoreutils"
 }
 
-func (smt *SEModulePcuHandler) SetAutoCommit(_ bool) {
-	// left to policycoreutils
+func (smt *SEModulePcuHandler) SetAutoCommit(autoCommit bool) {
+	smt.autoCommit = autoCommit
 }
 
-func (smt *SEModulePcuHandler) Install(modulePath string) error {
-	out, err := runSemodule("-X", "350", "-i", modulePath)
+func (smt *SEModulePcuHandler) Install(modulePath string, priority uint16) error {
+	if !smt.autoCommit {
+		return smt.queueInstall(modulePath, priority)
+	}
+	out, err := runSemodule("-X", strconv.Itoa(int(priority)), "-i", modulePath)
 	if err != nil {
 		smt.logger.Error(err, "Installing policy", "modulePath", modulePath, "output", out)
 		return seiface.NewErrCannotInstallModule(modulePath)
 	}
 
-	smt.logger.Info("Installing policy", "modulePath", modulePath, "output", out)
+	smt.logger.Info("Installing policy", "modulePath", modulePath, "priority", priority, "output", out)
 	return nil
 }
 
-func (smt *SEModulePcuHandler) List() ([]string, error) {
+// List parses the output of `semodule -lfull`, which has a line per module
+// in the format `<priority> <name> <language> [disabled]`
+func (smt *SEModulePcuHandler) List() ([]seiface.ModuleInfo, error) {
 	out, err := runSemodule("-lfull")
 	if err != nil {
 		smt.logger.Error(err, "Listing policies")
 		return nil, seiface.ErrList
 	}
-	modules := make([]string, 0)
-	for _, line := range strings.Split(string(out), "\n") {
-		module := strings.Split(line, " ")
-		if module[0] == "350" {
-			modules = append(modules, module[1])
+	modules := make([]seiface.ModuleInfo, 0)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		priority, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			continue
 		}
+		modules = append(modules, seiface.ModuleInfo{
+			Name:     fields[1],
+			Priority: uint16(priority),
+			Language: fields[2],
+			Enabled:  len(fields) < 4 || fields[3] != "disabled",
+		})
 	}
 	return modules, nil
 }
 
-func (smt *SEModulePcuHandler) Remove(modToRemove string) error {
-	out, err := runSemodule("-X", "350", "-r", modToRemove)
+// queueInstall copies the module, so that it's installed as it is now once
+// the pending operations are committed
+func (smt *SEModulePcuHandler) queueInstall(modulePath string, priority uint16) error {
+	if smt.stageDir == "" {
+		dir, err := os.MkdirTemp("", "selinuxd-pending-")
+		if err != nil {
+			smt.logger.Error(err, "Creating directory to queue policy in", "modulePath", modulePath)
+			return seiface.NewErrCannotInstallModule(modulePath)
+		}
+		smt.stageDir = dir
+	}
+	// semodule names the module after its file, so each copy gets its
+	// own directory
+	dir, err := os.MkdirTemp(smt.stageDir, "")
+	if err != nil {
+		smt.logger.Error(err, "Creating directory to queue policy in", "modulePath", modulePath)
+		return seiface.NewErrCannotInstallModule(modulePath)
+	}
+	data, err := os.ReadFile(modulePath)
+	if err != nil {
+		smt.logger.Error(err, "Reading policy", "modulePath", modulePath)
+		return seiface.NewErrCannotInstallModule(modulePath)
+	}
+	staged := filepath.Join(dir, filepath.Base(modulePath))
+	if err := os.WriteFile(staged, data, 0o600); err != nil {
+		smt.logger.Error(err, "Queueing policy", "modulePath", modulePath)
+		return seiface.NewErrCannotInstallModule(modulePath)
+	}
+
+	smt.pending = append(smt.pending, "-X", strconv.Itoa(int(priority)), "-i", staged)
+	smt.logger.Info("Queued policy install", "modulePath", modulePath, "priority", priority)
+	return nil
+}
+
+func (smt *SEModulePcuHandler) Remove(modToRemove string, priority uint16) error {
+	if !smt.autoCommit {
+		smt.pending = append(smt.pending, "-X", strconv.Itoa(int(priority)), "-r", modToRemove)
+		smt.logger.Info("Queued policy removal", "modToRemove", modToRemove, "priority", priority)
+		return nil
+	}
+	out, err := runSemodule("-X", strconv.Itoa(int(priority)), "-r", modToRemove)
 	if err != nil {
 		smt.logger.Error(err, "Removing a policy", "modToRemove", modToRemove, "output", out)
 		return seiface.NewErrCannotRemoveModule(modToRemove)
@@ -73,10 +153,75 @@ func (smt *SEModulePcuHandler) Remove(modToRemove string) error {
 	return nil
 }
 
+// Extract runs `semodule -E`, which writes the module to
+// `<name>.<language>` in the working directory
+func (smt *SEModulePcuHandler) Extract(moduleName string, priority uint16) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "selinuxd-extract-")
+	if err != nil {
+		smt.logger.Error(err, "Creating directory to extract policy to", "moduleName", moduleName)
+		return nil, seiface.NewErrCannotExtractModule(moduleName)
+	}
+	defer os.RemoveAll(dir)
+
+	out, err := runSemoduleIn(dir, "-X", strconv.Itoa(int(priority)), "-E", moduleName)
+	if err != nil {
+		smt.logger.Error(err, "Extracting policy", "moduleName", moduleName, "output", out)
+		return nil, seiface.NewErrCannotExtractModule(moduleName)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, moduleName+".*"))
+	if err != nil || len(matches) != 1 {
+		smt.logger.Info("Unexpected output extracting policy", "moduleName", moduleName, "files", matches)
+		return nil, seiface.NewErrCannotExtractModule(moduleName)
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		smt.logger.Error(err, "Reading extracted policy", "moduleName", moduleName)
+		return nil, seiface.NewErrCannotExtractModule(moduleName)
+	}
+	return data, nil
+}
+
+// Close discards the operations that weren't committed
 func (smt *SEModulePcuHandler) Close() error {
+	smt.discardPending()
 	return nil
 }
 
+// Commit applies the pending operations with a single semodule call.
+// semodule applies the operations in order, in one transaction, so none
+// of them is applied if any fails.
 func (smt *SEModulePcuHandler) Commit() error {
+	if len(smt.pending) == 0 {
+		return nil
+	}
+	args := smt.pending
+	defer smt.discardPending()
+
+	out, err := runSemodule(args[0], args[1:]...)
+	if err != nil {
+		smt.logger.Error(err, "Committing policy operations", "output", out)
+		code := -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		}
+		return seiface.NewErrCommit(code, out)
+	}
+
+	smt.logger.Info("Committed policy operations", "output", out)
 	return nil
 }
+
+// discardPending forgets the pending operations, and removes the copies
+// of the modules they'd install
+func (smt *SEModulePcuHandler) discardPending() {
+	smt.pending = nil
+	if smt.stageDir == "" {
+		return
+	}
+	if err := os.RemoveAll(smt.stageDir); err != nil {
+		smt.logger.Error(err, "Removing queued policies", "dir", smt.stageDir)
+	}
+	smt.stageDir = ""
+}
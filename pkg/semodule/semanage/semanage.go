@@ -8,6 +8,7 @@ package semanage
 #cgo LDFLAGS: -L/usr/lib64 -lsemanage -lsepol
 #include <semanage.h>
 #include <stdlib.h>
+#include <sys/mman.h>
 
 void wrap_set_cb(semanage_handle_t *handle, void *arg);
 
@@ -17,6 +18,10 @@ import "C"
 import (
 	"bytes"
 	"github.com/containers/selinuxd/pkg/semodule/interface"
+	"github.com/containers/selinuxd/pkg/utils"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"unsafe"
 
@@ -89,28 +94,53 @@ func NewSemanageHandler(autoCommit bool, logger logr.Logger) (seiface.Handler, e
 	}, nil
 }
 
+// Name returns the name of the back end
+func (sm *SeHandler) Name() string {
+	return "semanage"
+}
+
 // SetAutoCommit set's the `autoCommit` property in the handler
 func (sm *SeHandler) SetAutoCommit(autoCommit bool) {
 	sm.autoCommit = autoCommit
 }
 
-func (sm *SeHandler) getNthModName(n int, modInfoList *C.semanage_module_info_t) string {
+// getNthModInfo returns the information of the nth module of the list. It
+// returns false if the module's name or priority can't be read.
+func (sm *SeHandler) getNthModInfo(n int, modInfoList *C.semanage_module_info_t) (seiface.ModuleInfo, bool) {
 	modInfo := C.semanage_module_list_nth(modInfoList, C.int(n))
 	if modInfo == nil {
-		return ""
+		return seiface.ModuleInfo{}, false
 	}
 	defer C.semanage_module_info_destroy(sm.handle, modInfo)
 
-	// no free seems to be required, this returns a const char
-	cName := C.semanage_module_get_name(modInfo)
-	if cName == nil {
-		return ""
+	// no free seems to be required, these are const char
+	var cName, cLang *C.char
+	var cPriority C.uint16_t
+	var cEnabled C.int
+	if C.semanage_module_info_get_name(sm.handle, modInfo, &cName) < 0 || cName == nil {
+		return seiface.ModuleInfo{}, false
+	}
+	if C.semanage_module_info_get_priority(sm.handle, modInfo, &cPriority) < 0 {
+		return seiface.ModuleInfo{}, false
 	}
 
-	return C.GoString(cName)
+	info := seiface.ModuleInfo{
+		Name:     C.GoString(cName),
+		Priority: uint16(cPriority),
+	}
+	// The language and the enabled state are informative, so failing
+	// to get them isn't fatal
+	if C.semanage_module_info_get_lang_ext(sm.handle, modInfo, &cLang) >= 0 && cLang != nil {
+		info.Language = C.GoString(cLang)
+	}
+	if C.semanage_module_info_get_enabled(sm.handle, modInfo, &cEnabled) >= 0 {
+		info.Enabled = cEnabled == 1
+	}
+	return info, true
 }
 
-func (sm *SeHandler) List() ([]string, error) {
+// List returns the modules installed at every priority
+func (sm *SeHandler) List() ([]seiface.ModuleInfo, error) {
 	var modInfoList *C.semanage_module_info_t
 	var cNmod C.int
 
@@ -121,35 +151,41 @@ func (sm *SeHandler) List() ([]string, error) {
 	// NOTE(jaosorior): I actually don't understand the warning
 	// gocritic is issuing here...
 	// nolint:gocritic
-	rv := C.semanage_module_list(sm.handle, &modInfoList, &cNmod)
+	rv := C.semanage_module_list_all(sm.handle, &modInfoList, &cNmod)
 	if rv < 0 {
 		return nil, seiface.ErrList
 	}
 	defer C.free(unsafe.Pointer(modInfoList))
 
 	nmod := int(cNmod)
-	modNames := make([]string, 0)
+	modules := make([]seiface.ModuleInfo, 0, nmod)
 
 	for n := 0; n < nmod; n++ {
-		name := sm.getNthModName(n, modInfoList)
-		if name == "" {
+		info, ok := sm.getNthModInfo(n, modInfoList)
+		if !ok {
 			continue
 		}
-		modNames = append(modNames, name)
+		modules = append(modules, info)
 	}
 
-	return modNames, nil
+	return modules, nil
 }
 
-func (sm *SeHandler) Remove(moduleName string) error {
+// Remove removes the module from the given priority. Unlike
+// `semanage_module_remove`, which acts on the default priority of the
+// handle, the module is addressed through a key with an explicit priority.
+func (sm *SeHandler) Remove(moduleName string, priority uint16) error {
 	if sm.handle == nil {
 		return seiface.ErrNilHandle
 	}
 
-	cModName := C.CString(moduleName)
-	defer C.free(unsafe.Pointer(cModName))
+	modKey, ok := sm.newModuleKey(moduleName, priority)
+	if !ok {
+		return seiface.NewErrCannotRemoveModule(moduleName)
+	}
+	defer sm.destroyModuleKey(modKey)
 
-	rv := C.semanage_module_remove(sm.handle, cModName)
+	rv := C.semanage_module_remove_key(sm.handle, modKey)
 	if rv < 0 {
 		return seiface.NewErrCannotRemoveModule(moduleName)
 	}
@@ -160,15 +196,104 @@ func (sm *SeHandler) Remove(moduleName string) error {
 	return nil
 }
 
-func (sm *SeHandler) Install(moduleFile string) error {
+// Extract returns the module installed at the given priority, in the
+// language it was installed from
+func (sm *SeHandler) Extract(moduleName string, priority uint16) ([]byte, error) {
+	if sm.handle == nil {
+		return nil, seiface.ErrNilHandle
+	}
+
+	modKey, ok := sm.newModuleKey(moduleName, priority)
+	if !ok {
+		return nil, seiface.NewErrCannotExtractModule(moduleName)
+	}
+	defer sm.destroyModuleKey(modKey)
+
+	var data unsafe.Pointer
+	var dataLen C.size_t
+	var modInfo *C.semanage_module_info_t
+	rv := C.semanage_module_extract(sm.handle, modKey, 0, &data, &dataLen, &modInfo)
+	if rv < 0 {
+		return nil, seiface.NewErrCannotExtractModule(moduleName)
+	}
+	defer func() {
+		C.semanage_module_info_destroy(sm.handle, modInfo)
+		C.free(unsafe.Pointer(modInfo))
+	}()
+
+	if dataLen == 0 {
+		return []byte{}, nil
+	}
+	// The module is mapped from the policy store
+	defer C.munmap(data, dataLen)
+	return C.GoBytes(data, C.int(dataLen)), nil
+}
+
+// newModuleKey returns a key addressing the module at the given priority.
+// It must be released with destroyModuleKey.
+func (sm *SeHandler) newModuleKey(moduleName string, priority uint16) (*C.semanage_module_key_t, bool) {
+	var modKey *C.semanage_module_key_t
+	if C.semanage_module_key_create(sm.handle, &modKey) < 0 {
+		return nil, false
+	}
+
+	cModName := C.CString(moduleName)
+	defer C.free(unsafe.Pointer(cModName))
+
+	if C.semanage_module_key_set_name(sm.handle, modKey, cModName) < 0 ||
+		C.semanage_module_key_set_priority(sm.handle, modKey, C.uint16_t(priority)) < 0 {
+		sm.destroyModuleKey(modKey)
+		return nil, false
+	}
+	return modKey, true
+}
+
+func (sm *SeHandler) destroyModuleKey(modKey *C.semanage_module_key_t) {
+	C.semanage_module_key_destroy(sm.handle, modKey)
+	C.free(unsafe.Pointer(modKey))
+}
+
+// Install installs the module at the given priority. Unlike
+// `semanage_module_install_file`, which uses the default priority of the
+// handle, the module's name, language and priority are set explicitly.
+func (sm *SeHandler) Install(moduleFile string, priority uint16) error {
 	if sm.handle == nil {
 		return seiface.ErrNilHandle
 	}
 
-	cModFile := C.CString(moduleFile)
-	defer C.free(unsafe.Pointer(cModFile))
+	moduleName, err := utils.PolicyNameFromPath(moduleFile)
+	if err != nil {
+		return seiface.NewErrCannotInstallModule(moduleFile)
+	}
+	data, err := os.ReadFile(moduleFile)
+	if err != nil || len(data) == 0 {
+		return seiface.NewErrCannotInstallModule(moduleFile)
+	}
+
+	var modInfo *C.semanage_module_info_t
+	if C.semanage_module_info_create(sm.handle, &modInfo) < 0 {
+		return seiface.NewErrCannotInstallModule(moduleFile)
+	}
+	defer func() {
+		C.semanage_module_info_destroy(sm.handle, modInfo)
+		C.free(unsafe.Pointer(modInfo))
+	}()
+
+	cModName := C.CString(moduleName)
+	defer C.free(unsafe.Pointer(cModName))
+	cLang := C.CString(strings.TrimPrefix(filepath.Ext(moduleFile), "."))
+	defer C.free(unsafe.Pointer(cLang))
+
+	if C.semanage_module_info_set_name(sm.handle, modInfo, cModName) < 0 ||
+		C.semanage_module_info_set_lang_ext(sm.handle, modInfo, cLang) < 0 ||
+		C.semanage_module_info_set_priority(sm.handle, modInfo, C.uint16_t(priority)) < 0 {
+		return seiface.NewErrCannotInstallModule(moduleFile)
+	}
+
+	cData := C.CBytes(data)
+	defer C.free(cData)
 
-	rv := C.semanage_module_install_file(sm.handle, cModFile)
+	rv := C.semanage_module_install_info(sm.handle, modInfo, (*C.char)(cData), C.size_t(len(data)))
 	if rv < 0 {
 		return seiface.NewErrCannotInstallModule(moduleFile)
 	}
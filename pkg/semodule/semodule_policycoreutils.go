@@ -9,6 +9,6 @@ import (
 	"github.com/go-logr/logr"
 )
 
-func NewSemoduleHandler(_ bool, logger logr.Logger) (seiface.Handler, error) {
-	return policycoreutils.NewSEModulePcuHandler(logger)
+func NewSemoduleHandler(autoCommit bool, logger logr.Logger) (seiface.Handler, error) {
+	return policycoreutils.NewSEModulePcuHandler(autoCommit, logger)
 }
@@ -1,38 +1,103 @@
 package test
 
 import (
+	"errors"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 
 	seiface "github.com/containers/selinuxd/pkg/semodule/interface"
 	"github.com/containers/selinuxd/pkg/utils"
 )
 
+var (
+	// ErrTestCommit is returned by Commit after a call to FailNextCommit
+	ErrTestCommit = errors.New("test commit failure")
+	// ErrTestInstall is returned by Install after a call to FailInstalls
+	ErrTestInstall = errors.New("test install failure")
+	// ErrTestRemove is returned by Remove after a call to FailRemovals
+	ErrTestRemove = errors.New("test remove failure")
+	// ErrTestList is returned by List after a call to FailNextList
+	ErrTestList = errors.New("test list failure")
+)
+
+type testModule struct {
+	seiface.ModuleInfo
+	data []byte
+}
+
 type SEModuleTestHandler struct {
-	modules []string
+	modules []testModule
 	mu      sync.Mutex
+	// inTransaction tells whether auto-commit is off, in which case
+	// committed holds the modules as of the last commit, which a failed
+	// commit rolls back to
+	inTransaction bool
+	committed     []testModule
+	commits       int
+	failCommit    bool
+	onCommit      func()
+	failList      bool
+	// failInstalls holds how many more installs of a module should fail
+	failInstalls map[string]int
+	// failRemovals holds how many more removals of a module should fail
+	failRemovals map[string]int
 }
 
 // Ensure that the test handler implements the Handler interface
 var _ seiface.Handler = &SEModuleTestHandler{}
 
 func NewSEModuleTestHandler() *SEModuleTestHandler {
-	return &SEModuleTestHandler{}
+	return &SEModuleTestHandler{
+		failInstalls: make(map[string]int),
+		failRemovals: make(map[string]int),
+	}
 }
 
-func (smt *SEModuleTestHandler) SetAutoCommit(bool) {
+func (smt *SEModuleTestHandler) Name() string {
+	return "test"
 }
 
-func (smt *SEModuleTestHandler) Install(modulePath string) error {
+func (smt *SEModuleTestHandler) SetAutoCommit(autoCommit bool) {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	if autoCommit {
+		smt.inTransaction = false
+		smt.committed = nil
+	} else if !smt.inTransaction {
+		smt.inTransaction = true
+		smt.committed = slices.Clone(smt.modules)
+	}
+}
+
+func (smt *SEModuleTestHandler) Install(modulePath string, priority uint16) error {
 	baseFile := filepath.Base(modulePath)
 	module := utils.GetFileWithoutExtension(baseFile)
-	// Only install module if it's not already there.
-	if smt.IsModuleInstalled(module) {
-		return nil
+	if smt.shouldFailInstall(module) {
+		return ErrTestInstall
 	}
+	// The contents are only needed by Extract, so unreadable files
+	// are installed anyway
+	data, _ := os.ReadFile(modulePath)
 	smt.mu.Lock()
 	defer smt.mu.Unlock()
-	smt.modules = append(smt.modules, module)
+	for i, mod := range smt.modules {
+		if mod.Name == module && mod.Priority == priority {
+			smt.modules[i].data = data
+			return nil
+		}
+	}
+	smt.modules = append(smt.modules, testModule{
+		ModuleInfo: seiface.ModuleInfo{
+			Name:     module,
+			Priority: priority,
+			Language: strings.TrimPrefix(filepath.Ext(baseFile), "."),
+			Enabled:  true,
+		},
+		data: data,
+	})
 	return nil
 }
 
@@ -42,30 +107,68 @@ func (smt *SEModuleTestHandler) IsModuleInstalled(module string) bool {
 	for _, mod := range smt.modules {
 		// The module had already been installed.
 		// Nothing to do
-		if mod == module {
+		if mod.Name == module {
 			return true
 		}
 	}
 	return false
 }
 
-func (smt *SEModuleTestHandler) List() ([]string, error) {
-	// Return a copy
+// ModulePriorities returns the priorities that the module is installed at
+func (smt *SEModuleTestHandler) ModulePriorities(module string) []uint16 {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	prios := make([]uint16, 0)
+	for _, mod := range smt.modules {
+		if mod.Name == module {
+			prios = append(prios, mod.Priority)
+		}
+	}
+	return prios
+}
+
+func (smt *SEModuleTestHandler) List() ([]seiface.ModuleInfo, error) {
 	smt.mu.Lock()
 	defer smt.mu.Unlock()
-	return append([]string(nil), smt.modules...), nil
+	if smt.failList {
+		smt.failList = false
+		return nil, ErrTestList
+	}
+	modules := make([]seiface.ModuleInfo, 0, len(smt.modules))
+	for _, mod := range smt.modules {
+		modules = append(modules, mod.ModuleInfo)
+	}
+	return modules, nil
 }
 
-func (smt *SEModuleTestHandler) Remove(modToRemove string) error {
+func (smt *SEModuleTestHandler) Extract(moduleName string, priority uint16) ([]byte, error) {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	for _, mod := range smt.modules {
+		if mod.Name == moduleName && mod.Priority == priority {
+			return slices.Clone(mod.data), nil
+		}
+	}
+	return nil, seiface.NewErrCannotExtractModule(moduleName)
+}
+
+func (smt *SEModuleTestHandler) Remove(modToRemove string, priority uint16) error {
 	idToRemove := -1
 	smt.mu.Lock()
 	defer smt.mu.Unlock()
+	if smt.failRemovals[modToRemove] > 0 {
+		smt.failRemovals[modToRemove]--
+		return ErrTestRemove
+	}
 	for id, mod := range smt.modules {
-		if mod == modToRemove {
+		if mod.Name == modToRemove && mod.Priority == priority {
 			idToRemove = id
 			break
 		}
 	}
+	if idToRemove < 0 {
+		return seiface.NewErrCannotRemoveModule(modToRemove)
+	}
 	smt.modules = append(smt.modules[:idToRemove], smt.modules[idToRemove+1:]...)
 	return nil
 }
@@ -75,5 +178,80 @@ func (smt *SEModuleTestHandler) Close() error {
 }
 
 func (smt *SEModuleTestHandler) Commit() error {
+	smt.mu.Lock()
+	onCommit := smt.onCommit
+	smt.onCommit = nil
+	smt.mu.Unlock()
+	if onCommit != nil {
+		onCommit()
+	}
+
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	smt.commits++
+	if smt.failCommit {
+		smt.failCommit = false
+		if smt.inTransaction {
+			smt.modules = slices.Clone(smt.committed)
+		}
+		return ErrTestCommit
+	}
+	if smt.inTransaction {
+		smt.committed = slices.Clone(smt.modules)
+	}
 	return nil
 }
+
+// FailNextCommit makes the next call to Commit fail, and roll back the
+// changes made since the last commit
+func (smt *SEModuleTestHandler) FailNextCommit() {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	smt.failCommit = true
+}
+
+// OnNextCommit calls `fn` at the start of the next call to Commit, e.g. to
+// check the state that the changes are committed in
+func (smt *SEModuleTestHandler) OnNextCommit(fn func()) {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	smt.onCommit = fn
+}
+
+// FailNextList makes the next call to List fail
+func (smt *SEModuleTestHandler) FailNextList() {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	smt.failList = true
+}
+
+// FailInstalls makes the next `times` installs of the module fail
+func (smt *SEModuleTestHandler) FailInstalls(module string, times int) {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	smt.failInstalls[module] = times
+}
+
+func (smt *SEModuleTestHandler) shouldFailInstall(module string) bool {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	if smt.failInstalls[module] == 0 {
+		return false
+	}
+	smt.failInstalls[module]--
+	return true
+}
+
+// FailRemovals makes the next `times` removals of the module fail
+func (smt *SEModuleTestHandler) FailRemovals(module string, times int) {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	smt.failRemovals[module] = times
+}
+
+// CommitCalls returns the number of times Commit was called
+func (smt *SEModuleTestHandler) CommitCalls() int {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	return smt.commits
+}
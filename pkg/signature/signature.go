@@ -0,0 +1,209 @@
+// Package signature verifies the detached signatures of policy files
+// against a set of trusted public keys.
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/containers/selinuxd/pkg/utils"
+)
+
+// Extension is appended to the name of a file to get the name of its
+// detached signature, e.g. `web.cil.sig`
+const Extension = utils.SignatureExtension
+
+var (
+	ErrNoKeys           = errors.New("no public keys found")
+	ErrInvalidKey       = errors.New("invalid public key")
+	ErrMissingSignature = errors.New("missing signature")
+	ErrInvalidSignature = errors.New("signature doesn't match any trusted key")
+)
+
+// Path returns the path of the detached signature of the file in `path`
+func Path(path string) string {
+	return path + Extension
+}
+
+type namedKey struct {
+	name string
+	key  crypto.PublicKey
+}
+
+// KeySet holds the trusted public keys, read from the PEM files in a
+// directory. It's safe to use concurrently, and to reload while in use.
+type KeySet struct {
+	dir  string
+	mu   sync.RWMutex
+	keys []namedKey
+}
+
+// LoadKeySet reads the PKIX public keys in the PEM files in `dir`. Ed25519
+// and ECDSA keys are supported.
+func LoadKeySet(dir string) (*KeySet, error) {
+	ks := &KeySet{dir: dir}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Dir returns the directory that the keys are read from
+func (ks *KeySet) Dir() string {
+	return ks.dir
+}
+
+// Reload reads the keys again, e.g. after they were rotated. The current
+// keys are kept if the new ones can't be read.
+func (ks *KeySet) Reload() error {
+	entries, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return fmt.Errorf("reading public keys: %w", err)
+	}
+	keys := make([]namedKey, 0, len(entries))
+	for _, entry := range entries {
+		// Kubernetes volumes hold the actual files in `..`-prefixed
+		// entries, and link to them
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(ks.dir, entry.Name())
+		fileKeys, err := readKeys(path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("%w in %s", ErrNoKeys, ks.dir)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].name < keys[j].name })
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	return nil
+}
+
+// readKeys reads the public keys in a PEM file. Files without any PEM
+// block are skipped.
+func readKeys(path string) ([]namedKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading public keys: %w", err)
+	}
+	keys := make([]namedKey, 0)
+	for n := 0; ; n++ {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidKey, path, err)
+		}
+		switch key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey:
+		default:
+			return nil, fmt.Errorf("%w: %s: only ed25519 and ECDSA keys are supported", ErrInvalidKey, path)
+		}
+		name := filepath.Base(path)
+		if n > 0 {
+			name = fmt.Sprintf("%s#%d", name, n)
+		}
+		keys = append(keys, namedKey{name, key})
+	}
+	return keys, nil
+}
+
+// Verify checks that `sig` is a signature of `data` made with any of the
+// trusted keys, and returns the name of the key. The signature might be
+// raw or base64 encoded. ECDSA signatures are ASN.1 encoded, over the
+// SHA-256, SHA-384 or SHA-512 digest of the data for P-256, P-384 and
+// P-521 keys respectively.
+func (ks *KeySet) Verify(data, sig []byte) (string, error) {
+	sigs := [][]byte{sig}
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig))); err == nil {
+		sigs = append(sigs, decoded)
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, nk := range ks.keys {
+		for _, s := range sigs {
+			if verify(nk.key, data, s) {
+				return nk.name, nil
+			}
+		}
+	}
+	return "", ErrInvalidSignature
+}
+
+func verify(key crypto.PublicKey, data, sig []byte) bool {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, data, sig)
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digestFor(k.Curve, data), sig)
+	}
+	return false
+}
+
+func digestFor(curve elliptic.Curve, data []byte) []byte {
+	switch curve {
+	case elliptic.P384():
+		d := sha512.Sum384(data)
+		return d[:]
+	case elliptic.P521():
+		d := sha512.Sum512(data)
+		return d[:]
+	}
+	d := sha256.Sum256(data)
+	return d[:]
+}
+
+// VerifyFile checks the detached signature of the file in `path`, and
+// returns the name of the key that made it.
+func (ks *KeySet) VerifyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading signed file: %w", err)
+	}
+	return ks.VerifyDetached(path, data)
+}
+
+// VerifyDetached checks that the detached signature of the file in `path`
+// is a signature of `data`, which was read from the file. This way, the
+// contents that are verified are the ones that are used.
+func (ks *KeySet) VerifyDetached(path string, data []byte) (string, error) {
+	sig, err := os.ReadFile(Path(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrMissingSignature, Path(path))
+	} else if err != nil {
+		return "", fmt.Errorf("reading signature: %w", err)
+	}
+	name, err := ks.Verify(data, sig)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return name, nil
+}
@@ -0,0 +1,142 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePublicKey(t *testing.T, path string, key crypto.PublicKey) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePublicKey(t, filepath.Join(dir, "ed25519.pem"), edPub)
+	writePublicKey(t, filepath.Join(dir, "ecdsa.pem"), &ecPriv.PublicKey)
+
+	ks, err := LoadKeySet(dir)
+	if err != nil {
+		t.Fatalf("unexpected error loading the keys: %s", err)
+	}
+
+	data := []byte("(type web_t)")
+	digest := sha256.Sum256(data)
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecPriv, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSig := ed25519.Sign(edPriv, data)
+
+	valid := map[string]struct {
+		sig []byte
+		key string
+	}{
+		"raw ed25519":    {edSig, "ed25519.pem"},
+		"base64 ed25519": {[]byte(base64.StdEncoding.EncodeToString(edSig) + "\n"), "ed25519.pem"},
+		"raw ECDSA":      {ecSig, "ecdsa.pem"},
+		"base64 ECDSA":   {[]byte(base64.StdEncoding.EncodeToString(ecSig)), "ecdsa.pem"},
+	}
+	for name, tc := range valid {
+		key, err := ks.Verify(data, tc.sig)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		} else if key != tc.key {
+			t.Errorf("%s: expected the signature to be made with %s, got: %s", name, tc.key, key)
+		}
+	}
+
+	if _, err := ks.Verify([]byte("(type other_t)"), edSig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected the signature of other data to be invalid, got: %v", err)
+	}
+
+	policy := filepath.Join(t.TempDir(), "web.cil")
+	if err := os.WriteFile(policy, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.VerifyFile(policy); !errors.Is(err, ErrMissingSignature) {
+		t.Errorf("expected a missing signature, got: %v", err)
+	}
+	if err := os.WriteFile(Path(policy), edSig, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.VerifyFile(policy); err != nil {
+		t.Errorf("unexpected error verifying the file: %s", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	oldPub, oldPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPub, newPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "trusted.pem")
+	writePublicKey(t, keyPath, oldPub)
+
+	ks, err := LoadKeySet(dir)
+	if err != nil {
+		t.Fatalf("unexpected error loading the keys: %s", err)
+	}
+
+	data := []byte("(type web_t)")
+	oldSig := ed25519.Sign(oldPriv, data)
+	newSig := ed25519.Sign(newPriv, data)
+
+	writePublicKey(t, keyPath, newPub)
+	if err := ks.Reload(); err != nil {
+		t.Fatalf("unexpected error reloading the keys: %s", err)
+	}
+	if _, err := ks.Verify(data, oldSig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected the rotated key not to be trusted, got: %v", err)
+	}
+	if _, err := ks.Verify(data, newSig); err != nil {
+		t.Errorf("unexpected error verifying with the new key: %s", err)
+	}
+
+	// A broken key directory keeps the current keys
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("junk")}),
+		0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Reload(); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected an invalid key, got: %v", err)
+	}
+	if _, err := ks.Verify(data, newSig); err != nil {
+		t.Errorf("expected the previous keys to be kept, got: %s", err)
+	}
+
+	if _, err := LoadKeySet(t.TempDir()); !errors.Is(err, ErrNoKeys) {
+		t.Errorf("expected an empty directory to have no keys, got: %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// policyFormat describes a kind of policy file that selinuxd accepts
+type policyFormat struct {
+	// extension is the full extension of the file, e.g. `.pp.bz2`
+	extension string
+	// language is the language of the module, e.g. `pp`
+	language string
+	// decompress wraps a reader of the file to read the module from it.
+	// It's nil for files that aren't compressed.
+	decompress func(io.Reader) (io.Reader, error)
+}
+
+func gunzip(r io.Reader) (io.Reader, error) {
+	//nolint:wrapcheck // this is wrapped by the caller
+	return gzip.NewReader(r)
+}
+
+func bunzip2(r io.Reader) (io.Reader, error) {
+	return bzip2.NewReader(r), nil
+}
+
+// policyFormats are the kinds of policy files that selinuxd accepts.
+// Compound extensions go first, so they're matched before the simple ones.
+var policyFormats = []policyFormat{
+	{".cil.gz", "cil", gunzip},
+	{".cil.bz2", "cil", bunzip2},
+	{".pp.gz", "pp", gunzip},
+	{".pp.bz2", "pp", bunzip2},
+	{".cil", "cil", nil},
+	{".pp", "pp", nil},
+	{".te", "te", nil},
+}
+
+const (
+	// SourceExtension is the extension of type-enforcement sources, which
+	// are compiled into a module package before installing them
+	SourceExtension = ".te"
+	// FileContextsExtension is the extension of the file contexts that
+	// are packaged along with the type-enforcement source of the same name
+	FileContextsExtension = ".fc"
+	// InterfaceExtension is the extension of the interfaces that are
+	// expanded while compiling the type-enforcement source of the same name
+	InterfaceExtension = ".if"
+	// SignatureExtension is appended to the name of a file to get the
+	// name of its detached signature, e.g. `web.cil.sig`
+	SignatureExtension = ".sig"
+)
+
+func formatFromPath(path string) (policyFormat, bool) {
+	for _, f := range policyFormats {
+		if strings.HasSuffix(path, f.extension) {
+			return f, true
+		}
+	}
+	return policyFormat{}, false
+}
+
+// PolicyLanguage returns the language of the module held in `path`, e.g.
+// `cil` for `.cil.gz` files, or an empty string if it's not a policy file.
+func PolicyLanguage(path string) string {
+	f, _ := formatFromPath(path)
+	return f.language
+}
+
+// IsCompressedPolicy tells whether the policy file in `path` needs to be
+// decompressed before installing it.
+func IsCompressedPolicy(path string) bool {
+	f, ok := formatFromPath(path)
+	return ok && f.decompress != nil
+}
+
+// IsSourcePolicy tells whether the policy file in `path` is a
+// type-enforcement source that needs to be compiled before installing it.
+func IsSourcePolicy(path string) bool {
+	return PolicyLanguage(path) == "te"
+}
+
+// FileContextsPath returns the path of the file contexts that go with the
+// type-enforcement source in `path`. The file might not exist.
+func FileContextsPath(path string) string {
+	return strings.TrimSuffix(path, SourceExtension) + FileContextsExtension
+}
+
+// InterfacePath returns the path of the interfaces that go with the
+// type-enforcement source in `path`. The file might not exist.
+func InterfacePath(path string) string {
+	return strings.TrimSuffix(path, SourceExtension) + InterfaceExtension
+}
+
+// IsCompanionFile tells whether the file belongs to a policy file, rather
+// than being one: file contexts, interfaces and signatures.
+func IsCompanionFile(path string) bool {
+	switch filepath.Ext(path) {
+	case FileContextsExtension, InterfaceExtension, SignatureExtension:
+		return true
+	}
+	return false
+}
+
+// PolicyChecksum returns a checksum of the files that make up the policy
+// in `path`. That's the file itself, and, for type-enforcement sources,
+// their file contexts and interfaces if there are any.
+func PolicyChecksum(path string) ([]byte, error) {
+	cs, err := Checksum(path)
+	if err != nil || !IsSourcePolicy(path) {
+		return cs, err
+	}
+	fcs, err := companionChecksum(FileContextsPath(path))
+	if err != nil {
+		return nil, err
+	}
+	ifs, err := companionChecksum(InterfacePath(path))
+	if err != nil {
+		return nil, err
+	}
+	return SourceChecksum(cs, fcs, ifs), nil
+}
+
+// companionChecksum returns the checksum of the companion file in `path`,
+// or nil if there's none
+func companionChecksum(path string) ([]byte, error) {
+	cs, err := Checksum(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return cs, err
+}
+
+// SourceChecksum combines the checksums of a type-enforcement source and
+// of its file contexts and interfaces, which are nil if there are none.
+// Sources without companion files keep the checksum of the source itself.
+func SourceChecksum(cs, fcs, ifs []byte) []byte {
+	if fcs == nil && ifs == nil {
+		return cs
+	}
+	h := sha512.New()
+	h.Write(cs)
+	h.Write(fcs)
+	if ifs != nil {
+		// The interfaces are marked, so they can't be taken for file
+		// contexts with the same contents
+		h.Write([]byte(InterfaceExtension))
+		h.Write(ifs)
+	}
+	return h.Sum(nil)
+}
+
+// MaxPolicySize is the size, in bytes, of the largest module that is read
+// from a policy file once decompressed. It keeps a small compressed file
+// from expanding into more than the daemon can hold in memory.
+const MaxPolicySize = 64 << 20
+
+var ErrPolicyTooLarge = errors.New("policy is too large")
+
+// ReadPolicy returns the module held in the policy file in `path`,
+// decompressing it if needed.
+func ReadPolicy(path string) ([]byte, error) {
+	f, ok := formatFromPath(path)
+	if !ok {
+		return nil, fmt.Errorf("reading policy %s: %w", path, ErrInvalidExtension)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy: %w", err)
+	}
+	defer file.Close()
+
+	return readModule(path, f, file)
+}
+
+// DecompressPolicy returns the module held in `data`, the contents of the
+// policy file in `path`, decompressing it if needed.
+func DecompressPolicy(path string, data []byte) ([]byte, error) {
+	f, ok := formatFromPath(path)
+	if !ok {
+		return nil, fmt.Errorf("reading policy %s: %w", path, ErrInvalidExtension)
+	}
+	return readModule(path, f, bytes.NewReader(data))
+}
+
+// readModule reads the module of format `f` from `r`, up to MaxPolicySize
+// bytes of it
+func readModule(path string, f policyFormat, r io.Reader) ([]byte, error) {
+	var err error
+	if f.decompress != nil {
+		r, err = f.decompress(r)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing policy %s: %w", path, err)
+		}
+	}
+	data, err := io.ReadAll(io.LimitReader(r, MaxPolicySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading policy %s: %w", path, err)
+	}
+	if len(data) > MaxPolicySize {
+		return nil, fmt.Errorf("%w: %s holds over %d bytes", ErrPolicyTooLarge, path, MaxPolicySize)
+	}
+	return data, nil
+}
+
+// policyNameFromFormat strips the extension of the format from the file name
+func policyNameFromFormat(path string, f policyFormat) string {
+	return strings.TrimSuffix(filepath.Base(path), f.extension)
+}
@@ -7,31 +7,97 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var (
 	ErrInvalidPath      = errors.New("invalid path")
-	ErrInvalidExtension = errors.New("file with invalid extension, valid extensions: .cil .pp")
+	ErrInvalidExtension = errors.New("invalid extension, valid extensions: .cil .pp (optionally .gz or .bz2) .te")
+	ErrTemporaryFile    = errors.New("temporary file")
+	ErrInvalidName      = errors.New("invalid module name")
 )
 
+// Suffixes and prefixes of the temporary and partial files that editors
+// and configuration management tools leave around while saving a file.
+var (
+	temporaryFileSuffixes = []string{".swp", ".swx", "~", ".tmp"}
+	temporaryFilePrefixes = []string{".#"}
+)
+
+// bookkeepingPrefix starts the names of the entries that Kubernetes uses
+// to swap the contents of ConfigMap and Secret volumes
+const bookkeepingPrefix = ".."
+
 func NewErrInvalidPath(path string) error {
 	return fmt.Errorf("%w: %s", ErrInvalidPath, path)
 }
 
+// IsBookkeepingEntry tells whether the path is one of the `..`-prefixed
+// entries that Kubernetes uses to swap the contents of a volume.
+func IsBookkeepingEntry(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), bookkeepingPrefix)
+}
+
 func GetFileWithoutExtension(filename string) string {
 	extension := filepath.Ext(filename)
 	return filename[0 : len(filename)-len(extension)]
 }
 
+// IsTemporaryFile tells whether the file is a temporary or partial
+// file, such as a vim swap file or an emacs lock file.
+func IsTemporaryFile(path string) bool {
+	baseFile := filepath.Base(path)
+	for _, suffix := range temporaryFileSuffixes {
+		if strings.HasSuffix(baseFile, suffix) {
+			return true
+		}
+	}
+	for _, prefix := range temporaryFilePrefixes {
+		if strings.HasPrefix(baseFile, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func PolicyNameFromPath(path string) (string, error) {
-	switch filepath.Ext(path) {
-	case ".cil", ".pp":
-	default:
+	if IsTemporaryFile(path) {
+		return "", fmt.Errorf("ignoring: %w", ErrTemporaryFile)
+	}
+	f, ok := formatFromPath(path)
+	if !ok {
 		return "", fmt.Errorf("ignoring: %w", ErrInvalidExtension)
 	}
-	baseFile := filepath.Base(path)
-	policy := GetFileWithoutExtension(baseFile)
-	return policy, nil
+	return policyNameFromFormat(path, f), nil
+}
+
+// ValidateModuleName checks the module name with the rules that semodule
+// applies: it must start with a letter, followed by letters, digits, `_`,
+// `-` or single dots, and it can't end with a dot.
+func ValidateModuleName(name string) error {
+	for i, c := range name {
+		switch {
+		case isLetter(c):
+		case i == 0:
+			return fmt.Errorf("%w: %s: it must start with a letter", ErrInvalidName, name)
+		case isDigit(c) || c == '_' || c == '-':
+		case c == '.' && i+1 < len(name) && name[i+1] != '.':
+		default:
+			return fmt.Errorf("%w: %s: unexpected '%c' at position %d", ErrInvalidName, name, c, i+1)
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidName)
+	}
+	return nil
+}
+
+func isLetter(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
 }
 
 // Checksum returns a checksum for a file on a given path